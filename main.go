@@ -1,7 +1,19 @@
 package main
 
 import (
+	"mcp-server-admintasks/pkg/apt"
+	"mcp-server-admintasks/pkg/dnf"
+	"mcp-server-admintasks/pkg/etcgit"
+	"mcp-server-admintasks/pkg/inventory"
+	"mcp-server-admintasks/pkg/oval"
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/repomd"
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/sbom"
+	"mcp-server-admintasks/pkg/snapper"
+	"mcp-server-admintasks/pkg/suseconnect"
 	"mcp-server-admintasks/pkg/systemctl"
+	"mcp-server-admintasks/pkg/transactionalupdate"
 	"mcp-server-admintasks/pkg/utils"
 	"mcp-server-admintasks/pkg/zypper"
 )
@@ -10,5 +22,18 @@ func main() {
 	utils.INIT(utils.Debug)
 	systemctl.INIT(utils.Test, utils.Typed)
 	zypper.INIT(utils.Test, utils.Typed)
+	transactionalupdate.INIT(utils.Test, utils.Typed)
+	snapper.INIT(utils.Test, utils.Typed)
+	etcgit.INIT(utils.Test, utils.Typed)
+	rpmdb.INIT(utils.Test, utils.Typed)
+	repomd.INIT(utils.Test, utils.Typed)
+	sbom.INIT(utils.Test, utils.Typed)
+	inventory.INIT(utils.Test, utils.Typed)
+	oval.INIT(utils.Test, utils.Typed)
+	dnf.INIT(utils.Test, utils.Typed)
+	apt.INIT(utils.Test, utils.Typed)
+	suseconnect.INIT(utils.Test, utils.Typed)
+	// after all backends, which register themselves in their INIT
+	pkgmgr.INIT(utils.Test, utils.Typed)
 	utils.RUN()
 }
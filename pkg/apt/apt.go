@@ -0,0 +1,451 @@
+package apt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+var aptDebug bool
+
+var jsonAptGetSubCmds string
+var jsonAptCacheSubCmds string
+
+const aptSourcesList = "/etc/apt/sources.list"
+const aptSourcesDir = "/etc/apt/sources.list.d"
+
+var aptDistributions = []string{"debian", "ubuntu"}
+
+var aptGetCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "apt-get",
+	Description:       "APT package handling utility of Debian and Ubuntu",
+	NeedsRootHandling: true,
+	DefaultParameters: []string{"--quiet", "--assume-yes", "-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"},
+	Distributions:     aptDistributions,
+	Environment:       []string{"DEBIAN_FRONTEND=noninteractive", "APT_LISTCHANGES_FRONTEND=none"},
+	SubCommands: map[string]utils.SingleSubCmd{
+		"update": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Retrieve new lists of packages.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			Parameters:     []string{},
+		},
+		"install": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Install packages.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"remove": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Remove packages.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"upgrade": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Perform an upgrade of all installed packages.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{},
+		},
+	},
+}
+
+var aptCacheCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "apt-cache",
+	Description:       "Query the APT package cache of Debian and Ubuntu",
+	NeedsRootHandling: false,
+	DefaultParameters: []string{"--quiet"},
+	Distributions:     aptDistributions,
+	SubCommands: map[string]utils.SingleSubCmd{
+		"search": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Search the package list for a regex pattern in name and description.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{"PATTERN / regular expression"},
+		},
+		"show": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Show full information for specified packages.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"policy": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Show installed and candidate versions and the repository priorities of a package.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{"PACKAGE name"},
+		},
+	},
+}
+
+var aptCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "apt",
+	Description:       "High level package manager of Debian and Ubuntu",
+	DefaultParameters: []string{"--quiet"},
+	Distributions:     aptDistributions,
+}
+
+var aptMarkCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:    "apt-mark",
+	Description:   "Show, set and unset various settings for a package",
+	Distributions: aptDistributions,
+}
+
+var dpkgQueryCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:    "dpkg-query",
+	Description:   "Tool to query the dpkg database",
+	Distributions: aptDistributions,
+}
+
+// aptBackend implements pkgmgr.Backend with apt-get, apt-cache, apt-mark
+// and dpkg-query.
+type aptBackend struct{}
+
+func (backend aptBackend) Name() string {
+	return "apt"
+}
+
+func (backend aptBackend) SystemCmd() utils.SystemCmd {
+	return aptGetCmd
+}
+
+// installedVersions maps package names to the installed version.
+func installedVersions(ctx context.Context, names ...string) (map[string]string, error) {
+	params := append([]string{"--show", "--showformat=${Package}\t${Version}\t${db:Status-Abbrev}\n"}, names...)
+	result, err := utils.RunSystemCmd(ctx, dpkgQueryCmd, false, "", params...)
+	if err != nil {
+		return nil, err
+	}
+	// exit code 1 only means some of names are not installed
+	versions := make(map[string]string)
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 3 && strings.HasPrefix(fields[2], "ii") {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	return versions, nil
+}
+
+func (backend aptBackend) Search(ctx context.Context, pattern string) ([]pkgmgr.Package, error) {
+	result, err := utils.RunSystemCmd(ctx, aptCacheCmd, false, "search", pattern)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("apt-cache search failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	var packages []pkgmgr.Package
+	var names []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		name, summary, found := strings.Cut(line, " - ")
+		if !found {
+			continue
+		}
+		packages = append(packages, pkgmgr.Package{Name: name, Summary: summary})
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return packages, nil
+	}
+	versions, err := installedVersions(ctx, names...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range packages {
+		if version, ok := versions[packages[i].Name]; ok {
+			packages[i].Installed = true
+			packages[i].Version = version
+		}
+	}
+	return packages, nil
+}
+
+func (backend aptBackend) Info(ctx context.Context, name string) (pkgmgr.PackageInfo, error) {
+	result, err := utils.RunSystemCmd(ctx, aptCacheCmd, false, "show", "--no-all-versions", name)
+	if err != nil {
+		return pkgmgr.PackageInfo{}, err
+	}
+	if result.ExitCode != 0 {
+		return pkgmgr.PackageInfo{}, fmt.Errorf("package %s not found", name)
+	}
+	info := pkgmgr.NewPackageInfo(pkgmgr.ParseKeyValueLines(result.Stdout))
+	if description, ok := info.Details["description"]; ok && info.Summary == "" {
+		info.Summary, _, _ = strings.Cut(description, "\n")
+	}
+	versions, err := installedVersions(ctx, name)
+	if err != nil {
+		return pkgmgr.PackageInfo{}, err
+	}
+	if version, ok := versions[name]; ok {
+		info.Installed = true
+		info.Version = version
+	}
+	return info, nil
+}
+
+func (backend aptBackend) transaction(ctx context.Context, action string, subcmd string, params []string, packages []string) (pkgmgr.TransactionResult, error) {
+	result, err := utils.RunSystemCmd(ctx, aptGetCmd, true, subcmd, append(params, packages...)...)
+	if err != nil {
+		return pkgmgr.TransactionResult{}, err
+	}
+	transactionResult := pkgmgr.TransactionResult{
+		Backend:  backend.Name(),
+		Action:   action,
+		Packages: packages,
+		ExitCode: result.ExitCode,
+		Success:  result.ExitCode == 0,
+		Output:   result.Stdout,
+	}
+	if !transactionResult.Success {
+		transactionResult.Error = strings.TrimSpace(result.Stderr)
+	}
+	// Debian and Ubuntu flag pending reboots with this file
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		transactionResult.RebootNeeded = true
+	}
+	return transactionResult, nil
+}
+
+func (backend aptBackend) Install(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "install", "install", nil, packages)
+}
+
+func (backend aptBackend) Remove(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "remove", "remove", nil, packages)
+}
+
+func (backend aptBackend) Update(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	if len(packages) == 0 {
+		return backend.transaction(ctx, "update", "upgrade", nil, packages)
+	}
+	return backend.transaction(ctx, "update", "install", []string{"--only-upgrade"}, packages)
+}
+
+// parseAptUpgradable parses the output of apt list --upgradable:
+// "openssl/jammy-updates 3.0.2-0ubuntu1.15 amd64 [upgradable from: 3.0.2-0ubuntu1.14]"
+func parseAptUpgradable(output string) []pkgmgr.Update {
+	var updates []pkgmgr.Update
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.Contains(fields[0], "/") {
+			continue
+		}
+		name, suite, _ := strings.Cut(fields[0], "/")
+		update := pkgmgr.Update{
+			Name:       name,
+			Repository: suite,
+			NewVersion: fields[1],
+			Arch:       fields[2],
+		}
+		if _, old, found := strings.Cut(line, "upgradable from: "); found {
+			update.OldVersion = strings.TrimSuffix(old, "]")
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+func (backend aptBackend) ListUpdates(ctx context.Context) ([]pkgmgr.Update, error) {
+	result, err := utils.RunSystemCmd(ctx, aptCmd, false, "list", "--upgradable")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("apt list failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	return parseAptUpgradable(result.Stdout), nil
+}
+
+// parseAptSourcesList parses one-line style sources:
+// "deb [signed-by=/usr/share/keyrings/x.gpg] http://deb.debian.org/debian bookworm main"
+func parseAptSourcesList(filePath string) ([]pkgmgr.Repository, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var repos []pkgmgr.Repository
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		enabled := true
+		if strings.HasPrefix(line, "#") {
+			// commented out sources are disabled repositories
+			line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			enabled = false
+		}
+		if !strings.HasPrefix(line, "deb ") {
+			continue
+		}
+		options := ""
+		rest := strings.TrimSpace(strings.TrimPrefix(line, "deb "))
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				continue
+			}
+			options = rest[1:end]
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 2 {
+			continue
+		}
+		repos = append(repos, pkgmgr.Repository{
+			Alias:       strings.Join(fields[1:], " "),
+			Name:        filepath.Base(filePath),
+			URL:         fields[0],
+			Enabled:     enabled,
+			Autorefresh: true,
+			GPGCheck:    !strings.Contains(options, "trusted=yes"),
+		})
+	}
+	return repos, scanner.Err()
+}
+
+// parseAptDeb822Sources parses the deb822 style .sources files.
+func parseAptDeb822Sources(filePath string) ([]pkgmgr.Repository, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var repos []pkgmgr.Repository
+	for _, stanza := range strings.Split(string(content), "\n\n") {
+		values := pkgmgr.ParseKeyValueLines(stanza)
+		if !strings.Contains(values["types"], "deb") || values["uris"] == "" {
+			continue
+		}
+		for _, uri := range strings.Fields(values["uris"]) {
+			repos = append(repos, pkgmgr.Repository{
+				Alias:       strings.TrimSpace(values["suites"] + " " + values["components"]),
+				Name:        filepath.Base(filePath),
+				URL:         uri,
+				Enabled:     values["enabled"] != "no",
+				Autorefresh: true,
+				GPGCheck:    values["trusted"] != "yes",
+			})
+		}
+	}
+	return repos, nil
+}
+
+func (backend aptBackend) ListRepos(ctx context.Context) ([]pkgmgr.Repository, error) {
+	var repos []pkgmgr.Repository
+	listFiles, _ := filepath.Glob(filepath.Join(aptSourcesDir, "*.list"))
+	if _, err := os.Stat(aptSourcesList); err == nil {
+		listFiles = append([]string{aptSourcesList}, listFiles...)
+	}
+	for _, file := range listFiles {
+		fileRepos, err := parseAptSourcesList(file)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, fileRepos...)
+	}
+	sourcesFiles, _ := filepath.Glob(filepath.Join(aptSourcesDir, "*.sources"))
+	for _, file := range sourcesFiles {
+		fileRepos, err := parseAptDeb822Sources(file)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, fileRepos...)
+	}
+	return repos, nil
+}
+
+func (backend aptBackend) ListLocks(ctx context.Context) ([]pkgmgr.Lock, error) {
+	result, err := utils.RunSystemCmd(ctx, aptMarkCmd, false, "showhold")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("apt-mark showhold failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	var locks []pkgmgr.Lock
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			locks = append(locks, pkgmgr.Lock{Name: name, Type: "hold"})
+		}
+	}
+	return locks, nil
+}
+
+func runTests() {
+	for fileName, systemCmd := range map[string]utils.SystemCmd{"apt-get.json": aptGetCmd, "apt-cache.json": aptCacheCmd} {
+		// Convert struct to JSON
+		jsonData, err := json.MarshalIndent(systemCmd, "", "  ")
+		if err != nil {
+			fmt.Println("Error marshalling JSON:", err)
+			return
+		}
+		// Write JSON to file
+		err = os.WriteFile(fileName, jsonData, 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	pkgmgr.RegisterBackend(aptBackend{})
+	tmpAptGetSubCmds, err := json.MarshalIndent(aptGetCmd.SubCommands, "", "  ")
+	if err != nil {
+		fmt.Println("Error converting to JSON:", err)
+		return
+	}
+	jsonAptGetSubCmds = string(tmpAptGetSubCmds)
+	tmpAptCacheSubCmds, err := json.MarshalIndent(aptCacheCmd.SubCommands, "", "  ")
+	if err != nil {
+		fmt.Println("Error converting to JSON:", err)
+		return
+	}
+	jsonAptCacheSubCmds = string(tmpAptCacheSubCmds)
+	switch debugMode {
+	case utils.Production:
+		aptDebug = false
+		if initMode == utils.Typed {
+			for key := range aptGetCmd.SubCommands {
+				utils.AddToolToMCPServer(aptGetCmd, jsonAptGetSubCmds, key, aptGetCmd.SubCommands[key])
+			}
+			for key := range aptCacheCmd.SubCommands {
+				utils.AddToolToMCPServer(aptCacheCmd, jsonAptCacheSubCmds, key, aptCacheCmd.SubCommands[key])
+			}
+		}
+	case utils.Debug:
+		aptDebug = true
+		if initMode == utils.Typed {
+			for key := range aptGetCmd.SubCommands {
+				utils.AddToolToMCPServer(aptGetCmd, jsonAptGetSubCmds, key, aptGetCmd.SubCommands[key])
+			}
+			for key := range aptCacheCmd.SubCommands {
+				utils.AddToolToMCPServer(aptCacheCmd, jsonAptCacheSubCmds, key, aptCacheCmd.SubCommands[key])
+			}
+		}
+	case utils.Test:
+		aptDebug = true
+		runTests()
+	}
+}
@@ -0,0 +1,374 @@
+package dnf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+var dnfDebug bool
+
+var jsonDnfSubCmds string
+
+const dnfReposDir = "/etc/yum.repos.d"
+
+// name, epoch:version-release, arch, repository, summary
+const dnfQueryFormat = "%{name}\t%{epoch}:%{version}-%{release}\t%{arch}\t%{repoid}\t%{summary}\n"
+
+var dnfCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "dnf",
+	Description:       "Package manager of Fedora, RHEL and derived distributions",
+	NeedsRootHandling: true,
+	DefaultParameters: []string{"--quiet", "--assumeyes", "--color=never"},
+	Distributions:     []string{"fedora", "rhel", "centos"},
+	SubCommands: map[string]utils.SingleSubCmd{
+		"search": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Search for packages matching a keyword in name and summary.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{"KEYWORD"},
+		},
+		"info": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Show full information for specified packages.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"repolist": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Display the configured software repositories.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{},
+		},
+		"check-update": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Check for available package upgrades.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: false,
+			Parameters:     []string{},
+		},
+		"install": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Install packages.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"remove": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Remove packages.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"upgrade": {
+			CmdGroup:       "Main Commands",
+			Summary:        "Upgrade packages to the latest available version.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{},
+		},
+		"repoquery": {
+			CmdGroup:       "Query Commands",
+			Summary:        "Search for packages matching various criteria.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: false,
+			Parameters:     []string{"PATTERN"},
+		},
+		"versionlock": {
+			CmdGroup:       "Plugin Commands",
+			Summary:        "Protect packages from updates to newer versions.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			Parameters:     []string{"SUBCOMMAND"},
+		},
+	},
+}
+
+// dnfBackend implements pkgmgr.Backend for dnf.
+type dnfBackend struct{}
+
+func (backend dnfBackend) Name() string {
+	return "dnf"
+}
+
+func (backend dnfBackend) SystemCmd() utils.SystemCmd {
+	return dnfCmd
+}
+
+// dnf prints "0:" for packages without epoch, rpm does not
+func trimZeroEpoch(version string) string {
+	return strings.TrimPrefix(version, "0:")
+}
+
+func parseDnfQueryFormat(output string) []pkgmgr.Package {
+	var packages []pkgmgr.Package
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 5 {
+			continue
+		}
+		installed := fields[3] == "@System" || fields[3] == "installed" || fields[3] == "@commandline"
+		packages = append(packages, pkgmgr.Package{
+			Name:       fields[0],
+			Version:    trimZeroEpoch(fields[1]),
+			Arch:       fields[2],
+			Repository: fields[3],
+			Summary:    fields[4],
+			Installed:  installed,
+		})
+	}
+	return packages
+}
+
+func (backend dnfBackend) Search(ctx context.Context, pattern string) ([]pkgmgr.Package, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		pattern = "*" + pattern + "*"
+	}
+	result, err := utils.RunSystemCmd(ctx, dnfCmd, false, "repoquery", "--queryformat", dnfQueryFormat, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("dnf repoquery failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	installedResult, err := utils.RunSystemCmd(ctx, dnfCmd, false, "repoquery", "--installed", "--queryformat", dnfQueryFormat, pattern)
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]bool)
+	for _, pkg := range parseDnfQueryFormat(installedResult.Stdout) {
+		installed[pkg.Name+"."+pkg.Arch+"-"+pkg.Version] = true
+	}
+	packages := parseDnfQueryFormat(result.Stdout)
+	for i := range packages {
+		if installed[packages[i].Name+"."+packages[i].Arch+"-"+packages[i].Version] {
+			packages[i].Installed = true
+		}
+	}
+	return packages, nil
+}
+
+func (backend dnfBackend) Info(ctx context.Context, name string) (pkgmgr.PackageInfo, error) {
+	result, err := utils.RunSystemCmd(ctx, dnfCmd, false, "info", name)
+	if err != nil {
+		return pkgmgr.PackageInfo{}, err
+	}
+	if result.ExitCode != 0 {
+		return pkgmgr.PackageInfo{}, fmt.Errorf("package %s not found", name)
+	}
+	info := pkgmgr.NewPackageInfo(pkgmgr.ParseKeyValueLines(result.Stdout))
+	info.Installed = info.Repository == "@System" || info.Repository == "installed" || info.Details["from_repo"] != ""
+	return info, nil
+}
+
+func (backend dnfBackend) transaction(ctx context.Context, action string, subcmd string, packages []string) (pkgmgr.TransactionResult, error) {
+	result, err := utils.RunSystemCmd(ctx, dnfCmd, true, subcmd, packages...)
+	if err != nil {
+		return pkgmgr.TransactionResult{}, err
+	}
+	transactionResult := pkgmgr.TransactionResult{
+		Backend:  backend.Name(),
+		Action:   action,
+		Packages: packages,
+		ExitCode: result.ExitCode,
+		Success:  result.ExitCode == 0,
+		Output:   result.Stdout,
+	}
+	if !transactionResult.Success {
+		transactionResult.Error = strings.TrimSpace(result.Stderr)
+	}
+	return transactionResult, nil
+}
+
+func (backend dnfBackend) Install(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "install", "install", packages)
+}
+
+func (backend dnfBackend) Remove(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "remove", "remove", packages)
+}
+
+func (backend dnfBackend) Update(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "update", "upgrade", packages)
+}
+
+func (backend dnfBackend) ListUpdates(ctx context.Context) ([]pkgmgr.Update, error) {
+	result, err := utils.RunSystemCmd(ctx, dnfCmd, false, "repoquery", "--upgrades", "--latest-limit=1", "--queryformat", dnfQueryFormat)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("dnf repoquery failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	installedResult, err := utils.RunSystemCmd(ctx, dnfCmd, false, "repoquery", "--installed", "--queryformat", dnfQueryFormat)
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]string)
+	for _, pkg := range parseDnfQueryFormat(installedResult.Stdout) {
+		installed[pkg.Name+"."+pkg.Arch] = pkg.Version
+	}
+	var updates []pkgmgr.Update
+	for _, pkg := range parseDnfQueryFormat(result.Stdout) {
+		updates = append(updates, pkgmgr.Update{
+			Name:       pkg.Name,
+			Arch:       pkg.Arch,
+			OldVersion: installed[pkg.Name+"."+pkg.Arch],
+			NewVersion: pkg.Version,
+			Repository: pkg.Repository,
+		})
+	}
+	return updates, nil
+}
+
+// parseDnfRepoFile reads the ini style .repo files of yum/dnf.
+func parseDnfRepoFile(filePath string) ([]pkgmgr.Repository, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var repos []pkgmgr.Repository
+	var current *pkgmgr.Repository
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			// dnf defaults: enabled, gpgcheck off, priority 99
+			repos = append(repos, pkgmgr.Repository{Alias: strings.Trim(line, "[]"), Enabled: true, Priority: 99})
+			current = &repos[len(repos)-1]
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || current == nil {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "name":
+			current.Name = value
+		case "baseurl", "mirrorlist", "metalink":
+			if current.URL == "" {
+				current.URL = strings.Fields(value + " ")[0]
+			}
+		case "enabled":
+			current.Enabled = value == "1" || value == "true" || value == "yes"
+		case "gpgcheck":
+			current.GPGCheck = value == "1" || value == "true" || value == "yes"
+		case "priority":
+			current.Priority, _ = strconv.Atoi(value)
+		}
+	}
+	// dnf refreshes metadata on expiry, there is no autorefresh flag
+	for i := range repos {
+		repos[i].Autorefresh = true
+	}
+	return repos, scanner.Err()
+}
+
+func (backend dnfBackend) ListRepos(ctx context.Context) ([]pkgmgr.Repository, error) {
+	files, err := filepath.Glob(filepath.Join(dnfReposDir, "*.repo"))
+	if err != nil {
+		return nil, err
+	}
+	var repos []pkgmgr.Repository
+	for _, file := range files {
+		fileRepos, err := parseDnfRepoFile(file)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, fileRepos...)
+	}
+	return repos, nil
+}
+
+func (backend dnfBackend) ListLocks(ctx context.Context) ([]pkgmgr.Lock, error) {
+	result, err := utils.RunSystemCmd(ctx, dnfCmd, false, "versionlock", "list")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("dnf versionlock failed, is the versionlock plugin installed? %s", strings.TrimSpace(result.Stderr))
+	}
+	var locks []pkgmgr.Lock
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "Last metadata") {
+			continue
+		}
+		lockType := "versionlock"
+		if strings.HasPrefix(line, "!") {
+			lockType = "exclude"
+			line = strings.TrimPrefix(line, "!")
+		}
+		locks = append(locks, pkgmgr.Lock{Name: line, Type: lockType})
+	}
+	return locks, nil
+}
+
+func runTests() {
+	// Convert struct to JSON
+	jsonData, err := json.MarshalIndent(dnfCmd, "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+		return
+	}
+	// Write JSON to file
+	err = os.WriteFile("dnf.json", jsonData, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	pkgmgr.RegisterBackend(dnfBackend{})
+	tmpDnfSubCmds, err := json.MarshalIndent(dnfCmd.SubCommands, "", "  ")
+	if err != nil {
+		fmt.Println("Error converting to JSON:", err)
+		return
+	}
+	jsonDnfSubCmds = string(tmpDnfSubCmds)
+	switch debugMode {
+	case utils.Production:
+		dnfDebug = false
+		if initMode == utils.Typed {
+			for key := range dnfCmd.SubCommands {
+				utils.AddToolToMCPServer(dnfCmd, jsonDnfSubCmds, key, dnfCmd.SubCommands[key])
+			}
+		}
+	case utils.Debug:
+		dnfDebug = true
+		if initMode == utils.Typed {
+			for key := range dnfCmd.SubCommands {
+				utils.AddToolToMCPServer(dnfCmd, jsonDnfSubCmds, key, dnfCmd.SubCommands[key])
+			}
+		}
+	case utils.Test:
+		dnfDebug = true
+		runTests()
+	}
+}
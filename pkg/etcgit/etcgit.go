@@ -0,0 +1,380 @@
+package etcgit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var etcgitDebug bool
+
+// The repository lives outside of /etc, so it does not get in the way of
+// etckeeper or of an admin managed /etc/.git.
+const defaultEtcGitDir = "/var/lib/mcp-server-admintasks/etc.git"
+const etcWorkTree = "/etc"
+
+// Trailers in the commit messages
+const (
+	trailerTool    = "MCP-Tool"
+	trailerSession = "MCP-Session"
+	trailerCall    = "MCP-Call"
+	trailerPhase   = "MCP-Phase"
+)
+
+// Files which change all the time, or belong to other repositories
+var excludedPaths = []string{".git", ".etckeeper", "mtab", "adjtime", "ld.so.cache", "*.swp", "*~"}
+
+// Files with password hashes, keys and credentials, etc_diff would hand
+// them out to the model. They are neither committed nor shown.
+var secretPaths = []string{
+	"shadow", "shadow-", "gshadow", "gshadow-", "security/opasswd",
+	"ssh/ssh_host_*_key", "zypp/credentials.d", "NetworkManager/system-connections",
+	"wpa_supplicant/*.conf", "sssd/sssd.conf", "krb5.keytab", "ppp/*-secrets",
+	"mcp-server-admintasks/regcodes.json",
+}
+
+var callIDPattern = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+
+var gitCmd utils.SystemCmd
+
+type CommitInfo struct {
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	Phase     string `json:"phase"`
+	Tool      string `json:"tool"`
+	Session   string `json:"session"`
+	CallID    string `json:"call_id"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+func newGitCmd(gitDir string) utils.SystemCmd {
+	return utils.SystemCmd{
+		Executable:        "git",
+		Description:       "Version control of /etc around mutating tool calls",
+		NeedsRootHandling: true,
+		DefaultParameters: []string{
+			"-C", etcWorkTree, "--git-dir=" + gitDir, "--work-tree=" + etcWorkTree,
+			"-c", "user.name=mcp-server-admintasks", "-c", "user.email=root@localhost",
+			"-c", "core.quotepath=off", "-c", "commit.gpgsign=false",
+		},
+		SubCommands: map[string]utils.SingleSubCmd{
+			"log": {
+				CmdGroup:       "History Commands",
+				Summary:        "List the tool calls which have been recorded in the /etc repository.",
+				Description:    "",
+				IsEnabled:      true,
+				IsRootRequired: true,
+				Parameters:     []string{},
+			},
+			"show": {
+				CmdGroup:       "History Commands",
+				Summary:        "Show the changes a single tool call made to /etc.",
+				Description:    "",
+				IsEnabled:      true,
+				IsRootRequired: true,
+				Parameters:     []string{"CALL ID"},
+			},
+		},
+	}
+}
+
+func runGit(ctx context.Context, subcmd string, params ...string) (utils.CmdResult, error) {
+	result, err := utils.RunSystemCmd(ctx, gitCmd, true, subcmd, params...)
+	if err != nil {
+		return result, err
+	}
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("git %s failed: %s", subcmd, strings.TrimSpace(result.Stderr))
+	}
+	return result, nil
+}
+
+// allSecretPaths adds the registration codes file of the server config
+// to secretPaths, if it is in /etc.
+func allSecretPaths() []string {
+	paths := append([]string{}, secretPaths...)
+	if codesFile := utils.AdminTasksConfig.RegistrationCodesFile; codesFile != "" {
+		relative, err := filepath.Rel(etcWorkTree, filepath.Clean(codesFile))
+		if err == nil && relative != "." && !strings.HasPrefix(relative, "..") {
+			paths = append(paths, relative)
+		}
+	}
+	return paths
+}
+
+func pathspec() []string {
+	params := []string{"--", "."}
+	for _, path := range append(append([]string{}, excludedPaths...), allSecretPaths()...) {
+		params = append(params, ":(exclude,glob)**/"+path, ":(exclude,glob)"+path)
+	}
+	return params
+}
+
+func secretPathspec() []string {
+	params := []string{"--"}
+	for _, path := range allSecretPaths() {
+		params = append(params, ":(glob)"+path)
+	}
+	return params
+}
+
+// initRepository creates the repository with a first commit of /etc, if
+// it does not exist yet.
+func initRepository(ctx context.Context, gitDir string) error {
+	if _, err := runGit(ctx, "rev-parse", "--git-dir"); err == nil {
+		return nil
+	}
+	// /etc contains secrets like /etc/shadow, so only root may read it
+	cmd, err := utils.NewRootCommandContext(ctx, "install", "-d", "-m", "0700", gitDir)
+	if err != nil {
+		return err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create %s: %v %s", gitDir, err, output)
+	}
+	if _, err := runGit(ctx, "init", "--quiet"); err != nil {
+		return err
+	}
+	return commitEtc(ctx, "mcp-server-admintasks: initial state of /etc", "")
+}
+
+// commitEtc commits all changes of /etc. Without changes no commit is
+// created and no error is returned.
+func commitEtc(ctx context.Context, subject string, body string) error {
+	// repositories of older versions have secret files committed
+	rmParams := append([]string{"-r", "--cached", "--quiet", "--ignore-unmatch"}, secretPathspec()...)
+	if _, err := runGit(ctx, "rm", rmParams...); err != nil {
+		return err
+	}
+	if _, err := runGit(ctx, "add", append([]string{"--all"}, pathspec()...)...); err != nil {
+		return err
+	}
+	status, err := runGit(ctx, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status.Stdout) == "" {
+		return nil
+	}
+	message := subject
+	if body != "" {
+		message += "\n\n" + body
+	}
+	_, err = runGit(ctx, "commit", "--quiet", "--no-verify", "--message", message)
+	return err
+}
+
+func headCommit(ctx context.Context) string {
+	result, err := runGit(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(result.Stdout)
+}
+
+func commitMessage(call *utils.MutatingToolCall, phase string) (string, string) {
+	var subject string
+	if phase == "pre" {
+		subject = "mcp-server-admintasks: before " + call.Tool
+	} else {
+		subject = "mcp-server-admintasks: after " + call.Tool
+	}
+	arguments, _ := json.Marshal(call.Arguments)
+	// arguments end up in a file, so mask passwords and the like
+	redactedArguments, _ := utils.RedactSecrets(string(arguments))
+	body := fmt.Sprintf("Arguments: %s\n\n%s: %s\n%s: %s\n%s: %s\n%s: %s",
+		redactedArguments,
+		trailerTool, call.Tool,
+		trailerSession, call.SessionID,
+		trailerCall, call.CallID,
+		trailerPhase, phase)
+	return subject, body
+}
+
+// etcGitHook commits /etc before and after every mutating tool call. The
+// calls are serialized by the hooks, so the commit of the "post" phase
+// contains exactly the changes of a call.
+type etcGitHook struct{}
+
+func (hook etcGitHook) Name() string {
+	return "etcgit"
+}
+
+func (hook etcGitHook) Before(ctx context.Context, call *utils.MutatingToolCall) (string, error) {
+	subject, body := commitMessage(call, "pre")
+	if err := commitEtc(ctx, subject, body); err != nil {
+		return "", err
+	}
+	call.State["etcgit_head"] = headCommit(ctx)
+	return "", nil
+}
+
+func (hook etcGitHook) After(ctx context.Context, call *utils.MutatingToolCall, result *mcp.CallToolResult) (string, error) {
+	if call.Unchanged {
+		return "", nil
+	}
+	subject, body := commitMessage(call, "post")
+	if err := commitEtc(ctx, subject, body); err != nil {
+		return "", err
+	}
+	head := headCommit(ctx)
+	if head == call.State["etcgit_head"] {
+		return "", nil
+	}
+	jsonData, err := json.Marshal(map[string]any{"etc_changes": map[string]string{"call_id": call.CallID, "commit": head, "details": "use etc_diff with this call_id"}})
+	return string(jsonData), err
+}
+
+func parseTrailers(body string) map[string]string {
+	trailers := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		key, value, found := strings.Cut(line, ": ")
+		if found && (strings.HasPrefix(key, "MCP-") || key == "Arguments") {
+			trailers[key] = strings.TrimSpace(value)
+		}
+	}
+	return trailers
+}
+
+// listCommits returns the commits of tool calls, the most recent first.
+// With callID only the commits of this call are returned.
+func listCommits(ctx context.Context, callID string, maxCount int) ([]CommitInfo, error) {
+	params := []string{"--format=%H%x1f%aI%x1f%B%x1e", fmt.Sprintf("--max-count=%d", maxCount)}
+	if callID != "" {
+		params = append(params, "--fixed-strings", "--grep="+trailerCall+": "+callID)
+	} else {
+		params = append(params, "--fixed-strings", "--grep="+trailerPhase+": post")
+	}
+	result, err := runGit(ctx, "log", params...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []CommitInfo
+	for _, record := range strings.Split(result.Stdout, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		trailers := parseTrailers(fields[2])
+		if callID != "" && trailers[trailerCall] != callID {
+			// --grep matched a call ID with callID as prefix
+			continue
+		}
+		commits = append(commits, CommitInfo{
+			Commit:    fields[0],
+			Date:      fields[1],
+			Phase:     trailers[trailerPhase],
+			Tool:      trailers[trailerTool],
+			Session:   trailers[trailerSession],
+			CallID:    trailers[trailerCall],
+			Arguments: trailers["Arguments"],
+		})
+	}
+	return commits, nil
+}
+
+func addToolsToMCPServer() {
+	mcpToolHistory := mcp.NewTool("etc_history",
+		mcp.WithDescription("List the tool calls which changed files under /etc, with tool name, arguments, MCP session and call ID, the most recent first."),
+		mcp.WithNumber("max_count", mcp.Description("Maximum number of calls to list, default 50")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolHistory, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		maxCount := 50
+		if number, ok := req.GetArguments()["max_count"].(float64); ok && number > 0 {
+			maxCount = int(number)
+		}
+		commits, err := listCommits(ctx, "", maxCount)
+		if err != nil {
+			return nil, err
+		}
+		return utils.JSONToolResult(map[string]any{"calls": commits})
+	})
+	utils.RecordRegisteredTool("etc_history")
+
+	mcpToolDiff := mcp.NewTool("etc_diff",
+		mcp.WithDescription("Show the diff of the files under /etc changed by a single tool call."),
+		mcp.WithString("call_id", mcp.Required(), mcp.Description("CALL ID as listed by etc_history")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolDiff, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		callID, _ := req.GetArguments()["call_id"].(string)
+		if !callIDPattern.MatchString(callID) {
+			return nil, errors.New("Error in etc_diff: invalid call_id")
+		}
+		commits, err := listCommits(ctx, callID, 10)
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range commits {
+			if commit.Phase != "post" {
+				continue
+			}
+			result, err := runGit(ctx, "show", append([]string{"--format=%B", "--stat", "--patch", commit.Commit}, pathspec()...)...)
+			if err != nil {
+				return nil, err
+			}
+			return mcp.NewToolResultText(result.Stdout), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("{\"message\": \"call %s did not change any file under /etc\"}", callID)), nil
+	})
+	utils.RecordRegisteredTool("etc_diff")
+}
+
+func runTests() {
+	// Convert struct to JSON
+	jsonData, err := json.MarshalIndent(newGitCmd(defaultEtcGitDir), "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+		return
+	}
+	// Write JSON to file
+	err = os.WriteFile("etcgit.json", jsonData, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	gitDir := defaultEtcGitDir
+	if utils.AdminTasksConfig.EtcGitDir != "" {
+		gitDir = utils.AdminTasksConfig.EtcGitDir
+	}
+	gitCmd = newGitCmd(gitDir)
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		etcgitDebug = debugMode == utils.Debug
+		if !utils.IsSubCmdAvailable(gitCmd, "etc_history", gitCmd.SubCommands["log"]) {
+			utils.RecordSkippedTool("etc_diff", gitCmd.Executable, "git not available")
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-etcgit")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if err := initRepository(context.Background(), gitDir); err != nil {
+			sysLog.Warning(fmt.Sprintf("no version control of /etc: %v", err))
+			utils.RecordSkippedTool("etc_history", gitCmd.Executable, err.Error())
+			utils.RecordSkippedTool("etc_diff", gitCmd.Executable, err.Error())
+			return
+		}
+		if etcgitDebug {
+			sysLog.Info("committing /etc to " + gitDir + " around mutating tools")
+		}
+		utils.RegisterMutationHook(etcGitHook{})
+		addToolsToMCPServer()
+	case utils.Test:
+		etcgitDebug = true
+		runTests()
+	}
+}
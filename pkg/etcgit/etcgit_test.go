@@ -0,0 +1,137 @@
+package etcgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"mcp-server-admintasks/pkg/utils"
+)
+
+// etcFiles is a small /etc with secrets in the places of secretPaths.
+var etcFiles = []string{
+	"hosts",
+	"passwd",
+	"shadow",
+	"shadow-",
+	"gshadow",
+	"security/opasswd",
+	"security/limits.conf",
+	"ssh/sshd_config",
+	"ssh/ssh_host_ed25519_key",
+	"ssh/ssh_host_ed25519_key.pub",
+	"zypp/zypp.conf",
+	"zypp/credentials.d/SCCcredentials",
+	"NetworkManager/system-connections/wlan.nmconnection",
+	"wpa_supplicant/wpa_supplicant.conf",
+	"sssd/sssd.conf",
+	"krb5.keytab",
+	"ppp/chap-secrets",
+	"ppp/options",
+	"ld.so.cache",
+	"mtab",
+	"vimrc.swp",
+	"fstab~",
+	"sysconfig/network/ifcfg-eth0",
+	"mcp-server-admintasks/config.json",
+	"mcp-server-admintasks/regcodes.json",
+	"mcp-server-admintasks/codes/suse.json",
+}
+
+func gitListFiles(t *testing.T, dir string, spec []string) []string {
+	t.Helper()
+	args := append([]string{"-C", dir, "ls-files", "--others"}, spec...)
+	output, err := exec.Command("git", args...).Output()
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	files := strings.Fields(string(output))
+	sort.Strings(files)
+	return files
+}
+
+func TestPathspec(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	saved := utils.AdminTasksConfig
+	defer func() { utils.AdminTasksConfig = saved }()
+	utils.AdminTasksConfig.RegistrationCodesFile = "/etc/mcp-server-admintasks/codes/suse.json"
+	dir := t.TempDir()
+	if output, err := exec.Command("git", "-C", dir, "init", "--quiet").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v %s", err, output)
+	}
+	for _, file := range etcFiles {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	committed := gitListFiles(t, dir, pathspec())
+	want := []string{
+		"hosts",
+		"mcp-server-admintasks/config.json",
+		"passwd",
+		"ppp/options",
+		"security/limits.conf",
+		"ssh/ssh_host_ed25519_key.pub",
+		"ssh/sshd_config",
+		"sysconfig/network/ifcfg-eth0",
+		"zypp/zypp.conf",
+	}
+	if !reflect.DeepEqual(committed, want) {
+		t.Errorf("committed files %v, want %v", committed, want)
+	}
+
+	secrets := gitListFiles(t, dir, secretPathspec())
+	want = []string{
+		"NetworkManager/system-connections/wlan.nmconnection",
+		"gshadow",
+		"krb5.keytab",
+		"mcp-server-admintasks/codes/suse.json",
+		"mcp-server-admintasks/regcodes.json",
+		"ppp/chap-secrets",
+		"security/opasswd",
+		"shadow",
+		"shadow-",
+		"ssh/ssh_host_ed25519_key",
+		"sssd/sssd.conf",
+		"wpa_supplicant/wpa_supplicant.conf",
+		"zypp/credentials.d/SCCcredentials",
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(secrets, want) {
+		t.Errorf("secret files %v, want %v", secrets, want)
+	}
+}
+
+func TestAllSecretPaths(t *testing.T) {
+	saved := utils.AdminTasksConfig
+	defer func() { utils.AdminTasksConfig = saved }()
+	for codesFile, want := range map[string]string{
+		"":                                   "",
+		"/etc/mcp-server-admintasks/rc.json": "mcp-server-admintasks/rc.json",
+		"/etc/../etc/regcodes.json":          "regcodes.json",
+		"/root/regcodes.json":                "",
+		"/etc":                               "",
+		"/etcetera/regcodes.json":            "",
+	} {
+		utils.AdminTasksConfig.RegistrationCodesFile = codesFile
+		paths := allSecretPaths()
+		added := ""
+		if len(paths) > len(secretPaths) {
+			added = paths[len(paths)-1]
+		}
+		if added != want {
+			t.Errorf("registration_codes_file %q adds %q, want %q", codesFile, added, want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var inventoryDebug bool
+
+// Format of the inventory documents, checked when reading them
+const (
+	inventoryFormat  = "mcp-server-admintasks-inventory"
+	inventoryVersion = 1
+)
+
+// "current" is the inventory of this host at the time of the call
+const currentInventory = "current"
+
+// Lines per category in the summary, the JSON has all of them
+const summaryMaxLines = 20
+
+var snapshotIDPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z(-[A-Za-z0-9_.-]+)?$`)
+var labelInvalidPattern = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+}
+
+// Inventory is the list of installed packages of a host at a point in
+// time, as saved by inventory_save or exported by inventory_export.
+type Inventory struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	ID        string    `json:"id,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	Hostname  string    `json:"hostname"`
+	OS        string    `json:"os,omitempty"`
+	Created   time.Time `json:"created"`
+	Packages  []Package `json:"packages"`
+	sourceRef string
+}
+
+type InventoryInfo struct {
+	ID       string    `json:"id"`
+	Label    string    `json:"label,omitempty"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+	Packages int       `json:"packages"`
+}
+
+type VersionChange struct {
+	Name       string `json:"name"`
+	Arch       string `json:"arch,omitempty"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+	OldArch    string `json:"old_arch,omitempty"`
+}
+
+type VendorChange struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	OldVendor string `json:"old_vendor"`
+	NewVendor string `json:"new_vendor"`
+}
+
+type Diff struct {
+	From          InventoryInfo   `json:"from"`
+	To            InventoryInfo   `json:"to"`
+	Added         []Package       `json:"added"`
+	Removed       []Package       `json:"removed"`
+	Upgraded      []VersionChange `json:"upgraded"`
+	Downgraded    []VersionChange `json:"downgraded"`
+	VendorChanged []VendorChange  `json:"vendor_changed"`
+	Unchanged     int             `json:"unchanged"`
+	Summary       string          `json:"summary"`
+}
+
+// Components of a CycloneDX SBOM, as written by sbom_generate, are
+// accepted as inventory as well.
+type cycloneDXDocument struct {
+	BOMFormat string `json:"bomFormat"`
+	Metadata  struct {
+		Timestamp string `json:"timestamp"`
+		Component struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			Properties []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"properties"`
+		} `json:"component"`
+	} `json:"metadata"`
+	Components []struct {
+		Name       string `json:"name"`
+		Version    string `json:"version"`
+		Publisher  string `json:"publisher"`
+		Properties []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"properties"`
+	} `json:"components"`
+}
+
+// inventoryDir defaults to the state directory, the server writes the
+// snapshots itself.
+func inventoryDir() (string, error) {
+	if utils.AdminTasksConfig.InventoryDir != "" {
+		return utils.AdminTasksConfig.InventoryDir, nil
+	}
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "inventory"), nil
+}
+
+func (inventory Inventory) info() InventoryInfo {
+	id := inventory.ID
+	if id == "" {
+		id = inventory.sourceRef
+	}
+	return InventoryInfo{
+		ID:       id,
+		Label:    inventory.Label,
+		Hostname: inventory.Hostname,
+		Created:  inventory.Created,
+		Packages: len(inventory.Packages),
+	}
+}
+
+// Current returns the inventory of this host from the rpm database.
+func Current() (Inventory, error) {
+	db, err := rpmdb.Open()
+	if err != nil {
+		return Inventory{}, err
+	}
+	installed, err := db.Packages()
+	if err != nil {
+		return Inventory{}, err
+	}
+	hostname, _ := os.Hostname()
+	inventory := Inventory{
+		Format:    inventoryFormat,
+		Version:   inventoryVersion,
+		Hostname:  hostname,
+		OS:        strings.TrimSpace(utils.DetectedOSRelease.ID + " " + utils.DetectedOSRelease.VersionID),
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Packages:  []Package{},
+		sourceRef: currentInventory,
+	}
+	for _, pkg := range installed {
+		if pkg.Name == "gpg-pubkey" {
+			continue
+		}
+		inventory.Packages = append(inventory.Packages, Package{Name: pkg.Name, Version: pkg.EVR(), Arch: pkg.Arch, Vendor: pkg.Vendor})
+	}
+	return inventory, nil
+}
+
+// Save stores the current inventory in the inventory directory.
+func Save(label string) (Inventory, error) {
+	inventory, err := Current()
+	if err != nil {
+		return inventory, err
+	}
+	inventory.Label = labelInvalidPattern.ReplaceAllString(label, "_")
+	inventory.ID = inventory.Created.Format("20060102T150405Z")
+	if inventory.Label != "" {
+		inventory.ID += "-" + inventory.Label
+	}
+	dir, err := inventoryDir()
+	if err != nil {
+		return inventory, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return inventory, err
+	}
+	jsonData, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return inventory, err
+	}
+	filePath := filepath.Join(dir, inventory.ID+".json")
+	if _, err := os.Stat(filePath); err == nil {
+		return inventory, fmt.Errorf("inventory %s exists already", inventory.ID)
+	}
+	return inventory, os.WriteFile(filePath, jsonData, 0600)
+}
+
+// List returns the saved inventories, the most recent first.
+func List() ([]InventoryInfo, error) {
+	dir, err := inventoryDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	infos := []InventoryInfo{}
+	for _, file := range files {
+		inventory, err := load(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, inventory.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID > infos[j].ID })
+	return infos, nil
+}
+
+func load(id string) (Inventory, error) {
+	if !snapshotIDPattern.MatchString(id) {
+		return Inventory{}, fmt.Errorf("invalid inventory ID %q", id)
+	}
+	dir, err := inventoryDir()
+	if err != nil {
+		return Inventory{}, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return Inventory{}, err
+	}
+	return Parse(content)
+}
+
+// Parse reads an inventory document, or the components of a CycloneDX
+// SBOM.
+func Parse(content []byte) (Inventory, error) {
+	var probe struct {
+		Format    string `json:"format"`
+		BOMFormat string `json:"bomFormat"`
+	}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return Inventory{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	switch {
+	case probe.Format == inventoryFormat:
+		var inventory Inventory
+		if err := json.Unmarshal(content, &inventory); err != nil {
+			return Inventory{}, err
+		}
+		if inventory.Version > inventoryVersion {
+			return Inventory{}, fmt.Errorf("inventory version %d is not supported", inventory.Version)
+		}
+		return inventory, nil
+	case probe.BOMFormat == "CycloneDX":
+		var document cycloneDXDocument
+		if err := json.Unmarshal(content, &document); err != nil {
+			return Inventory{}, err
+		}
+		inventory := Inventory{Format: inventoryFormat, Version: inventoryVersion, OS: document.Metadata.Component.Name + " " + document.Metadata.Component.Version}
+		inventory.Created, _ = time.Parse(time.RFC3339, document.Metadata.Timestamp)
+		for _, property := range document.Metadata.Component.Properties {
+			if property.Name == "hostname" {
+				inventory.Hostname = property.Value
+			}
+		}
+		for _, component := range document.Components {
+			pkg := Package{Name: component.Name, Version: component.Version, Vendor: component.Publisher}
+			for _, property := range component.Properties {
+				if property.Name == "rpm:arch" {
+					pkg.Arch = property.Value
+				}
+			}
+			inventory.Packages = append(inventory.Packages, pkg)
+		}
+		return inventory, nil
+	}
+	return Inventory{}, errors.New("neither an inventory nor a CycloneDX SBOM")
+}
+
+// resolve turns the argument of inventory_diff into an inventory:
+// "current", the ID of a saved inventory or a JSON document.
+func resolve(reference string) (Inventory, error) {
+	reference = strings.TrimSpace(reference)
+	switch {
+	case reference == currentInventory:
+		return Current()
+	case strings.HasPrefix(reference, "{"):
+		inventory, err := Parse([]byte(reference))
+		inventory.sourceRef = "json"
+		return inventory, err
+	}
+	inventory, err := load(reference)
+	inventory.sourceRef = reference
+	return inventory, err
+}
+
+func byName(packages []Package) map[string][]Package {
+	names := make(map[string][]Package)
+	for _, pkg := range packages {
+		names[pkg.Name] = append(names[pkg.Name], pkg)
+	}
+	return names
+}
+
+// Compare reports the differences from one inventory to another. A name
+// installed once on both sides is an up- or downgrade, names installed in
+// several versions, like kernels, are compared version by version.
+func Compare(from Inventory, to Inventory) Diff {
+	diff := Diff{
+		From:          from.info(),
+		To:            to.info(),
+		Added:         []Package{},
+		Removed:       []Package{},
+		Upgraded:      []VersionChange{},
+		Downgraded:    []VersionChange{},
+		VendorChanged: []VendorChange{},
+	}
+	fromNames, toNames := byName(from.Packages), byName(to.Packages)
+	for name, fromPackages := range fromNames {
+		toPackages, ok := toNames[name]
+		if !ok {
+			diff.Removed = append(diff.Removed, fromPackages...)
+			continue
+		}
+		if len(fromPackages) == 1 && len(toPackages) == 1 {
+			oldPkg, newPkg := fromPackages[0], toPackages[0]
+			change := VersionChange{Name: name, Arch: newPkg.Arch, OldVersion: oldPkg.Version, NewVersion: newPkg.Version}
+			if oldPkg.Arch != newPkg.Arch {
+				change.OldArch = oldPkg.Arch
+			}
+			switch result := utils.CompareEVR(oldPkg.Version, newPkg.Version); {
+			case result < 0:
+				diff.Upgraded = append(diff.Upgraded, change)
+			case result > 0:
+				diff.Downgraded = append(diff.Downgraded, change)
+			default:
+				diff.Unchanged++
+			}
+			if oldPkg.Vendor != newPkg.Vendor && oldPkg.Vendor != "" && newPkg.Vendor != "" {
+				diff.VendorChanged = append(diff.VendorChanged, VendorChange{Name: name, Version: newPkg.Version, OldVendor: oldPkg.Vendor, NewVendor: newPkg.Vendor})
+			}
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, pkg := range toPackages {
+			seen[pkg.Version+"."+pkg.Arch] = true
+		}
+		for _, pkg := range fromPackages {
+			if seen[pkg.Version+"."+pkg.Arch] {
+				delete(seen, pkg.Version+"."+pkg.Arch)
+				diff.Unchanged++
+			} else {
+				diff.Removed = append(diff.Removed, pkg)
+			}
+		}
+		for _, pkg := range toPackages {
+			if seen[pkg.Version+"."+pkg.Arch] {
+				diff.Added = append(diff.Added, pkg)
+			}
+		}
+	}
+	for name, toPackages := range toNames {
+		if _, ok := fromNames[name]; !ok {
+			diff.Added = append(diff.Added, toPackages...)
+		}
+	}
+	sortPackages := func(packages []Package) {
+		sort.Slice(packages, func(i, j int) bool {
+			if packages[i].Name != packages[j].Name {
+				return packages[i].Name < packages[j].Name
+			}
+			return utils.CompareEVR(packages[i].Version, packages[j].Version) < 0
+		})
+	}
+	sortPackages(diff.Added)
+	sortPackages(diff.Removed)
+	sort.Slice(diff.Upgraded, func(i, j int) bool { return diff.Upgraded[i].Name < diff.Upgraded[j].Name })
+	sort.Slice(diff.Downgraded, func(i, j int) bool { return diff.Downgraded[i].Name < diff.Downgraded[j].Name })
+	sort.Slice(diff.VendorChanged, func(i, j int) bool { return diff.VendorChanged[i].Name < diff.VendorChanged[j].Name })
+	diff.Summary = summary(diff)
+	return diff
+}
+
+func describe(info InventoryInfo) string {
+	description := info.ID
+	if info.Hostname != "" {
+		description += " (" + info.Hostname
+		if !info.Created.IsZero() {
+			description += ", " + info.Created.Format(time.RFC3339)
+		}
+		description += ")"
+	}
+	return description
+}
+
+// summary is the diff in a few lines, like
+// "+ vim 9.1-1.1", "- nano 7.2-1.1", "^ openssl 3.0.8-1 -> 3.0.8-2".
+func summary(diff Diff) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%s -> %s: %d added, %d removed, %d upgraded, %d downgraded, %d vendor changes, %d unchanged",
+		describe(diff.From), describe(diff.To), len(diff.Added), len(diff.Removed), len(diff.Upgraded), len(diff.Downgraded), len(diff.VendorChanged), diff.Unchanged))
+	section := func(count int, line func(i int) string) {
+		for i := 0; i < count && i < summaryMaxLines; i++ {
+			lines = append(lines, line(i))
+		}
+		if count > summaryMaxLines {
+			lines = append(lines, fmt.Sprintf("  ... %d more", count-summaryMaxLines))
+		}
+	}
+	section(len(diff.Added), func(i int) string {
+		return fmt.Sprintf("+ %s %s", diff.Added[i].Name, diff.Added[i].Version)
+	})
+	section(len(diff.Removed), func(i int) string {
+		return fmt.Sprintf("- %s %s", diff.Removed[i].Name, diff.Removed[i].Version)
+	})
+	section(len(diff.Upgraded), func(i int) string {
+		return fmt.Sprintf("^ %s %s -> %s", diff.Upgraded[i].Name, diff.Upgraded[i].OldVersion, diff.Upgraded[i].NewVersion)
+	})
+	section(len(diff.Downgraded), func(i int) string {
+		return fmt.Sprintf("v %s %s -> %s", diff.Downgraded[i].Name, diff.Downgraded[i].OldVersion, diff.Downgraded[i].NewVersion)
+	})
+	section(len(diff.VendorChanged), func(i int) string {
+		return fmt.Sprintf("~ %s vendor %s -> %s", diff.VendorChanged[i].Name, diff.VendorChanged[i].OldVendor, diff.VendorChanged[i].NewVendor)
+	})
+	return strings.Join(lines, "\n")
+}
+
+func addToolsToMCPServer() {
+	mcpToolSave := mcp.NewTool("inventory_save",
+		mcp.WithDescription("Save the list of installed packages of this host as inventory snapshot, to compare it later with inventory_diff."),
+		mcp.WithString("label", mcp.Description("Short label for the snapshot, e.g. before-upgrade")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolSave, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		label, _ := req.GetArguments()["label"].(string)
+		inventory, err := Save(label)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(inventory.info())
+	})
+	utils.RecordRegisteredTool("inventory_save")
+
+	mcpToolList := mcp.NewTool("inventory_list",
+		mcp.WithDescription("List the saved inventory snapshots of this host."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		infos, err := List()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(map[string]any{"inventories": infos})
+	})
+	utils.RecordRegisteredTool("inventory_list")
+
+	mcpToolExport := mcp.NewTool("inventory_export",
+		mcp.WithDescription("Return the current inventory of this host as JSON, to compare it with another host by passing it to inventory_diff there."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolExport, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		inventory, err := Current()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(inventory)
+	})
+	utils.RecordRegisteredTool("inventory_export")
+
+	mcpToolDiff := mcp.NewTool("inventory_diff",
+		mcp.WithDescription("Compare two package inventories and report added, removed, upgraded and downgraded packages and vendor changes, with a short summary. Each side is \"current\", the ID of a saved snapshot or a JSON document from inventory_export or a CycloneDX SBOM of another host."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Older or reference inventory: \"current\", a snapshot ID or JSON")),
+		mcp.WithString("to", mcp.Required(), mcp.Description("Newer or compared inventory: \"current\", a snapshot ID or JSON")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolDiff, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		fromReference, _ := req.GetArguments()["from"].(string)
+		toReference, _ := req.GetArguments()["to"].(string)
+		from, err := resolve(fromReference)
+		if err != nil {
+			return mcp.NewToolResultError("from: " + err.Error()), nil
+		}
+		to, err := resolve(toReference)
+		if err != nil {
+			return mcp.NewToolResultError("to: " + err.Error()), nil
+		}
+		return utils.JSONToolResult(Compare(from, to))
+	})
+	utils.RecordRegisteredTool("inventory_diff")
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		inventoryDebug = debugMode == utils.Debug
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-inventory")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if dir, err := inventoryDir(); inventoryDebug && err == nil {
+			sysLog.Info("inventory snapshots in " + dir)
+		}
+		addToolsToMCPServer()
+	}
+}
@@ -0,0 +1,169 @@
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const suseVendor = "SUSE LLC <https://www.suse.com/>"
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		from, to      []Package
+		added         []Package
+		removed       []Package
+		upgraded      []VersionChange
+		downgraded    []VersionChange
+		vendorChanged []VendorChange
+		unchanged     int
+	}{
+		{name: "upgrade and downgrade",
+			from: []Package{{Name: "vim", Version: "9.0.2103-1.1", Arch: "x86_64"}, {Name: "openssl", Version: "3.0.8-2", Arch: "x86_64"}},
+			to:   []Package{{Name: "vim", Version: "9.1.0330-1.1", Arch: "x86_64"}, {Name: "openssl", Version: "3.0.8-1", Arch: "x86_64"}},
+			upgraded: []VersionChange{
+				{Name: "vim", Arch: "x86_64", OldVersion: "9.0.2103-1.1", NewVersion: "9.1.0330-1.1"},
+			},
+			downgraded: []VersionChange{
+				{Name: "openssl", Arch: "x86_64", OldVersion: "3.0.8-2", NewVersion: "3.0.8-1"},
+			}},
+		{name: "epoch wins over version",
+			from:     []Package{{Name: "mozilla-nss", Version: "3.101-1.1"}},
+			to:       []Package{{Name: "mozilla-nss", Version: "1:2.0-1.1"}},
+			upgraded: []VersionChange{{Name: "mozilla-nss", OldVersion: "3.101-1.1", NewVersion: "1:2.0-1.1"}}},
+		{name: "added and removed names",
+			from:      []Package{{Name: "nano", Version: "7.2-1.1", Arch: "x86_64"}, {Name: "bash", Version: "5.2-1.1", Arch: "x86_64"}},
+			to:        []Package{{Name: "htop", Version: "3.3.0-1.1", Arch: "x86_64"}, {Name: "bash", Version: "5.2-1.1", Arch: "x86_64"}},
+			added:     []Package{{Name: "htop", Version: "3.3.0-1.1", Arch: "x86_64"}},
+			removed:   []Package{{Name: "nano", Version: "7.2-1.1", Arch: "x86_64"}},
+			unchanged: 1},
+		{name: "arch change of the same version",
+			from:      []Package{{Name: "libfoo1", Version: "1.0-1", Arch: "i586"}},
+			to:        []Package{{Name: "libfoo1", Version: "1.0-1", Arch: "x86_64"}},
+			unchanged: 1},
+		{name: "multi-version kernels",
+			from: []Package{
+				{Name: "kernel-default", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"},
+				{Name: "kernel-default", Version: "6.4.0-150600.23.14.2", Arch: "x86_64"},
+			},
+			to: []Package{
+				{Name: "kernel-default", Version: "6.4.0-150600.23.22.1", Arch: "x86_64"},
+				{Name: "kernel-default", Version: "6.4.0-150600.23.14.2", Arch: "x86_64"},
+			},
+			added:     []Package{{Name: "kernel-default", Version: "6.4.0-150600.23.22.1", Arch: "x86_64"}},
+			removed:   []Package{{Name: "kernel-default", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"}},
+			unchanged: 1},
+		{name: "single to multi-version",
+			from: []Package{{Name: "kernel-default", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"}},
+			to: []Package{
+				{Name: "kernel-default", Version: "6.4.0-150600.23.14.2", Arch: "x86_64"},
+				{Name: "kernel-default", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"},
+			},
+			added:     []Package{{Name: "kernel-default", Version: "6.4.0-150600.23.14.2", Arch: "x86_64"}},
+			unchanged: 1},
+		{name: "vendor change",
+			from: []Package{
+				{Name: "ffmpeg-6", Version: "6.1.1-1.1", Arch: "x86_64", Vendor: suseVendor},
+				{Name: "curl", Version: "8.6.0-1.1", Arch: "x86_64", Vendor: suseVendor},
+			},
+			to: []Package{
+				{Name: "ffmpeg-6", Version: "6.1.1-1.1", Arch: "x86_64", Vendor: "http://packman.links2linux.de"},
+				// an unknown vendor is no change
+				{Name: "curl", Version: "8.6.0-1.1", Arch: "x86_64"},
+			},
+			vendorChanged: []VendorChange{{Name: "ffmpeg-6", Version: "6.1.1-1.1", OldVendor: suseVendor, NewVendor: "http://packman.links2linux.de"}},
+			unchanged:     2},
+	} {
+		diff := Compare(Inventory{Packages: tc.from}, Inventory{Packages: tc.to})
+		for _, check := range []struct {
+			field     string
+			got, want any
+		}{
+			{"added", diff.Added, tc.added},
+			{"removed", diff.Removed, tc.removed},
+			{"upgraded", diff.Upgraded, tc.upgraded},
+			{"downgraded", diff.Downgraded, tc.downgraded},
+			{"vendor_changed", diff.VendorChanged, tc.vendorChanged},
+		} {
+			if reflect.ValueOf(check.got).Len() == 0 && reflect.ValueOf(check.want).Len() == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(check.got, check.want) {
+				t.Errorf("%s: %s %+v, want %+v", tc.name, check.field, check.got, check.want)
+			}
+		}
+		if diff.Unchanged != tc.unchanged {
+			t.Errorf("%s: %d unchanged, want %d", tc.name, diff.Unchanged, tc.unchanged)
+		}
+	}
+}
+
+func TestCompareArchChange(t *testing.T) {
+	diff := Compare(
+		Inventory{Packages: []Package{{Name: "libfoo1", Version: "1.0-1", Arch: "i586"}}},
+		Inventory{Packages: []Package{{Name: "libfoo1", Version: "1.1-1", Arch: "x86_64"}}})
+	want := []VersionChange{{Name: "libfoo1", Arch: "x86_64", OldVersion: "1.0-1", NewVersion: "1.1-1", OldArch: "i586"}}
+	if !reflect.DeepEqual(diff.Upgraded, want) {
+		t.Errorf("upgraded %+v, want %+v", diff.Upgraded, want)
+	}
+	if !strings.HasPrefix(diff.Summary, " -> : 0 added, 0 removed, 1 upgraded") || !strings.Contains(diff.Summary, "^ libfoo1 1.0-1 -> 1.1-1") {
+		t.Errorf("summary %q", diff.Summary)
+	}
+}
+
+func TestParse(t *testing.T) {
+	created := time.Date(2024, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    Inventory
+		err     string
+	}{
+		{name: "inventory",
+			content: `{"format": "mcp-server-admintasks-inventory", "version": 1, "id": "20241018T120000Z-before", "label": "before",
+				"hostname": "web1", "os": "sles 15.6", "created": "2024-10-18T12:00:00Z",
+				"packages": [{"name": "bash", "version": "5.2.15-150500.3.2", "arch": "x86_64", "vendor": "SUSE LLC <https://www.suse.com/>"}]}`,
+			want: Inventory{Format: inventoryFormat, Version: 1, ID: "20241018T120000Z-before", Label: "before", Hostname: "web1", OS: "sles 15.6", Created: created,
+				Packages: []Package{{Name: "bash", Version: "5.2.15-150500.3.2", Arch: "x86_64", Vendor: suseVendor}}}},
+		{name: "cyclonedx",
+			content: `{"bomFormat": "CycloneDX", "specVersion": "1.5",
+				"metadata": {"timestamp": "2024-10-18T12:00:00Z", "component": {"type": "operating-system", "name": "opensuse-leap", "version": "15.6",
+					"properties": [{"name": "hostname", "value": "web2"}, {"name": "pretty_name", "value": "openSUSE Leap 15.6"}]}},
+				"components": [
+					{"type": "library", "name": "bash", "version": "5.2.15-150500.3.2", "publisher": "SUSE LLC <https://www.suse.com/>",
+						"properties": [{"name": "rpm:arch", "value": "x86_64"}, {"name": "rpm:repository", "value": "repo-oss"}]},
+					{"type": "library", "name": "tzdata", "version": "2024a-1.1", "properties": [{"name": "rpm:arch", "value": "noarch"}]}
+				]}`,
+			want: Inventory{Format: inventoryFormat, Version: inventoryVersion, Hostname: "web2", OS: "opensuse-leap 15.6", Created: created,
+				Packages: []Package{
+					{Name: "bash", Version: "5.2.15-150500.3.2", Arch: "x86_64", Vendor: suseVendor},
+					{Name: "tzdata", Version: "2024a-1.1", Arch: "noarch"},
+				}}},
+		{name: "newer version",
+			content: `{"format": "mcp-server-admintasks-inventory", "version": 2, "packages": []}`,
+			err:     "inventory version 2 is not supported"},
+		{name: "spdx",
+			content: `{"spdxVersion": "SPDX-2.3", "packages": []}`,
+			err:     "neither an inventory nor a CycloneDX SBOM"},
+		{name: "no json",
+			content: `bash-5.2.15-150500.3.2.x86_64`,
+			err:     "invalid JSON"},
+	} {
+		inventory, err := Parse([]byte(tc.content))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(inventory, tc.want) {
+			t.Errorf("%s:\n%+v\nwant %+v", tc.name, inventory, tc.want)
+		}
+	}
+}
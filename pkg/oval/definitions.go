@@ -0,0 +1,253 @@
+package oval
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type reference struct {
+	Source string `xml:"source,attr"`
+	RefID  string `xml:"ref_id,attr"`
+	RefURL string `xml:"ref_url,attr"`
+}
+
+type criterion struct {
+	TestRef string `xml:"test_ref,attr"`
+	Negate  bool   `xml:"negate,attr"`
+	Comment string `xml:"comment,attr"`
+}
+
+type extendDefinition struct {
+	DefinitionRef string `xml:"definition_ref,attr"`
+	Negate        bool   `xml:"negate,attr"`
+}
+
+type criteria struct {
+	Operator          string             `xml:"operator,attr"`
+	Negate            bool               `xml:"negate,attr"`
+	Criteria          []criteria         `xml:"criteria"`
+	Criterions        []criterion        `xml:"criterion"`
+	ExtendDefinitions []extendDefinition `xml:"extend_definition"`
+}
+
+type definition struct {
+	ID         string      `xml:"id,attr"`
+	Class      string      `xml:"class,attr"`
+	Title      string      `xml:"metadata>title"`
+	References []reference `xml:"metadata>reference"`
+	Severity   string      `xml:"metadata>advisory>severity"`
+	// patch definitions list the fixed CVEs in the advisory
+	AdvisoryCVEs []string `xml:"metadata>advisory>cve"`
+	Criteria     criteria `xml:"criteria"`
+}
+
+type rpminfoTest struct {
+	ID             string `xml:"id,attr"`
+	Check          string `xml:"check,attr"`
+	CheckExistence string `xml:"check_existence,attr"`
+	StateOperator  string `xml:"state_operator,attr"`
+	Object         struct {
+		ObjectRef string `xml:"object_ref,attr"`
+	} `xml:"object"`
+	States []struct {
+		StateRef string `xml:"state_ref,attr"`
+	} `xml:"state"`
+}
+
+type rpminfoObject struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name"`
+}
+
+type stateValue struct {
+	Operation string `xml:"operation,attr"`
+	Datatype  string `xml:"datatype,attr"`
+	Value     string `xml:",chardata"`
+}
+
+type rpminfoState struct {
+	ID             string      `xml:"id,attr"`
+	Arch           *stateValue `xml:"arch"`
+	Epoch          *stateValue `xml:"epoch"`
+	Version        *stateValue `xml:"version"`
+	Release        *stateValue `xml:"release"`
+	EVR            *stateValue `xml:"evr"`
+	SignatureKeyID *stateValue `xml:"signature_keyid"`
+}
+
+// Definitions is a parsed OVAL definitions file. Only the rpminfo tests
+// are kept, all other tests evaluate to unknown.
+type Definitions struct {
+	Path             string    `json:"path"`
+	Product          string    `json:"product,omitempty"`
+	Generated        time.Time `json:"generated,omitempty"`
+	DefinitionsCount int       `json:"definitions"`
+	definitions      []*definition
+	definitionsByID  map[string]*definition
+	tests            map[string]*rpminfoTest
+	otherTests       map[string]string
+	objects          map[string]*rpminfoObject
+	states           map[string]*rpminfoState
+}
+
+// openCompressed opens path, decompressed from .gz, .bz2 or .zst, the
+// compressions the OVAL files are published with.
+func openCompressed(path string) (io.Reader, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReader(file)
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return gzipReader, file.Close, nil
+	case strings.HasSuffix(path, ".bz2"):
+		return bzip2.NewReader(buffered), file.Close, nil
+	case strings.HasSuffix(path, ".zst"):
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return zstdReader, func() error { zstdReader.Close(); return file.Close() }, nil
+	case strings.HasSuffix(path, ".xz"):
+		file.Close()
+		return nil, nil, errors.New("xz compressed OVAL files are not supported, use the .gz or .bz2 file or decompress it")
+	}
+	return buffered, file.Close, nil
+}
+
+// parseDefinitions reads the definitions, tests, objects and states of
+// an OVAL file element by element, the files of a distribution have a
+// few hundred MB.
+func parseDefinitions(reader io.Reader) (*Definitions, error) {
+	defs := &Definitions{
+		definitionsByID: make(map[string]*definition),
+		tests:           make(map[string]*rpminfoTest),
+		otherTests:      make(map[string]string),
+		objects:         make(map[string]*rpminfoObject),
+		states:          make(map[string]*rpminfoState),
+	}
+	decoder := xml.NewDecoder(reader)
+	var section string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "generator", "definitions", "tests", "objects", "states", "variables":
+			section = start.Name.Local
+			continue
+		case "product_name":
+			if section == "generator" {
+				decoder.DecodeElement(&defs.Product, &start)
+			}
+			continue
+		case "timestamp":
+			if section == "generator" {
+				var timestamp string
+				decoder.DecodeElement(&timestamp, &start)
+				defs.Generated, _ = time.Parse("2006-01-02T15:04:05", strings.TrimSpace(timestamp))
+			}
+			continue
+		}
+		switch {
+		case section == "definitions" && start.Name.Local == "definition":
+			var def definition
+			if err := decoder.DecodeElement(&def, &start); err != nil {
+				return nil, err
+			}
+			defs.definitions = append(defs.definitions, &def)
+			defs.definitionsByID[def.ID] = &def
+		case section == "tests" && start.Name.Local == "rpminfo_test":
+			var test rpminfoTest
+			if err := decoder.DecodeElement(&test, &start); err != nil {
+				return nil, err
+			}
+			defs.tests[test.ID] = &test
+		case section == "tests":
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					defs.otherTests[attr.Value] = start.Name.Local
+				}
+			}
+			decoder.Skip()
+		case section == "objects" && start.Name.Local == "rpminfo_object":
+			var object rpminfoObject
+			if err := decoder.DecodeElement(&object, &start); err != nil {
+				return nil, err
+			}
+			object.Name = strings.TrimSpace(object.Name)
+			defs.objects[object.ID] = &object
+		case section == "states" && start.Name.Local == "rpminfo_state":
+			var state rpminfoState
+			if err := decoder.DecodeElement(&state, &start); err != nil {
+				return nil, err
+			}
+			defs.states[state.ID] = &state
+		case section == "objects" || section == "states" || section == "variables":
+			decoder.Skip()
+		}
+	}
+	if len(defs.definitions) == 0 {
+		return nil, errors.New("no OVAL definitions found")
+	}
+	defs.DefinitionsCount = len(defs.definitions)
+	return defs, nil
+}
+
+var definitionsCacheMutex sync.Mutex
+var definitionsCache *Definitions
+var definitionsCacheKey string
+
+// LoadDefinitions parses the OVAL file at path, the last file is kept
+// in memory until it changes.
+func LoadDefinitions(path string) (*Definitions, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano())
+	definitionsCacheMutex.Lock()
+	defer definitionsCacheMutex.Unlock()
+	if definitionsCache != nil && definitionsCacheKey == key {
+		return definitionsCache, nil
+	}
+	reader, closeFile, err := openCompressed(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile()
+	defs, err := parseDefinitions(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defs.Path = path
+	definitionsCache = defs
+	definitionsCacheKey = key
+	return defs, nil
+}
@@ -0,0 +1,335 @@
+package oval
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+// result is the three-valued result of OVAL criteria, tests which can
+// not be evaluated here are unknown.
+type result int
+
+const (
+	resultFalse result = iota
+	resultTrue
+	resultUnknown
+)
+
+func (r result) negate(negate bool) result {
+	if !negate || r == resultUnknown {
+		return r
+	}
+	if r == resultTrue {
+		return resultFalse
+	}
+	return resultTrue
+}
+
+func combine(operator string, results []result) result {
+	trueCount, unknownCount := 0, 0
+	for _, r := range results {
+		switch r {
+		case resultTrue:
+			trueCount++
+		case resultUnknown:
+			unknownCount++
+		}
+	}
+	falseCount := len(results) - trueCount - unknownCount
+	switch strings.ToUpper(operator) {
+	case "OR":
+		if trueCount > 0 {
+			return resultTrue
+		}
+		if unknownCount > 0 {
+			return resultUnknown
+		}
+		return resultFalse
+	case "ONE":
+		if trueCount > 1 || (trueCount == 0 && unknownCount == 0) {
+			return resultFalse
+		}
+		if unknownCount > 0 {
+			return resultUnknown
+		}
+		return resultTrue
+	case "XOR":
+		if unknownCount > 0 {
+			return resultUnknown
+		}
+		if trueCount%2 == 1 {
+			return resultTrue
+		}
+		return resultFalse
+	default:
+		if falseCount > 0 {
+			return resultFalse
+		}
+		if unknownCount > 0 {
+			return resultUnknown
+		}
+		return resultTrue
+	}
+}
+
+// packageMatch is an installed package matching a test with a state
+// "evr less than", i.e. a package which is older than the fixed version.
+type packageMatch struct {
+	pkg   rpmdb.InstalledPackage
+	fixed string
+}
+
+// evaluator evaluates the definitions of one file against the installed
+// packages, the results of tests and definitions are cached.
+type evaluator struct {
+	defs              *Definitions
+	installed         map[string][]rpmdb.InstalledPackage
+	testResults       map[string]result
+	testMatches       map[string][]packageMatch
+	definitionResults map[string]result
+	unsupported       map[string]int
+}
+
+func newEvaluator(defs *Definitions, packages []rpmdb.InstalledPackage) *evaluator {
+	installed := make(map[string][]rpmdb.InstalledPackage)
+	for _, pkg := range packages {
+		installed[pkg.Name] = append(installed[pkg.Name], pkg)
+	}
+	return &evaluator{
+		defs:              defs,
+		installed:         installed,
+		testResults:       make(map[string]result),
+		testMatches:       make(map[string][]packageMatch),
+		definitionResults: make(map[string]result),
+		unsupported:       make(map[string]int),
+	}
+}
+
+func compareOperation(operation string, comparison int) (result, bool) {
+	switch operation {
+	case "", "equals":
+		return boolResult(comparison == 0), true
+	case "not equal":
+		return boolResult(comparison != 0), true
+	case "less than":
+		return boolResult(comparison < 0), true
+	case "less than or equal":
+		return boolResult(comparison <= 0), true
+	case "greater than":
+		return boolResult(comparison > 0), true
+	case "greater than or equal":
+		return boolResult(comparison >= 0), true
+	}
+	return resultUnknown, false
+}
+
+func boolResult(value bool) result {
+	if value {
+		return resultTrue
+	}
+	return resultFalse
+}
+
+// matchValue compares a field of an installed package with the value of
+// a state.
+func matchValue(actual string, value *stateValue) result {
+	expected := strings.TrimSpace(value.Value)
+	switch value.Operation {
+	case "pattern match":
+		pattern, err := regexp.Compile(expected)
+		if err != nil {
+			return resultUnknown
+		}
+		return boolResult(pattern.MatchString(actual))
+	case "case insensitive equals":
+		return boolResult(strings.EqualFold(actual, expected))
+	}
+	var comparison int
+	switch value.Datatype {
+	case "evr_string":
+		comparison = utils.CompareEVR(actual, expected)
+	case "version", "debian_evr_string":
+		comparison = utils.RpmVerCmp(actual, expected)
+	case "int":
+		actualNumber, errActual := strconv.Atoi(actual)
+		expectedNumber, errExpected := strconv.Atoi(expected)
+		if errActual != nil || errExpected != nil {
+			return resultUnknown
+		}
+		comparison = actualNumber - expectedNumber
+	default:
+		comparison = strings.Compare(actual, expected)
+	}
+	r, _ := compareOperation(value.Operation, comparison)
+	return r
+}
+
+// matchState checks a package against all fields of a state. Signature
+// key IDs are not in the header data read by rpmdb and are not checked,
+// a state without any other field is unknown.
+func matchState(pkg rpmdb.InstalledPackage, state *rpminfoState) result {
+	var results []result
+	if state.Arch != nil {
+		results = append(results, matchValue(pkg.Arch, state.Arch))
+	}
+	if state.Epoch != nil {
+		results = append(results, matchValue(strconv.FormatInt(pkg.Epoch, 10), state.Epoch))
+	}
+	if state.Version != nil {
+		results = append(results, matchValue(pkg.Version, state.Version))
+	}
+	if state.Release != nil {
+		results = append(results, matchValue(pkg.Release, state.Release))
+	}
+	if state.EVR != nil {
+		evr := strconv.FormatInt(pkg.Epoch, 10) + ":" + pkg.Version + "-" + pkg.Release
+		results = append(results, matchValue(evr, state.EVR))
+	}
+	if len(results) == 0 {
+		return resultUnknown
+	}
+	return combine("AND", results)
+}
+
+func (e *evaluator) evaluateTest(testRef string) result {
+	if r, ok := e.testResults[testRef]; ok {
+		return r
+	}
+	r := e.evaluateRpminfoTest(testRef)
+	e.testResults[testRef] = r
+	return r
+}
+
+func (e *evaluator) evaluateRpminfoTest(testRef string) result {
+	test, ok := e.defs.tests[testRef]
+	if !ok {
+		if testType, ok := e.defs.otherTests[testRef]; ok {
+			e.unsupported[testType]++
+		} else {
+			e.unsupported["missing test"]++
+		}
+		return resultUnknown
+	}
+	object, ok := e.defs.objects[test.Object.ObjectRef]
+	if !ok || object.Name == "" {
+		e.unsupported["rpminfo_object without name"]++
+		return resultUnknown
+	}
+	items := e.installed[object.Name]
+	switch test.CheckExistence {
+	case "none_exist":
+		return boolResult(len(items) == 0)
+	case "only_one_exists":
+		if len(items) != 1 {
+			return resultFalse
+		}
+	case "any_exist":
+	default:
+		if len(items) == 0 {
+			return resultFalse
+		}
+	}
+	if len(test.States) == 0 {
+		return resultTrue
+	}
+	var itemResults []result
+	var matches []packageMatch
+	for _, item := range items {
+		var stateResults []result
+		fixed := ""
+		for _, stateRef := range test.States {
+			state, ok := e.defs.states[stateRef.StateRef]
+			if !ok {
+				stateResults = append(stateResults, resultUnknown)
+				continue
+			}
+			stateResults = append(stateResults, matchState(item, state))
+			if state.EVR != nil && state.EVR.Operation == "less than" {
+				fixed = strings.TrimPrefix(strings.TrimSpace(state.EVR.Value), "0:")
+			}
+		}
+		operator := test.StateOperator
+		if operator == "" {
+			operator = "AND"
+		}
+		itemResult := combine(operator, stateResults)
+		itemResults = append(itemResults, itemResult)
+		if itemResult == resultTrue && fixed != "" {
+			matches = append(matches, packageMatch{pkg: item, fixed: fixed})
+		}
+	}
+	var r result
+	switch test.Check {
+	case "at least one":
+		r = combine("OR", itemResults)
+	case "only one":
+		r = combine("ONE", itemResults)
+	case "none satisfy":
+		r = combine("OR", itemResults).negate(true)
+	default:
+		r = combine("AND", itemResults)
+	}
+	if r == resultTrue {
+		e.testMatches[testRef] = matches
+	}
+	return r
+}
+
+func (e *evaluator) evaluateCriteria(c criteria) result {
+	var results []result
+	for _, child := range c.Criteria {
+		results = append(results, e.evaluateCriteria(child))
+	}
+	for _, child := range c.Criterions {
+		results = append(results, e.evaluateTest(child.TestRef).negate(child.Negate))
+	}
+	for _, child := range c.ExtendDefinitions {
+		results = append(results, e.evaluateDefinition(child.DefinitionRef).negate(child.Negate))
+	}
+	return combine(c.Operator, results).negate(c.Negate)
+}
+
+func (e *evaluator) evaluateDefinition(definitionRef string) result {
+	if r, ok := e.definitionResults[definitionRef]; ok {
+		return r
+	}
+	def, ok := e.defs.definitionsByID[definitionRef]
+	if !ok {
+		return resultUnknown
+	}
+	// guards against definitions extending themselves
+	e.definitionResults[definitionRef] = resultUnknown
+	r := e.evaluateCriteria(def.Criteria)
+	e.definitionResults[definitionRef] = r
+	return r
+}
+
+// vulnerablePackages collects the outdated packages of the true,
+// not negated criteria of c.
+func (e *evaluator) vulnerablePackages(c criteria, seen map[string]bool) []packageMatch {
+	var matches []packageMatch
+	if c.Negate {
+		return nil
+	}
+	for _, child := range c.Criteria {
+		if e.evaluateCriteria(child) == resultTrue {
+			matches = append(matches, e.vulnerablePackages(child, seen)...)
+		}
+	}
+	for _, child := range c.Criterions {
+		if child.Negate || e.evaluateTest(child.TestRef) != resultTrue {
+			continue
+		}
+		for _, match := range e.testMatches[child.TestRef] {
+			if !seen[match.pkg.NEVRA()] {
+				seen[match.pkg.NEVRA()] = true
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,384 @@
+package oval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/repomd"
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var ovalDebug bool
+
+const defaultOVALDir = "/var/lib/mcp-server-admintasks/oval"
+
+// OVAL files older than this are reported as outdated
+const maxOVALAge = 30 * 24 * time.Hour
+
+// Definition IDs listed for unknown results, the count has all of them
+const maxUnknownListed = 50
+
+var cvePattern = regexp.MustCompile(`CVE-[0-9]{4}-[0-9]{4,}`)
+
+type AffectedPackage struct {
+	Name             string `json:"name"`
+	Arch             string `json:"arch"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version"`
+	// newest version in the cached metadata of the enabled repositories
+	AvailableVersion string `json:"available_version,omitempty"`
+	Repository       string `json:"repository,omitempty"`
+}
+
+type Finding struct {
+	DefinitionID string            `json:"definition_id"`
+	Class        string            `json:"class"`
+	Title        string            `json:"title"`
+	Severity     string            `json:"severity,omitempty"`
+	CVEs         []string          `json:"cves"`
+	Packages     []AffectedPackage `json:"packages"`
+	FixAvailable bool              `json:"fix_available"`
+	Patches      []string          `json:"patches,omitempty"`
+}
+
+type Assessment struct {
+	Definitions        *Definitions   `json:"oval"`
+	Evaluated          int            `json:"evaluated"`
+	Affected           []Finding      `json:"affected"`
+	AffectedCVEs       []string       `json:"affected_cves"`
+	FixableCVEs        []string       `json:"fixable_cves"`
+	BySeverity         map[string]int `json:"by_severity"`
+	Unknown            int            `json:"unknown"`
+	UnknownDefinitions []string       `json:"unknown_definitions,omitempty"`
+	UnsupportedTests   map[string]int `json:"unsupported_tests,omitempty"`
+	Warnings           []string       `json:"warnings,omitempty"`
+}
+
+type fixLookup struct {
+	availableByName map[string][]repomd.Package
+	patchesByCVE    map[string][]string
+}
+
+func ovalDir() string {
+	if utils.AdminTasksConfig.OVALDir != "" {
+		return utils.AdminTasksConfig.OVALDir
+	}
+	return defaultOVALDir
+}
+
+// resolvePath returns path, relative paths are in the OVAL directory. If
+// path is empty, the OVAL directory has to contain exactly one file.
+func resolvePath(path string) (string, error) {
+	if path != "" {
+		if filepath.IsAbs(path) {
+			return path, nil
+		}
+		return filepath.Join(ovalDir(), filepath.Clean("/"+path)), nil
+	}
+	entries, err := os.ReadDir(ovalDir())
+	if err != nil {
+		return "", fmt.Errorf("no path given and no OVAL directory: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.Contains(entry.Name(), ".xml") {
+			files = append(files, entry.Name())
+		}
+	}
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("no OVAL file in %s, copy the OVAL definitions of the distribution there", ovalDir())
+	case 1:
+		return filepath.Join(ovalDir(), files[0]), nil
+	}
+	return "", fmt.Errorf("several OVAL files in %s, choose one: %s", ovalDir(), strings.Join(files, ", "))
+}
+
+func definitionCVEs(def *definition) []string {
+	seen := make(map[string]bool)
+	var cves []string
+	add := func(cve string) {
+		if cvePattern.MatchString(cve) && !seen[cve] {
+			seen[cve] = true
+			cves = append(cves, cve)
+		}
+	}
+	for _, ref := range def.References {
+		if ref.Source == "CVE" {
+			add(strings.TrimSpace(ref.RefID))
+		}
+	}
+	for _, cve := range def.AdvisoryCVEs {
+		add(strings.TrimSpace(cve))
+	}
+	for _, cve := range cvePattern.FindAllString(def.Title, -1) {
+		add(cve)
+	}
+	return cves
+}
+
+// newFixLookup indexes the cached metadata of the enabled repositories,
+// it is only available with zypp repositories.
+func newFixLookup() (*fixLookup, []string) {
+	if !utils.MatchesDistribution([]string{"suse"}) {
+		return nil, []string{"fixed versions are only looked up in zypp repositories"}
+	}
+	repos, err := repomd.EnabledRepositories()
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	lookup := &fixLookup{
+		availableByName: make(map[string][]repomd.Package),
+		patchesByCVE:    make(map[string][]string),
+	}
+	var warnings []string
+	if len(repos) == 0 {
+		warnings = append(warnings, "no enabled repository with cached metadata, run zypper refresh while connected or mirror the repositories")
+	}
+	for _, repo := range repos {
+		packages, err := repomd.RepositoryPackages(repo)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		for _, pkg := range packages {
+			lookup.availableByName[pkg.Name] = append(lookup.availableByName[pkg.Name], pkg)
+		}
+		updates, err := repomd.RepositoryUpdates(repo)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		for _, update := range updates {
+			for _, cve := range update.CVEs {
+				lookup.patchesByCVE[cve] = append(lookup.patchesByCVE[cve], update.ID)
+			}
+		}
+	}
+	return lookup, warnings
+}
+
+// newestFix returns the newest available package fixing installed.
+func (lookup *fixLookup) newestFix(installed rpmdb.InstalledPackage, fixed string) (repomd.Package, bool) {
+	var newest repomd.Package
+	found := false
+	for _, candidate := range lookup.availableByName[installed.Name] {
+		if candidate.Arch != installed.Arch && candidate.Arch != "noarch" {
+			continue
+		}
+		if utils.CompareEVR(candidate.Version, fixed) < 0 {
+			continue
+		}
+		if !found || utils.CompareEVR(candidate.Version, newest.Version) > 0 {
+			newest = candidate
+			found = true
+		}
+	}
+	return newest, found
+}
+
+func (lookup *fixLookup) patches(cves []string) []string {
+	seen := make(map[string]bool)
+	var patches []string
+	for _, cve := range cves {
+		for _, patch := range lookup.patchesByCVE[cve] {
+			if !seen[patch] {
+				seen[patch] = true
+				patches = append(patches, patch)
+			}
+		}
+	}
+	sort.Strings(patches)
+	return patches
+}
+
+// Assess evaluates the vulnerability and patch definitions of the OVAL
+// file at path against the installed packages, optionally only the ones
+// for the given CVEs. Everything is read from local files.
+func Assess(path string, cves []string) (Assessment, error) {
+	var assessment Assessment
+	defs, err := LoadDefinitions(path)
+	if err != nil {
+		return assessment, err
+	}
+	db, err := rpmdb.Open()
+	if err != nil {
+		return assessment, err
+	}
+	packages, err := db.Packages()
+	if err != nil {
+		return assessment, err
+	}
+	return assessPackages(defs, packages, cves)
+}
+
+// assessPackages evaluates defs against packages, see Assess.
+func assessPackages(defs *Definitions, packages []rpmdb.InstalledPackage, cves []string) (Assessment, error) {
+	assessment := Assessment{
+		Definitions:  defs,
+		Affected:     []Finding{},
+		AffectedCVEs: []string{},
+		FixableCVEs:  []string{},
+		BySeverity:   make(map[string]int),
+	}
+	if !defs.Generated.IsZero() && time.Since(defs.Generated) > maxOVALAge {
+		assessment.Warnings = append(assessment.Warnings, fmt.Sprintf("the OVAL data is from %s, newer vulnerabilities are missing", defs.Generated.Format("2006-01-02")))
+	}
+	wanted := make(map[string]bool)
+	for _, cve := range cves {
+		wanted[strings.ToUpper(strings.TrimSpace(cve))] = true
+	}
+	e := newEvaluator(defs, packages)
+	var lookup *fixLookup
+	affectedCVEs := make(map[string]bool)
+	fixableCVEs := make(map[string]bool)
+	for _, def := range defs.definitions {
+		if def.Class != "vulnerability" && def.Class != "patch" {
+			continue
+		}
+		defCVEs := definitionCVEs(def)
+		if len(wanted) > 0 {
+			match := false
+			for _, cve := range defCVEs {
+				match = match || wanted[cve]
+			}
+			if !match {
+				continue
+			}
+		}
+		assessment.Evaluated++
+		switch e.evaluateDefinition(def.ID) {
+		case resultUnknown:
+			assessment.Unknown++
+			if len(assessment.UnknownDefinitions) < maxUnknownListed {
+				assessment.UnknownDefinitions = append(assessment.UnknownDefinitions, def.ID)
+			}
+			continue
+		case resultFalse:
+			continue
+		}
+		if lookup == nil {
+			var warnings []string
+			lookup, warnings = newFixLookup()
+			assessment.Warnings = append(assessment.Warnings, warnings...)
+			if lookup == nil {
+				lookup = &fixLookup{}
+			}
+		}
+		finding := Finding{
+			DefinitionID: def.ID,
+			Class:        def.Class,
+			Title:        strings.TrimSpace(def.Title),
+			Severity:     strings.ToLower(strings.TrimSpace(def.Severity)),
+			CVEs:         defCVEs,
+			Packages:     []AffectedPackage{},
+			FixAvailable: true,
+		}
+		for _, match := range e.vulnerablePackages(def.Criteria, make(map[string]bool)) {
+			affected := AffectedPackage{
+				Name:             match.pkg.Name,
+				Arch:             match.pkg.Arch,
+				InstalledVersion: match.pkg.EVR(),
+				FixedVersion:     match.fixed,
+			}
+			if available, ok := lookup.newestFix(match.pkg, match.fixed); ok {
+				affected.AvailableVersion = available.Version
+				affected.Repository = available.Repository
+			} else {
+				finding.FixAvailable = false
+			}
+			finding.Packages = append(finding.Packages, affected)
+		}
+		if len(finding.Packages) == 0 {
+			finding.FixAvailable = false
+		}
+		finding.Patches = lookup.patches(defCVEs)
+		for _, cve := range defCVEs {
+			affectedCVEs[cve] = true
+			if finding.FixAvailable {
+				fixableCVEs[cve] = true
+			}
+		}
+		severity := finding.Severity
+		if severity == "" {
+			severity = "unspecified"
+		}
+		assessment.BySeverity[severity]++
+		assessment.Affected = append(assessment.Affected, finding)
+	}
+	for cve := range affectedCVEs {
+		assessment.AffectedCVEs = append(assessment.AffectedCVEs, cve)
+	}
+	for cve := range fixableCVEs {
+		assessment.FixableCVEs = append(assessment.FixableCVEs, cve)
+	}
+	sort.Strings(assessment.AffectedCVEs)
+	sort.Strings(assessment.FixableCVEs)
+	if len(e.unsupported) > 0 {
+		assessment.UnsupportedTests = e.unsupported
+	}
+	if assessment.Evaluated == 0 && len(wanted) > 0 {
+		return assessment, errors.New("the OVAL file has no definitions for the given CVEs")
+	}
+	return assessment, nil
+}
+
+func addToolsToMCPServer() {
+	mcpToolAssess := mcp.NewTool("oval_assess",
+		mcp.WithDescription("Evaluate a local OVAL definitions file (e.g. the OVAL XML of the distribution copied onto the host) against the installed rpm packages, fully offline. Reports the affected CVEs with installed and fixed versions and whether a fix is available in the cached metadata of the enabled repositories."),
+		mcp.WithString("path", mcp.Description(fmt.Sprintf("OVAL file (.xml, .xml.gz, .xml.bz2), relative to %s; may be left out if there is only one file", ovalDir()))),
+		mcp.WithArray("cves", mcp.Description("Only evaluate the definitions of these CVEs"), mcp.Items(map[string]any{"type": "string"})),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolAssess, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		path, _ := req.GetArguments()["path"].(string)
+		var cves []string
+		items, _ := req.GetArguments()["cves"].([]any)
+		for _, item := range items {
+			if cve, ok := item.(string); ok && cve != "" {
+				cves = append(cves, cve)
+			}
+		}
+		resolved, err := resolvePath(path)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		assessment, err := Assess(resolved, cves)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(assessment)
+	})
+	utils.RecordRegisteredTool("oval_assess")
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		ovalDebug = debugMode == utils.Debug
+		if _, err := rpmdb.Open(); err != nil {
+			utils.RecordSkippedTool("oval_assess", "rpmdb", err.Error())
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-oval")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if ovalDebug {
+			sysLog.Info("OVAL files in " + ovalDir())
+		}
+		addToolsToMCPServer()
+	}
+}
@@ -0,0 +1,165 @@
+package oval
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+const (
+	T = resultTrue
+	F = resultFalse
+	U = resultUnknown
+)
+
+func fixturePackages() []rpmdb.InstalledPackage {
+	return []rpmdb.InstalledPackage{
+		{Name: "sles-release", Version: "15.6", Release: "150600.46.1", Arch: "x86_64"},
+		{Name: "vim", Version: "9.0.2103", Release: "150500.20.6.1", Arch: "x86_64"},
+		{Name: "vim-data-common", Version: "9.1.0330", Release: "150500.20.12.1", Arch: "noarch"},
+		{Name: "bash", Version: "4.4", Release: "150400.27.3.2", Arch: "x86_64"},
+		{Name: "kernel-default", Version: "6.4.0", Release: "150600.23.7.3", Arch: "x86_64"},
+		{Name: "kernel-default", Version: "6.4.0", Release: "150600.23.14.2", Arch: "x86_64"},
+	}
+}
+
+func fixtureDefinitions(t *testing.T) *Definitions {
+	t.Helper()
+	file, err := os.Open("testdata/oval.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	defs, err := parseDefinitions(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return defs
+}
+
+func TestCombine(t *testing.T) {
+	for _, tc := range []struct {
+		operator string
+		results  []result
+		want     result
+	}{
+		{"AND", []result{T, T}, T},
+		{"AND", []result{T, F, U}, F},
+		{"AND", []result{T, U}, U},
+		{"", []result{T, U}, U},
+		{"and", []result{F}, F},
+		{"OR", []result{F, F}, F},
+		{"OR", []result{F, U, T}, T},
+		{"OR", []result{F, U}, U},
+		{"OR", nil, F},
+		{"ONE", []result{T, F}, T},
+		{"ONE", []result{T, T, U}, F},
+		{"ONE", []result{T, U}, U},
+		{"ONE", []result{F, U}, U},
+		{"ONE", []result{F, F}, F},
+		{"XOR", []result{T, T, T}, T},
+		{"XOR", []result{T, T}, F},
+		{"XOR", []result{T, U}, U},
+		{"XOR", []result{F, F}, F},
+	} {
+		if got := combine(tc.operator, tc.results); got != tc.want {
+			t.Errorf("combine(%q, %v) = %v, want %v", tc.operator, tc.results, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateRpminfoTest(t *testing.T) {
+	e := newEvaluator(fixtureDefinitions(t), fixturePackages())
+	for _, tc := range []struct {
+		test string
+		want result
+	}{
+		// version equals
+		{"oval:org.opensuse.security:tst:1", T},
+		// evr less than
+		{"oval:org.opensuse.security:tst:2", T},
+		{"oval:org.opensuse.security:tst:3", F},
+		{"oval:org.opensuse.security:tst:4", F},
+		// signature key IDs are not checked
+		{"oval:org.opensuse.security:tst:5", U},
+		// arch pattern and evr, one of two kernels is older
+		{"oval:org.opensuse.security:tst:6", T},
+		{"oval:org.opensuse.security:tst:7", U},
+		{"oval:org.opensuse.security:tst:8", T},
+		{"oval:org.opensuse.security:tst:9", F},
+		{"oval:org.opensuse.security:tst:10", F},
+		{"oval:org.opensuse.security:tst:11", U},
+		{"oval:org.opensuse.security:tst:404", U},
+	} {
+		if got := e.evaluateRpminfoTest(tc.test); got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.test, got, tc.want)
+		}
+	}
+	matches := e.testMatches["oval:org.opensuse.security:tst:6"]
+	if len(matches) != 1 || matches[0].pkg.Release != "150600.23.7.3" || matches[0].fixed != "6.4.0-150600.23.14.2" {
+		t.Errorf("kernel matches %+v", matches)
+	}
+	want := map[string]int{"textfilecontent54_test": 1, "rpminfo_object without name": 1, "missing test": 1}
+	if !reflect.DeepEqual(e.unsupported, want) {
+		t.Errorf("unsupported %v, want %v", e.unsupported, want)
+	}
+}
+
+func TestAssess(t *testing.T) {
+	osRelease := utils.DetectedOSRelease
+	defer func() { utils.DetectedOSRelease = osRelease }()
+	// no zypp repositories to look up the fixes
+	utils.DetectedOSRelease = utils.OSRelease{ID: "debian"}
+
+	defs := fixtureDefinitions(t)
+	assessment, err := assessPackages(defs, fixturePackages(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the inventory definition is not evaluated, the kernel patch is
+	// unknown because of its signature test
+	if assessment.Evaluated != 4 || assessment.Unknown != 2 {
+		t.Errorf("%d evaluated, %d unknown", assessment.Evaluated, assessment.Unknown)
+	}
+	wantUnknown := []string{"oval:org.opensuse.security:def:20241", "oval:org.opensuse.security:def:20242"}
+	if !reflect.DeepEqual(assessment.UnknownDefinitions, wantUnknown) {
+		t.Errorf("unknown definitions %v, want %v", assessment.UnknownDefinitions, wantUnknown)
+	}
+	wantAffected := []Finding{{
+		DefinitionID: "oval:org.opensuse.security:def:202422667",
+		Class:        "vulnerability",
+		Title:        "CVE-2024-22667",
+		Severity:     "important",
+		CVEs:         []string{"CVE-2024-22667"},
+		Packages: []AffectedPackage{{
+			Name:             "vim",
+			Arch:             "x86_64",
+			InstalledVersion: "9.0.2103-150500.20.6.1",
+			FixedVersion:     "9.1.0330-150500.20.12.1",
+		}},
+	}}
+	if !reflect.DeepEqual(assessment.Affected, wantAffected) {
+		t.Errorf("affected\n%+v\nwant\n%+v", assessment.Affected, wantAffected)
+	}
+	if !reflect.DeepEqual(assessment.AffectedCVEs, []string{"CVE-2024-22667"}) || len(assessment.FixableCVEs) != 0 {
+		t.Errorf("affected CVEs %v, fixable %v", assessment.AffectedCVEs, assessment.FixableCVEs)
+	}
+	if !reflect.DeepEqual(assessment.BySeverity, map[string]int{"important": 1}) {
+		t.Errorf("by severity %v", assessment.BySeverity)
+	}
+	// outdated OVAL data and no fix lookup
+	if len(assessment.Warnings) != 2 {
+		t.Errorf("warnings %v", assessment.Warnings)
+	}
+
+	assessment, err = assessPackages(defs, fixturePackages(), []string{" cve-2024-37370"})
+	if err != nil || assessment.Evaluated != 1 || len(assessment.Affected) != 0 {
+		t.Errorf("CVE-2024-37370: %+v %v", assessment, err)
+	}
+	if _, err := assessPackages(defs, fixturePackages(), []string{"CVE-2000-0001"}); err == nil {
+		t.Error("unknown CVE accepted")
+	}
+}
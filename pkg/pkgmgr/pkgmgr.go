@@ -0,0 +1,349 @@
+package pkgmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var pkgmgrDebug bool
+
+// Package is an entry of a search result, the same for all backends.
+type Package struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Summary    string `json:"summary,omitempty"`
+	Installed  bool   `json:"installed"`
+}
+
+type PackageInfo struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version,omitempty"`
+	Arch        string            `json:"arch,omitempty"`
+	Repository  string            `json:"repository,omitempty"`
+	Vendor      string            `json:"vendor,omitempty"`
+	License     string            `json:"license,omitempty"`
+	Summary     string            `json:"summary,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Installed   bool              `json:"installed"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+type Update struct {
+	Name       string `json:"name"`
+	Arch       string `json:"arch,omitempty"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version"`
+	Repository string `json:"repository,omitempty"`
+}
+
+type Repository struct {
+	Alias       string `json:"alias"`
+	Name        string `json:"name,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	Autorefresh bool   `json:"autorefresh"`
+	GPGCheck    bool   `json:"gpgcheck"`
+	Priority    int    `json:"priority,omitempty"`
+}
+
+type Lock struct {
+	Name       string `json:"name"`
+	Type       string `json:"type,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+type TransactionResult struct {
+	Backend      string   `json:"backend"`
+	Action       string   `json:"action"`
+	Packages     []string `json:"packages"`
+	Success      bool     `json:"success"`
+	RebootNeeded bool     `json:"reboot_needed"`
+	Snapshot     int      `json:"snapshot,omitempty"`
+	ExitCode     int      `json:"exit_code"`
+	Output       string   `json:"output,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// Backend is implemented by every package manager. All backends return
+// the same result types, so the pkg_* tools look the same on every host.
+type Backend interface {
+	Name() string
+	SystemCmd() utils.SystemCmd
+	Search(ctx context.Context, pattern string) ([]Package, error)
+	Info(ctx context.Context, name string) (PackageInfo, error)
+	Install(ctx context.Context, packages []string) (TransactionResult, error)
+	Remove(ctx context.Context, packages []string) (TransactionResult, error)
+	Update(ctx context.Context, packages []string) (TransactionResult, error)
+	ListUpdates(ctx context.Context) ([]Update, error)
+	ListRepos(ctx context.Context) ([]Repository, error)
+	ListLocks(ctx context.Context) ([]Lock, error)
+}
+
+// ConditionalBackend is implemented by backends which are only usable
+// under certain conditions, e.g. only with a read-only root file system.
+type ConditionalBackend interface {
+	IsApplicable() bool
+}
+
+var registeredBackends []Backend
+
+// ActiveBackend is the backend the pkg_* tools are using, nil if no
+// package manager is available on this host.
+var ActiveBackend Backend
+
+// RegisterBackend makes backend available for selection, backends
+// registered first are preferred.
+func RegisterBackend(backend Backend) {
+	registeredBackends = append(registeredBackends, backend)
+}
+
+func selectBackend() Backend {
+	for _, backend := range registeredBackends {
+		systemCmd := backend.SystemCmd()
+		if !utils.MatchesDistribution(systemCmd.Distributions) {
+			continue
+		}
+		if conditional, ok := backend.(ConditionalBackend); ok && !conditional.IsApplicable() {
+			continue
+		}
+		if utils.ProbeSystemCmd(systemCmd).Available {
+			return backend
+		}
+	}
+	return nil
+}
+
+// ParseKeyValueLines parses "Key : Value" output like the one of
+// zypper info, dnf info or apt-cache show. Keys are lower case with
+// spaces replaced by "_", continuation lines are appended to the
+// previous value.
+func ParseKeyValueLines(text string) map[string]string {
+	values := make(map[string]string)
+	var lastKey string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Trim(line, "-= \t") == "" {
+			// empty lines and underlines of headings
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.Contains(strings.TrimSpace(key), "  ") {
+			if lastKey != "" {
+				continuation := strings.TrimPrefix(strings.TrimSpace(line), ":")
+				values[lastKey] = strings.TrimSpace(values[lastKey] + "\n" + strings.TrimSpace(continuation))
+			}
+			continue
+		}
+		lastKey = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), " ", "_")
+		if _, exists := values[lastKey]; exists {
+			// only the first stanza is used
+			continue
+		}
+		values[lastKey] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// NewPackageInfo fills the common fields of PackageInfo from the parsed
+// key/value output of a backend.
+func NewPackageInfo(details map[string]string) PackageInfo {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := details[key]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+	installed := strings.ToLower(first("installed", "status"))
+	return PackageInfo{
+		Name:        first("name", "package"),
+		Version:     first("version"),
+		Arch:        first("arch", "architecture"),
+		Repository:  first("repository", "repo", "from_repo", "apt-sources"),
+		Vendor:      first("vendor", "maintainer"),
+		License:     first("license"),
+		Summary:     first("summary"),
+		Description: first("description", "description-en"),
+		Installed:   strings.HasPrefix(installed, "yes") || strings.Contains(installed, "install ok installed") || strings.HasPrefix(installed, "up-to-date") || strings.HasPrefix(installed, "out-of-date"),
+		Details:     details,
+	}
+}
+
+func toolResultJSON(backend Backend, key string, value any) (*mcp.CallToolResult, error) {
+	return utils.JSONToolResult(map[string]any{"backend": backend.Name(), key: value})
+}
+
+func transactionResultJSON(result TransactionResult) (*mcp.CallToolResult, error) {
+	jsonData, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return mcp.NewToolResultError(string(jsonData)), nil
+	}
+	return mcp.NewToolResultText(string(jsonData)), nil
+}
+
+func stringListArgument(req mcp.CallToolRequest, name string) []string {
+	var strList []string
+	argsSlice, ok := req.GetArguments()[name].([]interface{})
+	if ok {
+		for _, arg := range argsSlice {
+			if str, ok := arg.(string); ok && str != "" {
+				strList = append(strList, str)
+			}
+		}
+	}
+	return strList
+}
+
+// ValidatePackageNames refuses names which would be taken as options
+// by the package manager.
+func ValidatePackageNames(packages []string) error {
+	for _, name := range packages {
+		if strings.HasPrefix(name, "-") {
+			return fmt.Errorf("invalid package name %q", name)
+		}
+	}
+	return nil
+}
+
+type transactionFunc func(ctx context.Context, packages []string) (TransactionResult, error)
+
+func addTransactionTool(toolName string, description string, required bool, transaction func(Backend) transactionFunc) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription(description),
+	}
+	if required {
+		options = append(options, mcp.WithArray("packages", mcp.Required(), mcp.Description("Names of the packages"), mcp.Items(map[string]any{"type": "string"})))
+	} else {
+		options = append(options, mcp.WithArray("packages", mcp.Description("Names of the packages, all packages if empty"), mcp.Items(map[string]any{"type": "string"})))
+	}
+	utils.AdminTasksMCPServer.AddTool(mcp.NewTool(toolName, options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		packages := stringListArgument(req, "packages")
+		if required && len(packages) == 0 {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError("Error in " + toolName + ": no packages given"), nil
+		}
+		if err := ValidatePackageNames(packages); err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		result, err := transaction(ActiveBackend)(ctx, packages)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return transactionResultJSON(result)
+	})
+	utils.RecordRegisteredTool(toolName)
+	utils.MarkToolMutating(toolName, ActiveBackend.SystemCmd().Executable)
+}
+
+func addToolsToMCPServer() {
+	mcpToolSearch := mcp.NewTool("pkg_search",
+		mcp.WithDescription("Search for packages by name with the package manager of this host (zypper, transactional-update, dnf or apt)."),
+		mcp.WithString("pattern", mcp.Required(), mcp.Description("PATTERN or PACKAGE name")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolSearch, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pattern, ok := req.GetArguments()["pattern"].(string)
+		if !ok || strings.HasPrefix(pattern, "-") {
+			return mcp.NewToolResultError("Error in pkg_search: invalid pattern"), nil
+		}
+		packages, err := ActiveBackend.Search(ctx, pattern)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return toolResultJSON(ActiveBackend, "packages", packages)
+	})
+	utils.RecordRegisteredTool("pkg_search")
+
+	mcpToolInfo := mcp.NewTool("pkg_info",
+		mcp.WithDescription("Show full information for a single package with a known name."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("PACKAGE name")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolInfo, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, ok := req.GetArguments()["name"].(string)
+		if !ok || ValidatePackageNames([]string{name}) != nil {
+			return mcp.NewToolResultError("Error in pkg_info: invalid package name"), nil
+		}
+		info, err := ActiveBackend.Info(ctx, name)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return toolResultJSON(ActiveBackend, "package", info)
+	})
+	utils.RecordRegisteredTool("pkg_info")
+
+	addTransactionTool("pkg_install", "Install packages.", true, func(backend Backend) transactionFunc { return backend.Install })
+	addTransactionTool("pkg_remove", "Remove packages.", true, func(backend Backend) transactionFunc { return backend.Remove })
+	addTransactionTool("pkg_update", "Update the given installed packages, or all installed packages, to newer versions.", false, func(backend Backend) transactionFunc { return backend.Update })
+
+	mcpToolListUpdates := mcp.NewTool("pkg_list_updates",
+		mcp.WithDescription("List available package updates."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolListUpdates, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		updates, err := ActiveBackend.ListUpdates(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return toolResultJSON(ActiveBackend, "updates", updates)
+	})
+	utils.RecordRegisteredTool("pkg_list_updates")
+
+	mcpToolListRepos := mcp.NewTool("pkg_list_repos",
+		mcp.WithDescription("List all defined repositories."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolListRepos, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repos, err := ActiveBackend.ListRepos(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return toolResultJSON(ActiveBackend, "repositories", repos)
+	})
+	utils.RecordRegisteredTool("pkg_list_repos")
+
+	mcpToolLocks := mcp.NewTool("pkg_locks",
+		mcp.WithDescription("List current package locks (zypper locks, dnf versionlock, apt holds)."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolLocks, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		locks, err := ActiveBackend.ListLocks(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return toolResultJSON(ActiveBackend, "locks", locks)
+	})
+	utils.RecordRegisteredTool("pkg_locks")
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		pkgmgrDebug = debugMode == utils.Debug
+		ActiveBackend = selectBackend()
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-pkgmgr")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if ActiveBackend == nil {
+			sysLog.Notice("no supported package manager found, pkg_* tools are not available")
+			return
+		}
+		if pkgmgrDebug {
+			sysLog.Info("package manager backend: " + ActiveBackend.Name())
+		}
+		addToolsToMCPServer()
+	case utils.Test:
+		pkgmgrDebug = true
+	}
+}
@@ -0,0 +1,93 @@
+package repomd
+
+import (
+	"sync"
+	"time"
+)
+
+// Parsed metadata of big repositories takes hundreds of MB, so it is only
+// kept while it is used and up to a total number of entries.
+var (
+	metadataCacheMaxIdle  = 10 * time.Minute
+	metadataCacheMaxItems = 300000
+)
+
+type cacheEntry[T any] struct {
+	// revision and metadata file the items were parsed from
+	key      string
+	items    []T
+	lastUsed time.Time
+}
+
+// metadataCache keeps the parsed metadata of the latest revision of
+// every repository, keyed by alias.
+type metadataCache[T any] struct {
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry[T]
+	timer   *time.Timer
+}
+
+func newMetadataCache[T any]() *metadataCache[T] {
+	return &metadataCache[T]{entries: make(map[string]*cacheEntry[T])}
+}
+
+// get returns the items of alias cached for key, or calls load and
+// caches its result. Older revisions of alias are dropped.
+func (cache *metadataCache[T]) get(alias string, key string, load func() ([]T, error)) ([]T, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := time.Now()
+	if entry, ok := cache.entries[alias]; ok && entry.key == key {
+		entry.lastUsed = now
+		return entry.items, nil
+	}
+	items, err := load()
+	if err != nil {
+		return nil, err
+	}
+	cache.entries[alias] = &cacheEntry[T]{key: key, items: items, lastUsed: now}
+	cache.evict(now, alias)
+	if cache.timer == nil {
+		cache.timer = time.AfterFunc(metadataCacheMaxIdle, cache.expire)
+	}
+	return items, nil
+}
+
+// evict drops idle entries, and the least recently used ones while there
+// are more than metadataCacheMaxItems items. keep is never dropped.
+func (cache *metadataCache[T]) evict(now time.Time, keep string) {
+	total := 0
+	for alias, entry := range cache.entries {
+		if alias != keep && now.Sub(entry.lastUsed) > metadataCacheMaxIdle {
+			delete(cache.entries, alias)
+			continue
+		}
+		total += len(entry.items)
+	}
+	for total > metadataCacheMaxItems {
+		oldest := ""
+		for alias, entry := range cache.entries {
+			if alias != keep && (oldest == "" || entry.lastUsed.Before(cache.entries[oldest].lastUsed)) {
+				oldest = alias
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		total -= len(cache.entries[oldest].items)
+		delete(cache.entries, oldest)
+	}
+}
+
+// expire runs while entries are cached, so idle metadata is freed even
+// if no search follows.
+func (cache *metadataCache[T]) expire() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.evict(time.Now(), "")
+	if len(cache.entries) == 0 {
+		cache.timer = nil
+		return
+	}
+	cache.timer.Reset(metadataCacheMaxIdle)
+}
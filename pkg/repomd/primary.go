@@ -0,0 +1,323 @@
+package repomd
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const defaultSearchLimit = 30
+
+var toolNames = []string{"repomd_search"}
+
+type primaryEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr"`
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type primaryPackage struct {
+	Type    string `xml:"type,attr"`
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Summary     string `xml:"summary"`
+	Description string `xml:"description"`
+	URL         string `xml:"url"`
+	Location    struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	// the rpm: namespace is left out, Go matches the local names
+	License   string         `xml:"format>license"`
+	Vendor    string         `xml:"format>vendor"`
+	Group     string         `xml:"format>group"`
+	SourceRPM string         `xml:"format>sourcerpm"`
+	Provides  []primaryEntry `xml:"format>provides>entry"`
+	Requires  []primaryEntry `xml:"format>requires>entry"`
+	Files     []string       `xml:"format>file"`
+}
+
+// Package is a package of the cached metadata of a repository.
+type Package struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Arch        string   `json:"arch"`
+	Repository  string   `json:"repository"`
+	Summary     string   `json:"summary,omitempty"`
+	Description string   `json:"-"`
+	License     string   `json:"license,omitempty"`
+	Vendor      string   `json:"vendor,omitempty"`
+	SourceRPM   string   `json:"source_rpm,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	Provides    []string `json:"-"`
+	Requires    []string `json:"-"`
+	priority    int
+}
+
+type SearchResult struct {
+	Package
+	Score   int      `json:"score"`
+	Matched []string `json:"matched"`
+}
+
+func formatEVR(epoch string, version string, release string) string {
+	evr := version
+	if release != "" {
+		evr += "-" + release
+	}
+	if epoch != "" && epoch != "0" {
+		evr = epoch + ":" + evr
+	}
+	return evr
+}
+
+// parsePrimary reads the packages of primary.xml one by one, the files
+// of big repositories do not fit into memory as a whole.
+func parsePrimary(reader io.Reader, repo Repository) ([]Package, error) {
+	var packages []Package
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return packages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		var primary primaryPackage
+		if err := decoder.DecodeElement(&primary, &start); err != nil {
+			return nil, err
+		}
+		if primary.Type != "" && primary.Type != "rpm" {
+			continue
+		}
+		pkg := Package{
+			Name:        primary.Name,
+			Version:     formatEVR(primary.Version.Epoch, primary.Version.Ver, primary.Version.Rel),
+			Arch:        primary.Arch,
+			Repository:  repo.Alias,
+			Summary:     strings.TrimSpace(primary.Summary),
+			Description: strings.TrimSpace(primary.Description),
+			License:     primary.License,
+			Vendor:      primary.Vendor,
+			SourceRPM:   primary.SourceRPM,
+			Location:    primary.Location.Href,
+			priority:    repo.Priority,
+		}
+		for _, provide := range primary.Provides {
+			pkg.Provides = append(pkg.Provides, provide.Name)
+		}
+		for _, require := range primary.Requires {
+			pkg.Requires = append(pkg.Requires, require.Name)
+		}
+		// files in primary.xml are the commonly required ones, like /usr/bin/*
+		pkg.Provides = append(pkg.Provides, primary.Files...)
+		packages = append(packages, pkg)
+	}
+}
+
+var primaryCache = newMetadataCache[Package]()
+
+// RepositoryPackages returns the packages of the cached primary metadata
+// of repo. They are kept in memory while they are used, until the
+// repository is refreshed.
+func RepositoryPackages(repo Repository) ([]Package, error) {
+	key := repo.Revision + "\x00" + repo.data["primary"]
+	return primaryCache.get(repo.Alias, key, func() ([]Package, error) {
+		reader, err := repo.OpenData("primary")
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		packages, err := parsePrimary(reader, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", repo.Alias, err)
+		}
+		return packages, nil
+	})
+}
+
+// levenshtein returns the edit distance of a and b, or max+1 if it is
+// above max.
+func levenshtein(a string, b string, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		rowMin := current[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			rowMin = min(rowMin, current[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// scoreTerm ranks how well term matches pkg, 0 for no match.
+func scoreTerm(pkg Package, term string) (int, string) {
+	name := strings.ToLower(pkg.Name)
+	switch {
+	case name == term:
+		return 100, "name"
+	case strings.HasPrefix(name, term):
+		return 70, "name"
+	case strings.Contains(name, term):
+		return 50, "name"
+	}
+	for _, provide := range pkg.Provides {
+		if strings.ToLower(provide) == term {
+			return 45, "provides"
+		}
+	}
+	if len(term) >= 4 {
+		maxDistance := 1
+		if len(term) > 6 {
+			maxDistance = 2
+		}
+		if distance := levenshtein(name, term, maxDistance); distance <= maxDistance {
+			return 40 - 10*distance, "name (fuzzy)"
+		}
+	}
+	if strings.Contains(strings.ToLower(pkg.Summary), term) {
+		return 25, "summary"
+	}
+	for _, provide := range pkg.Provides {
+		if strings.Contains(strings.ToLower(provide), term) {
+			return 15, "provides"
+		}
+	}
+	if strings.Contains(strings.ToLower(pkg.Description), term) {
+		return 10, "description"
+	}
+	return 0, ""
+}
+
+// Search ranks the packages of repos against all words of query. Every
+// word has to match the name, provides, summary or description.
+func Search(repos []Repository, query string, limit int) ([]SearchResult, []string) {
+	terms := strings.Fields(strings.ToLower(query))
+	var results []SearchResult
+	var repoErrors []string
+	for _, repo := range repos {
+		packages, err := RepositoryPackages(repo)
+		if err != nil {
+			repoErrors = append(repoErrors, err.Error())
+			continue
+		}
+		for _, pkg := range packages {
+			result := SearchResult{Package: pkg}
+			for _, term := range terms {
+				score, field := scoreTerm(pkg, term)
+				if score == 0 {
+					result.Score = 0
+					break
+				}
+				result.Score += score
+				result.Matched = append(result.Matched, term+": "+field)
+			}
+			if result.Score > 0 {
+				results = append(results, result)
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return utils.CompareEVR(a.Version, b.Version) > 0
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, repoErrors
+}
+
+func addToolsToMCPServer() {
+	mcpToolSearch := mcp.NewTool("repomd_search",
+		mcp.WithDescription("Search packages in the cached metadata of the enabled repositories, ranked by how well name, provides, summary and description match; tolerates typos in package names. Works offline and while zypper is running, but only knows what the last zypper refresh downloaded."),
+		mcp.WithString("query", mcp.Required(), mcp.Description("Words to search for, all of them have to match")),
+		mcp.WithString("repository", mcp.Description("Alias of a single repository to search in")),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Maximum number of results, default %d", defaultSearchLimit))),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolSearch, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		query, _ := req.GetArguments()["query"].(string)
+		if strings.TrimSpace(query) == "" {
+			return mcp.NewToolResultError("query is required"), nil
+		}
+		alias, _ := req.GetArguments()["repository"].(string)
+		limit := defaultSearchLimit
+		if number, ok := req.GetArguments()["limit"].(float64); ok && number > 0 {
+			limit = int(number)
+		}
+		repos, err := ListRepositories()
+		if err != nil {
+			return nil, err
+		}
+		var searched []Repository
+		var skipped []Repository
+		for _, repo := range repos {
+			if alias != "" && repo.Alias != alias {
+				continue
+			}
+			if repo.Enabled && repo.CacheError == "" {
+				searched = append(searched, repo)
+			} else if repo.Enabled {
+				skipped = append(skipped, repo)
+			}
+		}
+		if alias != "" && len(searched) == 0 && len(skipped) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("no enabled repository %s", alias)), nil
+		}
+		results, repoErrors := Search(searched, query, limit)
+		return utils.JSONToolResult(map[string]any{
+			"results":              results,
+			"searched":             searched,
+			"skipped_repositories": skipped,
+			"errors":               repoErrors,
+		})
+	})
+	for _, toolName := range toolNames {
+		utils.RecordRegisteredTool(toolName)
+	}
+}
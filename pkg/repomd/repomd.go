@@ -0,0 +1,251 @@
+package repomd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var repomdDebug bool
+
+// Defaults of reposdir and metadatadir in zypp.conf
+const (
+	zyppReposDir = "/etc/zypp/repos.d"
+	zyppRawCache = "/var/cache/zypp/raw"
+)
+
+// Repository is a repository of /etc/zypp/repos.d together with the
+// state of its cached metadata.
+type Repository struct {
+	Alias    string `json:"alias"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type,omitempty"`
+	BaseURL  string `json:"baseurl,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	Priority int    `json:"priority"`
+	// set from repomd.xml, empty without cached rpm-md metadata
+	Revision    string    `json:"revision,omitempty"`
+	CachedAt    time.Time `json:"cached_at,omitempty"`
+	CacheError  string    `json:"cache_error,omitempty"`
+	metadataDir string
+	data        map[string]string
+}
+
+type repomdData struct {
+	Type     string `xml:"type,attr"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+}
+
+type repomdFile struct {
+	Revision string       `xml:"revision"`
+	Data     []repomdData `xml:"data"`
+}
+
+// parseRepoFile parses a .repo file of zypp, an INI file with one
+// section per repository alias.
+func parseRepoFile(reader io.Reader) []Repository {
+	var repos []Repository
+	var current *Repository
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			// zypp defaults: enabled, priority 99
+			repos = append(repos, Repository{Alias: line[1 : len(line)-1], Enabled: true, Priority: 99})
+			current = &repos[len(repos)-1]
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || current == nil {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "name":
+			current.Name = value
+		case "type":
+			current.Type = value
+		case "baseurl":
+			if current.BaseURL == "" {
+				current.BaseURL = value
+			}
+		case "enabled":
+			current.Enabled = value == "1" || value == "yes" || value == "true"
+		case "priority":
+			if priority, err := strconv.Atoi(value); err == nil {
+				current.Priority = priority
+			}
+		}
+	}
+	return repos
+}
+
+// ListRepositories returns the repositories of repos.d, sorted by
+// priority and alias, with the state of their cached rpm-md metadata.
+func ListRepositories() ([]Repository, error) {
+	files, err := filepath.Glob(filepath.Join(zyppReposDir, "*.repo"))
+	if err != nil {
+		return nil, err
+	}
+	var repos []Repository
+	for _, file := range files {
+		content, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		repos = append(repos, parseRepoFile(content)...)
+		content.Close()
+	}
+	for i := range repos {
+		repos[i].loadRepomd()
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		if repos[i].Priority != repos[j].Priority {
+			return repos[i].Priority < repos[j].Priority
+		}
+		return repos[i].Alias < repos[j].Alias
+	})
+	return repos, nil
+}
+
+// EnabledRepositories returns the enabled repositories with cached
+// metadata.
+func EnabledRepositories() ([]Repository, error) {
+	repos, err := ListRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var enabled []Repository
+	for _, repo := range repos {
+		if repo.Enabled && repo.CacheError == "" {
+			enabled = append(enabled, repo)
+		}
+	}
+	return enabled, nil
+}
+
+func (repo *Repository) loadRepomd() {
+	repo.metadataDir = filepath.Join(zyppRawCache, repo.Alias)
+	repomdPath := filepath.Join(repo.metadataDir, "repodata", "repomd.xml")
+	info, err := os.Stat(repomdPath)
+	if err != nil {
+		repo.CacheError = "no cached rpm-md metadata, run zypper refresh"
+		return
+	}
+	content, err := os.ReadFile(repomdPath)
+	if err != nil {
+		repo.CacheError = err.Error()
+		return
+	}
+	var repomd repomdFile
+	if err := xml.Unmarshal(content, &repomd); err != nil {
+		repo.CacheError = fmt.Sprintf("invalid repomd.xml: %v", err)
+		return
+	}
+	repo.Revision = repomd.Revision
+	repo.CachedAt = info.ModTime()
+	repo.data = make(map[string]string)
+	for _, data := range repomd.Data {
+		repo.data[data.Type] = data.Location.Href
+	}
+}
+
+type decompressingReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (reader *decompressingReader) Close() error {
+	var err error
+	for i := len(reader.closers) - 1; i >= 0; i-- {
+		if closeErr := reader.closers[i](); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// OpenData opens the cached metadata file of dataType (primary,
+// updateinfo, ...), decompressed from .gz or .zst.
+func (repo *Repository) OpenData(dataType string) (io.ReadCloser, error) {
+	href, ok := repo.data[dataType]
+	if !ok {
+		return nil, fmt.Errorf("repository %s has no %s metadata", repo.Alias, dataType)
+	}
+	dataPath := filepath.Join(repo.metadataDir, filepath.Clean("/"+href))
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	reader := &decompressingReader{Reader: file, closers: []func() error{file.Close}}
+	switch {
+	case strings.HasSuffix(href, ".gz"):
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader.Reader = gzipReader
+		reader.closers = append(reader.closers, gzipReader.Close)
+	case strings.HasSuffix(href, ".zst"):
+		zstdReader, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader.Reader = zstdReader
+		reader.closers = append(reader.closers, func() error { zstdReader.Close(); return nil })
+	case strings.HasSuffix(href, ".xz"), strings.HasSuffix(href, ".bz2"):
+		file.Close()
+		return nil, errors.New("unsupported compression of " + href)
+	}
+	return reader, nil
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		repomdDebug = debugMode == utils.Debug
+		if !utils.MatchesDistribution([]string{"suse"}) {
+			for _, toolName := range toolNames {
+				utils.RecordSkippedTool(toolName, "zypper", "not a SUSE distribution")
+			}
+			return
+		}
+		if _, err := os.Stat(zyppReposDir); err != nil {
+			for _, toolName := range toolNames {
+				utils.RecordSkippedTool(toolName, "zypper", "no "+zyppReposDir)
+			}
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-repomd")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if repomdDebug {
+			sysLog.Info("reading cached repository metadata from " + zyppRawCache)
+		}
+		addToolsToMCPServer()
+	}
+}
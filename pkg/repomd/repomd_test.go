@@ -0,0 +1,279 @@
+package repomd
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixtureRepository(alias string, priority int) Repository {
+	return Repository{
+		Alias:       alias,
+		Enabled:     true,
+		Priority:    priority,
+		Revision:    "1719316801",
+		metadataDir: "testdata",
+		data: map[string]string{
+			"primary":    "repodata/primary.xml",
+			"updateinfo": "repodata/updateinfo.xml",
+		},
+	}
+}
+
+func TestParsePrimary(t *testing.T) {
+	file, err := os.Open("testdata/repodata/primary.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	packages, err := parsePrimary(file, fixtureRepository("updates", 99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nevras []string
+	for _, pkg := range packages {
+		nevras = append(nevras, pkg.Name+"-"+pkg.Version+"."+pkg.Arch)
+	}
+	// the source package is left out, epoch 0 is not shown
+	want := []string{
+		"vim-9.1.0330-150500.20.12.1.x86_64",
+		"vim-9.0.2103-150500.20.6.1.x86_64",
+		"vim-data-common-9.1.0330-150500.20.12.1.noarch",
+		"neovim-1:0.9.5-1.2.x86_64",
+		"nano-7.2-150600.1.4.x86_64",
+	}
+	if !reflect.DeepEqual(nevras, want) {
+		t.Fatalf("packages %v, want %v", nevras, want)
+	}
+	vim := packages[0]
+	if vim.Repository != "updates" || vim.License != "Vim" || vim.Vendor != "SUSE LLC <https://www.suse.com/>" ||
+		vim.SourceRPM != "vim-9.1.0330-150500.20.12.1.src.rpm" || vim.Location != "x86_64/vim-9.1.0330-150500.20.12.1.x86_64.rpm" {
+		t.Errorf("vim %+v", vim)
+	}
+	// files count as provides
+	if want := []string{"vim", "vi", "/usr/bin/vim"}; !reflect.DeepEqual(vim.Provides, want) {
+		t.Errorf("provides %v, want %v", vim.Provides, want)
+	}
+	if want := []string{"vim-data-common", "libc.so.6()(64bit)"}; !reflect.DeepEqual(vim.Requires, want) {
+		t.Errorf("requires %v, want %v", vim.Requires, want)
+	}
+}
+
+func TestParsePrimaryBroken(t *testing.T) {
+	for _, content := range []string{
+		"<metadata><package type=\"rpm\"><name>vim</name>",
+		"<metadata><package type=\"rpm\"><name>vim</nam></package></metadata>",
+	} {
+		if _, err := parsePrimary(strings.NewReader(content), Repository{}); err == nil {
+			t.Errorf("parsePrimary(%q) accepted", content)
+		}
+	}
+}
+
+func TestParseUpdateinfo(t *testing.T) {
+	file, err := os.Open("testdata/repodata/updateinfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	updates, err := parseUpdateinfo(file, fixtureRepository("updates", 99))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Update{
+		{
+			ID:         "SUSE-SLE-Product-SLES-15-SP6-2024-2188",
+			Type:       "security",
+			Severity:   "important",
+			Title:      "Security update for vim",
+			Repository: "updates",
+			CVEs:       []string{"CVE-2024-22667", "CVE-2024-41957"},
+			Packages: []UpdatePackage{
+				{Name: "vim", Version: "9.1.0330-150500.20.12.1", Arch: "x86_64"},
+				{Name: "vim-data-common", Version: "9.1.0330-150500.20.12.1", Arch: "noarch"},
+			},
+		},
+		{
+			ID:         "SUSE-SLE-Product-SLES-15-SP6-2024-2200",
+			Type:       "recommended",
+			Severity:   "moderate",
+			Title:      "Recommended update for nano",
+			Repository: "updates",
+			Packages:   []UpdatePackage{{Name: "nano", Version: "2:7.2-150600.1.4", Arch: "x86_64"}},
+		},
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates\n%+v\nwant\n%+v", updates, want)
+	}
+}
+
+func TestOpenDataCompressed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "repodata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile("testdata/repodata/primary.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(dir, "repodata", "primary.xml.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(file)
+	writer.Write(content)
+	writer.Close()
+	file.Close()
+	repo := Repository{Alias: "gz", metadataDir: dir, data: map[string]string{"primary": "repodata/primary.xml.gz"}}
+	reader, err := repo.OpenData("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	packages, err := parsePrimary(reader, repo)
+	if err != nil || len(packages) != 5 {
+		t.Errorf("%d packages, %v", len(packages), err)
+	}
+	if _, err := repo.OpenData("updateinfo"); err == nil {
+		t.Error("missing updateinfo opened")
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"vim", "vim", 2, 0},
+		{"vim", "vin", 2, 1},
+		{"nginx", "ngnix", 2, 2},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3},
+		{"a", "abcdef", 2, 3},
+	} {
+		if got := levenshtein(tc.a, tc.b, tc.max); got != tc.want {
+			t.Errorf("levenshtein(%q, %q, %d) = %d, want %d", tc.a, tc.b, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestScoreTerm(t *testing.T) {
+	pkg := Package{
+		Name:        "vim-data-common",
+		Summary:     "Common Data Files for Vim",
+		Description: "Data files needed by every variant of the editor.",
+		Provides:    []string{"vim-data", "/usr/share/vim/vimrc"},
+	}
+	for _, tc := range []struct {
+		term  string
+		score int
+		field string
+	}{
+		{"vim-data-common", 100, "name"},
+		{"vim", 70, "name"},
+		{"common", 50, "name"},
+		{"vim-data", 70, "name"},
+		{"vimrc", 15, "provides"},
+		{"files", 25, "summary"},
+		{"variant", 10, "description"},
+		{"emacs", 0, ""},
+	} {
+		score, field := scoreTerm(pkg, tc.term)
+		if score != tc.score || field != tc.field {
+			t.Errorf("scoreTerm(%q) = %d %q, want %d %q", tc.term, score, field, tc.score, tc.field)
+		}
+	}
+	// exact provides before fuzzy names
+	if score, field := scoreTerm(Package{Name: "neovim", Provides: []string{"nvim"}}, "nvim"); score != 45 || field != "provides" {
+		t.Errorf("provides: %d %q", score, field)
+	}
+	if score, field := scoreTerm(Package{Name: "neovim"}, "neovym"); score != 30 || field != "name (fuzzy)" {
+		t.Errorf("fuzzy: %d %q", score, field)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	results, repoErrors := Search([]Repository{fixtureRepository("updates", 99)}, "vim", 0)
+	if len(repoErrors) != 0 {
+		t.Fatal(repoErrors)
+	}
+	var ranked []string
+	for _, result := range results {
+		ranked = append(ranked, result.Name+"-"+result.Version)
+	}
+	// exact name, newest version first, then prefix, then contains
+	want := []string{
+		"vim-9.1.0330-150500.20.12.1",
+		"vim-9.0.2103-150500.20.6.1",
+		"vim-data-common-9.1.0330-150500.20.12.1",
+		"neovim-1:0.9.5-1.2",
+	}
+	if !reflect.DeepEqual(ranked, want) {
+		t.Errorf("ranked %v, want %v", ranked, want)
+	}
+
+	// all terms have to match
+	results, _ = Search([]Repository{fixtureRepository("updates", 99)}, "vim common", 0)
+	if len(results) != 1 || results[0].Name != "vim-data-common" || len(results[0].Matched) != 2 {
+		t.Errorf("vim common: %+v", results)
+	}
+
+	// the same package of a repository with a better priority first
+	results, _ = Search([]Repository{fixtureRepository("updates", 99), fixtureRepository("pool", 10)}, "nano", 1)
+	if len(results) != 1 || results[0].Repository != "pool" {
+		t.Errorf("nano: %+v", results)
+	}
+
+	results, repoErrors = Search([]Repository{{Alias: "broken", metadataDir: "testdata", data: map[string]string{"primary": "repodata/missing.xml"}}}, "vim", 0)
+	if len(results) != 0 || len(repoErrors) != 1 {
+		t.Errorf("missing metadata: %v %v", results, repoErrors)
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	maxIdle, maxItems := metadataCacheMaxIdle, metadataCacheMaxItems
+	defer func() { metadataCacheMaxIdle, metadataCacheMaxItems = maxIdle, maxItems }()
+	metadataCacheMaxIdle, metadataCacheMaxItems = time.Hour, 5
+
+	cache := newMetadataCache[int]()
+	loads := 0
+	load := func(count int) func() ([]int, error) {
+		return func() ([]int, error) {
+			loads++
+			return make([]int, count), nil
+		}
+	}
+	cache.get("a", "1", load(2))
+	cache.get("a", "1", load(2))
+	if loads != 1 {
+		t.Errorf("%d loads of the same revision", loads)
+	}
+	// a new revision replaces the old one
+	cache.get("a", "2", load(2))
+	if loads != 2 || len(cache.entries) != 1 {
+		t.Errorf("%d loads, %d entries", loads, len(cache.entries))
+	}
+	// above the item limit the least recently used entry goes
+	cache.get("b", "1", load(2))
+	cache.get("a", "2", load(2))
+	cache.get("c", "1", load(2))
+	if _, ok := cache.entries["b"]; ok || len(cache.entries) != 2 {
+		t.Errorf("entries after eviction: %v", cache.entries)
+	}
+	// an entry above the limit is kept while it is the latest
+	cache.get("d", "1", load(10))
+	if len(cache.entries) != 1 || cache.entries["d"] == nil {
+		t.Errorf("entries with a big entry: %v", cache.entries)
+	}
+	// idle entries expire
+	cache.entries["d"].lastUsed = time.Now().Add(-2 * time.Hour)
+	cache.expire()
+	if len(cache.entries) != 0 || cache.timer != nil {
+		t.Errorf("entries after expiry: %v", cache.entries)
+	}
+}
@@ -0,0 +1,109 @@
+package repomd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type updateinfoUpdate struct {
+	Type       string `xml:"type,attr"`
+	ID         string `xml:"id"`
+	Title      string `xml:"title"`
+	Severity   string `xml:"severity"`
+	References []struct {
+		ID   string `xml:"id,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"references>reference"`
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Epoch   string `xml:"epoch,attr"`
+		Version string `xml:"version,attr"`
+		Release string `xml:"release,attr"`
+		Arch    string `xml:"arch,attr"`
+	} `xml:"pkglist>collection>package"`
+}
+
+// Update is a patch of the cached updateinfo metadata of a repository.
+type Update struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Severity   string          `json:"severity,omitempty"`
+	Title      string          `json:"title,omitempty"`
+	Repository string          `json:"repository"`
+	CVEs       []string        `json:"cves,omitempty"`
+	Packages   []UpdatePackage `json:"packages,omitempty"`
+}
+
+type UpdatePackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+}
+
+// parseUpdateinfo reads the updates of updateinfo.xml one by one.
+func parseUpdateinfo(reader io.Reader, repo Repository) ([]Update, error) {
+	var updates []Update
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return updates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "update" {
+			continue
+		}
+		var info updateinfoUpdate
+		if err := decoder.DecodeElement(&info, &start); err != nil {
+			return nil, err
+		}
+		update := Update{
+			ID:         strings.TrimSpace(info.ID),
+			Type:       info.Type,
+			Severity:   strings.ToLower(strings.TrimSpace(info.Severity)),
+			Title:      strings.TrimSpace(info.Title),
+			Repository: repo.Alias,
+		}
+		for _, reference := range info.References {
+			if reference.Type == "cve" {
+				update.CVEs = append(update.CVEs, reference.ID)
+			}
+		}
+		for _, pkg := range info.Packages {
+			update.Packages = append(update.Packages, UpdatePackage{
+				Name:    pkg.Name,
+				Version: formatEVR(pkg.Epoch, pkg.Version, pkg.Release),
+				Arch:    pkg.Arch,
+			})
+		}
+		updates = append(updates, update)
+	}
+}
+
+var updateinfoCache = newMetadataCache[Update]()
+
+// RepositoryUpdates returns the patches of the cached updateinfo metadata
+// of repo, repositories without patches return none.
+func RepositoryUpdates(repo Repository) ([]Update, error) {
+	if _, ok := repo.data["updateinfo"]; !ok {
+		return nil, nil
+	}
+	key := repo.Revision + "\x00" + repo.data["updateinfo"]
+	return updateinfoCache.get(repo.Alias, key, func() ([]Update, error) {
+		reader, err := repo.OpenData("updateinfo")
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		updates, err := parseUpdateinfo(reader, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", repo.Alias, err)
+		}
+		return updates, nil
+	})
+}
@@ -0,0 +1,187 @@
+package rpmdb
+
+import (
+	"strings"
+	"sync"
+)
+
+// Dependency tags of the rpm header, see rpmtag.h
+const (
+	tagProvideName       = 1047
+	tagRequireFlags      = 1048
+	tagRequireName       = 1049
+	tagRequireVersion    = 1050
+	tagProvideFlags      = 1112
+	tagProvideVersion    = 1113
+	tagRecommendName     = 5046
+	tagRecommendVersion  = 5047
+	tagRecommendFlags    = 5048
+	tagSupplementName    = 5052
+	tagSupplementVersion = 5053
+	tagSupplementFlags   = 5054
+)
+
+// RPMSENSE_* flags of the dependency entries
+const (
+	senseLess    = 1 << 1
+	senseGreater = 1 << 2
+	senseEqual   = 1 << 3
+	senseRPMLib  = 1 << 24
+)
+
+// Dependency is a capability with an optional version comparison, rich
+// dependencies like "(a if b)" are kept as they are in Name.
+type Dependency struct {
+	Name     string `json:"name"`
+	Operator string `json:"operator,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// PackageDependencies are the dependencies of an installed package, Files
+// only holds the files other packages require.
+type PackageDependencies struct {
+	Package     InstalledPackage `json:"package"`
+	Provides    []Dependency     `json:"provides"`
+	Requires    []Dependency     `json:"requires"`
+	Recommends  []Dependency     `json:"recommends,omitempty"`
+	Supplements []Dependency     `json:"supplements,omitempty"`
+	Files       []string         `json:"files,omitempty"`
+}
+
+var dependenciesCacheMutex sync.Mutex
+var dependenciesCache []PackageDependencies
+var dependenciesCacheKey string
+
+func (dependency Dependency) String() string {
+	if dependency.Operator == "" {
+		return dependency.Name
+	}
+	return dependency.Name + " " + dependency.Operator + " " + dependency.Version
+}
+
+// IsRich is true for boolean dependencies of rpm >= 4.13.
+func (dependency Dependency) IsRich() bool {
+	return strings.HasPrefix(dependency.Name, "(")
+}
+
+// Capabilities returns the capability names of a dependency, for rich
+// dependencies all names in it, without the operators and versions.
+func (dependency Dependency) Capabilities() []string {
+	if !dependency.IsRich() {
+		return []string{dependency.Name}
+	}
+	var names []string
+	skipVersion := false
+	for _, token := range strings.Fields(dependency.Name) {
+		// only the unbalanced parentheses group, "libc.so.6()(64bit)" is a name
+		token = strings.TrimLeft(token, "(")
+		for strings.HasSuffix(token, ")") && strings.Count(token, ")") > strings.Count(token, "(") {
+			token = strings.TrimSuffix(token, ")")
+		}
+		switch token {
+		case "":
+			continue
+		case "and", "or", "if", "else", "with", "without", "unless":
+			continue
+		case "<", "<=", "=", ">=", ">":
+			skipVersion = true
+			continue
+		}
+		if skipVersion {
+			skipVersion = false
+			continue
+		}
+		names = append(names, token)
+	}
+	return names
+}
+
+func senseOperator(flags int64) string {
+	operator := ""
+	if flags&senseLess != 0 {
+		operator += "<"
+	}
+	if flags&senseGreater != 0 {
+		operator += ">"
+	}
+	if flags&senseEqual != 0 {
+		operator += "="
+	}
+	return operator
+}
+
+func dependenciesFromHeader(header *rpmHeader, nameTag int32, flagsTag int32, versionTag int32) []Dependency {
+	names := header.strings(nameTag)
+	flags := header.ints(flagsTag)
+	versions := header.strings(versionTag)
+	dependencies := make([]Dependency, 0, len(names))
+	for i, name := range names {
+		dependency := Dependency{Name: name}
+		if i < len(flags) {
+			if flags[i]&senseRPMLib != 0 || strings.HasPrefix(name, "rpmlib(") {
+				continue
+			}
+			dependency.Operator = senseOperator(flags[i])
+		}
+		if i < len(versions) && dependency.Operator != "" {
+			dependency.Version = versions[i]
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	return dependencies
+}
+
+// Dependencies returns provides, requires, recommends and supplements of
+// all installed packages, in the order of the database. The list is kept
+// until the database changes.
+func (db *Database) Dependencies() ([]PackageDependencies, error) {
+	dependenciesCacheMutex.Lock()
+	defer dependenciesCacheMutex.Unlock()
+	key := db.cacheKey()
+	if dependenciesCache != nil && key == dependenciesCacheKey {
+		return dependenciesCache, nil
+	}
+	var packages []PackageDependencies
+	requiredFiles := make(map[string]bool)
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		pkg := PackageDependencies{
+			Package:     packageFromHeader(header, false),
+			Provides:    dependenciesFromHeader(header, tagProvideName, tagProvideFlags, tagProvideVersion),
+			Requires:    dependenciesFromHeader(header, tagRequireName, tagRequireFlags, tagRequireVersion),
+			Recommends:  dependenciesFromHeader(header, tagRecommendName, tagRecommendFlags, tagRecommendVersion),
+			Supplements: dependenciesFromHeader(header, tagSupplementName, tagSupplementFlags, tagSupplementVersion),
+		}
+		for _, require := range pkg.Requires {
+			if strings.HasPrefix(require.Name, "/") {
+				requiredFiles[require.Name] = true
+			}
+		}
+		packages = append(packages, pkg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the files are only known after all requires, so a second pass
+	index := 0
+	if len(requiredFiles) > 0 {
+		err = db.forEachHeader(func(header *rpmHeader) error {
+			if index >= len(packages) {
+				return nil
+			}
+			for _, name := range header.fileNames() {
+				if requiredFiles[name] {
+					packages[index].Files = append(packages[index].Files, name)
+				}
+			}
+			index++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	dependenciesCache = packages
+	dependenciesCacheKey = key
+	return packages, nil
+}
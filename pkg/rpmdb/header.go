@@ -0,0 +1,206 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Tags of the rpm header, see rpmtag.h
+const (
+	tagName          = 1000
+	tagVersion       = 1001
+	tagRelease       = 1002
+	tagEpoch         = 1003
+	tagSummary       = 1004
+	tagDescription   = 1005
+	tagBuildTime     = 1006
+	tagInstallTime   = 1008
+	tagSize          = 1009
+	tagVendor        = 1011
+	tagLicense       = 1014
+	tagPackager      = 1015
+	tagGroup         = 1016
+	tagURL           = 1020
+	tagArch          = 1022
+	tagFileSizes     = 1028
+	tagFileModes     = 1030
+	tagFileFlags     = 1037
+	tagSourceRPM     = 1044
+	tagDirIndexes    = 1116
+	tagBaseNames     = 1117
+	tagDirNames      = 1118
+	tagLongSize      = 5009
+	tagLongFileSizes = 5008
+	tagOldFileNames  = 1027
+)
+
+// Types of header entries
+const (
+	typeNull        = 0
+	typeChar        = 1
+	typeInt8        = 2
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+// RPMFILE_* flags of tagFileFlags
+const (
+	fileFlagConfig = 1 << 0
+	fileFlagDoc    = 1 << 1
+	fileFlagGhost  = 1 << 6
+)
+
+// limits of headerImport() in librpm
+const (
+	headerMaxEntries = 0xffff
+	headerMaxData    = 256 * 1024 * 1024
+)
+
+type headerEntry struct {
+	tag    int32
+	typ    uint32
+	offset int32
+	count  uint32
+}
+
+// rpmHeader is a header blob as stored in the rpm database: the number of
+// entries and the size of the data, the index entries and the data, all
+// big-endian.
+type rpmHeader struct {
+	entries map[int32]headerEntry
+	data    []byte
+}
+
+func parseHeader(blob []byte) (*rpmHeader, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("header too short")
+	}
+	indexCount := binary.BigEndian.Uint32(blob[0:4])
+	dataSize := binary.BigEndian.Uint32(blob[4:8])
+	if indexCount == 0 || indexCount > headerMaxEntries || dataSize > headerMaxData {
+		return nil, errors.New("invalid header size")
+	}
+	dataStart := 8 + 16*int(indexCount)
+	if len(blob) < dataStart+int(dataSize) {
+		return nil, errors.New("header truncated")
+	}
+	header := &rpmHeader{
+		entries: make(map[int32]headerEntry, indexCount),
+		data:    blob[dataStart : dataStart+int(dataSize)],
+	}
+	for i := 0; i < int(indexCount); i++ {
+		index := blob[8+16*i : 8+16*(i+1)]
+		entry := headerEntry{
+			tag:    int32(binary.BigEndian.Uint32(index[0:4])),
+			typ:    binary.BigEndian.Uint32(index[4:8]),
+			offset: int32(binary.BigEndian.Uint32(index[8:12])),
+			count:  binary.BigEndian.Uint32(index[12:16]),
+		}
+		if entry.offset < 0 || int(entry.offset) > len(header.data) {
+			return nil, fmt.Errorf("tag %d out of header data", entry.tag)
+		}
+		header.entries[entry.tag] = entry
+	}
+	return header, nil
+}
+
+// strings returns the count NUL-terminated strings of a string entry.
+func (header *rpmHeader) strings(tag int32) []string {
+	entry, ok := header.entries[tag]
+	if !ok || (entry.typ != typeString && entry.typ != typeStringArray && entry.typ != typeI18NString) {
+		return nil
+	}
+	data := header.data[entry.offset:]
+	// every string takes at least its NUL byte
+	values := make([]string, 0, min(int(entry.count), len(data)))
+	for i := uint32(0); i < entry.count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		values = append(values, string(data[:end]))
+		data = data[end+1:]
+	}
+	return values
+}
+
+// string returns a string entry, of I18N strings the untranslated one.
+func (header *rpmHeader) string(tag int32) string {
+	if values := header.strings(tag); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ints returns an integer entry of any size.
+func (header *rpmHeader) ints(tag int32) []int64 {
+	entry, ok := header.entries[tag]
+	if !ok {
+		return nil
+	}
+	var size int
+	switch entry.typ {
+	case typeChar, typeInt8:
+		size = 1
+	case typeInt16:
+		size = 2
+	case typeInt32:
+		size = 4
+	case typeInt64:
+		size = 8
+	default:
+		return nil
+	}
+	data := header.data[entry.offset:]
+	if uint64(len(data)) < uint64(entry.count)*uint64(size) {
+		return nil
+	}
+	values := make([]int64, entry.count)
+	for i := range values {
+		switch size {
+		case 1:
+			values[i] = int64(data[i])
+		case 2:
+			values[i] = int64(binary.BigEndian.Uint16(data[2*i:]))
+		case 4:
+			values[i] = int64(binary.BigEndian.Uint32(data[4*i:]))
+		case 8:
+			values[i] = int64(binary.BigEndian.Uint64(data[8*i:]))
+		}
+	}
+	return values
+}
+
+func (header *rpmHeader) int(tag int32) (int64, bool) {
+	if values := header.ints(tag); len(values) > 0 {
+		return values[0], true
+	}
+	return 0, false
+}
+
+// fileNames returns the paths of all files, from base and dir names or
+// from the old file names of rpm < 4.
+func (header *rpmHeader) fileNames() []string {
+	baseNames := header.strings(tagBaseNames)
+	if len(baseNames) == 0 {
+		return header.strings(tagOldFileNames)
+	}
+	dirNames := header.strings(tagDirNames)
+	dirIndexes := header.ints(tagDirIndexes)
+	names := make([]string, 0, len(baseNames))
+	for i, baseName := range baseNames {
+		if i >= len(dirIndexes) || dirIndexes[i] < 0 || int(dirIndexes[i]) >= len(dirNames) {
+			names = append(names, baseName)
+			continue
+		}
+		names = append(names, dirNames[dirIndexes[i]]+baseName)
+	}
+	return names
+}
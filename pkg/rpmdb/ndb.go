@@ -0,0 +1,92 @@
+package rpmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reader for Packages.db of the ndb backend of rpm, the default on
+// openSUSE and SLE 15. See lib/backend/ndb/rpmpkg.c of rpm.
+
+const (
+	ndbPageSize     = 4096
+	ndbSlotSize     = 16
+	ndbBlockSize    = 16
+	ndbBlobHeadSize = 16
+	// the database header takes the first two slots
+	ndbHeaderSlots = 2
+)
+
+// magics, little-endian
+var (
+	ndbMagic         = binary.LittleEndian.Uint32([]byte("RpmP"))
+	ndbSlotMagic     = binary.LittleEndian.Uint32([]byte("Slot"))
+	ndbBlobHeadMagic = binary.LittleEndian.Uint32([]byte("BlbS"))
+)
+
+// scanNDB calls fn with the package index and the header blob of every
+// package in the ndb Packages.db at path.
+func scanNDB(path string, fn func(index uint32, blob []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, ndbPageSize)
+	if _, err := file.ReadAt(header, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != ndbMagic {
+		return fmt.Errorf("%s: not an ndb package database", path)
+	}
+	slotPages := binary.LittleEndian.Uint32(header[12:16])
+	if slotPages == 0 || slotPages > 2048 {
+		return fmt.Errorf("%s: invalid number of slot pages %d", path, slotPages)
+	}
+	slots := make([]byte, int(slotPages)*ndbPageSize)
+	if _, err := file.ReadAt(slots, 0); err != nil && err != io.EOF {
+		return err
+	}
+	for offset := ndbHeaderSlots * ndbSlotSize; offset+ndbSlotSize <= len(slots); offset += ndbSlotSize {
+		slot := slots[offset : offset+ndbSlotSize]
+		if binary.LittleEndian.Uint32(slot[0:4]) != ndbSlotMagic {
+			return fmt.Errorf("%s: invalid slot at %d", path, offset)
+		}
+		index := binary.LittleEndian.Uint32(slot[4:8])
+		if index == 0 {
+			// free slot
+			continue
+		}
+		blockOffset := int64(binary.LittleEndian.Uint32(slot[8:12])) * ndbBlockSize
+		blockCount := int64(binary.LittleEndian.Uint32(slot[12:16]))
+		blob, err := readNDBBlob(file, index, blockOffset, blockCount*ndbBlockSize)
+		if err != nil {
+			return fmt.Errorf("%s: package %d: %v", path, index, err)
+		}
+		if err := fn(index, blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readNDBBlob(file *os.File, index uint32, offset int64, size int64) ([]byte, error) {
+	if size < ndbBlobHeadSize || size > headerMaxData {
+		return nil, errors.New("invalid blob size")
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(data[0:4]) != ndbBlobHeadMagic || binary.LittleEndian.Uint32(data[4:8]) != index {
+		return nil, errors.New("invalid blob header")
+	}
+	blobSize := int64(binary.LittleEndian.Uint32(data[12:16]))
+	if ndbBlobHeadSize+blobSize > size {
+		return nil, errors.New("blob exceeds its blocks")
+	}
+	return data[ndbBlobHeadSize : ndbBlobHeadSize+blobSize], nil
+}
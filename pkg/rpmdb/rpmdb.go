@@ -0,0 +1,426 @@
+package rpmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var rpmdbDebug bool
+
+// Locations of the rpm database, /var/lib/rpm is the old %_dbpath and
+// usually a symlink today. The Berkeley DB format of old releases is not
+// supported.
+var databasePaths = []struct {
+	path    string
+	backend string
+}{
+	{"/usr/lib/sysimage/rpm/rpmdb.sqlite", "sqlite"},
+	{"/var/lib/rpm/rpmdb.sqlite", "sqlite"},
+	{"/usr/lib/sysimage/rpm/Packages.db", "ndb"},
+	{"/var/lib/rpm/Packages.db", "ndb"},
+}
+
+// rpm stores the headers in the table Packages (hnum, blob)
+const sqlitePackagesTable = "Packages"
+
+var toolNames = []string{"rpmdb_list_packages", "rpmdb_package_info", "rpmdb_file_owner", "rpmdb_package_files"}
+
+type InstalledPackage struct {
+	Name        string    `json:"name"`
+	Epoch       int64     `json:"epoch,omitempty"`
+	Version     string    `json:"version"`
+	Release     string    `json:"release"`
+	Arch        string    `json:"arch,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Vendor      string    `json:"vendor,omitempty"`
+	License     string    `json:"license,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Packager    string    `json:"packager,omitempty"`
+	SourceRPM   string    `json:"source_rpm,omitempty"`
+	Size        int64     `json:"size"`
+	BuildTime   time.Time `json:"build_time"`
+	InstallTime time.Time `json:"install_time"`
+}
+
+type PackageFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	Config bool   `json:"config,omitempty"`
+	Doc    bool   `json:"doc,omitempty"`
+	Ghost  bool   `json:"ghost,omitempty"`
+}
+
+// EVR returns [epoch:]version-release.
+func (pkg InstalledPackage) EVR() string {
+	if pkg.Epoch != 0 {
+		return fmt.Sprintf("%d:%s-%s", pkg.Epoch, pkg.Version, pkg.Release)
+	}
+	return pkg.Version + "-" + pkg.Release
+}
+
+// NEVRA returns name-[epoch:]version-release.arch like rpm -q.
+func (pkg InstalledPackage) NEVRA() string {
+	if pkg.Arch == "" {
+		return pkg.Name + "-" + pkg.EVR()
+	}
+	return pkg.Name + "-" + pkg.EVR() + "." + pkg.Arch
+}
+
+// Database is the rpm database of the host, read without librpm and
+// without taking the rpm lock.
+type Database struct {
+	Path    string `json:"path"`
+	Backend string `json:"backend"`
+}
+
+var packagesCacheMutex sync.Mutex
+var packagesCache []InstalledPackage
+var packagesCacheKey string
+
+// Open locates the rpm database of the host.
+func Open() (*Database, error) {
+	for _, candidate := range databasePaths {
+		if _, err := os.Stat(candidate.path); err == nil {
+			return &Database{Path: candidate.path, Backend: candidate.backend}, nil
+		}
+	}
+	return nil, errors.New("no sqlite or ndb rpm database found")
+}
+
+// forEachHeader calls fn with every package header of the database.
+func (db *Database) forEachHeader(fn func(header *rpmHeader) error) error {
+	handleBlob := func(blob []byte) error {
+		header, err := parseHeader(blob)
+		if err != nil {
+			// a single broken header should not hide all packages
+			if rpmdbDebug {
+				sysLog, syslogerr := syslog.New(syslog.LOG_WARNING, "mcp-server-rpmdb")
+				if syslogerr == nil {
+					sysLog.Warning(fmt.Sprintf("%s: %v", db.Path, err))
+					sysLog.Close()
+				}
+			}
+			return nil
+		}
+		return fn(header)
+	}
+	switch db.Backend {
+	case "sqlite":
+		sqlite, err := openSQLite(db.Path)
+		if err != nil {
+			return err
+		}
+		defer sqlite.Close()
+		root, err := sqlite.tableRoot(sqlitePackagesTable)
+		if err != nil {
+			return err
+		}
+		return sqlite.scanTable(root, func(rowid int64, values []any) error {
+			if len(values) < 2 {
+				return nil
+			}
+			blob, ok := values[1].([]byte)
+			if !ok {
+				return nil
+			}
+			return handleBlob(blob)
+		})
+	case "ndb":
+		return scanNDB(db.Path, func(index uint32, blob []byte) error {
+			return handleBlob(blob)
+		})
+	}
+	return fmt.Errorf("unsupported rpm database backend %s", db.Backend)
+}
+
+func packageFromHeader(header *rpmHeader, withDescription bool) InstalledPackage {
+	pkg := InstalledPackage{
+		Name:      header.string(tagName),
+		Version:   header.string(tagVersion),
+		Release:   header.string(tagRelease),
+		Arch:      header.string(tagArch),
+		Summary:   header.string(tagSummary),
+		Vendor:    header.string(tagVendor),
+		License:   header.string(tagLicense),
+		Group:     header.string(tagGroup),
+		URL:       header.string(tagURL),
+		Packager:  header.string(tagPackager),
+		SourceRPM: header.string(tagSourceRPM),
+	}
+	if withDescription {
+		pkg.Description = header.string(tagDescription)
+	}
+	pkg.Epoch, _ = header.int(tagEpoch)
+	if size, ok := header.int(tagLongSize); ok {
+		pkg.Size = size
+	} else {
+		pkg.Size, _ = header.int(tagSize)
+	}
+	if buildTime, ok := header.int(tagBuildTime); ok {
+		pkg.BuildTime = time.Unix(buildTime, 0)
+	}
+	if installTime, ok := header.int(tagInstallTime); ok {
+		pkg.InstallTime = time.Unix(installTime, 0)
+	}
+	return pkg
+}
+
+func filesFromHeader(header *rpmHeader) []PackageFile {
+	names := header.fileNames()
+	sizes := header.ints(tagLongFileSizes)
+	if len(sizes) == 0 {
+		sizes = header.ints(tagFileSizes)
+	}
+	modes := header.ints(tagFileModes)
+	flags := header.ints(tagFileFlags)
+	files := make([]PackageFile, 0, len(names))
+	for i, name := range names {
+		file := PackageFile{Path: name}
+		if i < len(sizes) {
+			file.Size = sizes[i]
+		}
+		if i < len(modes) {
+			file.Mode = strconv.FormatInt(modes[i], 8)
+		}
+		if i < len(flags) {
+			file.Config = flags[i]&fileFlagConfig != 0
+			file.Doc = flags[i]&fileFlagDoc != 0
+			file.Ghost = flags[i]&fileFlagGhost != 0
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// cacheKey changes with every change of the database or its WAL file.
+func (db *Database) cacheKey() string {
+	key := db.Path
+	for _, file := range []string{db.Path, db.Path + "-wal"} {
+		if info, err := os.Stat(file); err == nil {
+			key += fmt.Sprintf(":%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return key
+}
+
+// Packages returns all installed packages sorted by name, without the
+// descriptions. The list is kept until the database changes.
+func (db *Database) Packages() ([]InstalledPackage, error) {
+	packagesCacheMutex.Lock()
+	defer packagesCacheMutex.Unlock()
+	key := db.cacheKey()
+	if packagesCache != nil && key == packagesCacheKey {
+		return packagesCache, nil
+	}
+	var packages []InstalledPackage
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		packages = append(packages, packageFromHeader(header, false))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return utils.CompareEVR(packages[i].EVR(), packages[j].EVR()) < 0
+	})
+	packagesCache = packages
+	packagesCacheKey = key
+	return packages, nil
+}
+
+// PackagesByName returns all installed versions of name with their
+// descriptions.
+func (db *Database) PackagesByName(name string) ([]InstalledPackage, error) {
+	var packages []InstalledPackage
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		if header.string(tagName) == name {
+			packages = append(packages, packageFromHeader(header, true))
+		}
+		return nil
+	})
+	return packages, err
+}
+
+// FileOwners returns the packages containing filePath.
+func (db *Database) FileOwners(filePath string) ([]InstalledPackage, error) {
+	filePath = filepath.Clean(filePath)
+	baseName := filepath.Base(filePath)
+	var packages []InstalledPackage
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		// cheap check on the base names before building the paths
+		found := false
+		for _, name := range header.strings(tagBaseNames) {
+			if name == baseName {
+				found = true
+				break
+			}
+		}
+		if !found && len(header.strings(tagOldFileNames)) == 0 {
+			return nil
+		}
+		for _, name := range header.fileNames() {
+			if name == filePath {
+				packages = append(packages, packageFromHeader(header, false))
+				break
+			}
+		}
+		return nil
+	})
+	return packages, err
+}
+
+// PackageFiles returns the files of every installed version of name,
+// keyed by name-version-release.arch.
+func (db *Database) PackageFiles(name string) (map[string][]PackageFile, error) {
+	files, err := db.FilesOfPackages(func(candidate string) bool { return candidate == name })
+	if files[name] == nil {
+		return make(map[string][]PackageFile), err
+	}
+	return files[name], err
+}
+
+// FilesOfPackages returns the files of the packages whose name matches,
+// read in one pass over the database. They are keyed by the name and
+// then by name-version-release.arch.
+func (db *Database) FilesOfPackages(match func(name string) bool) (map[string]map[string][]PackageFile, error) {
+	files := make(map[string]map[string][]PackageFile)
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		name := header.string(tagName)
+		if !match(name) {
+			return nil
+		}
+		if files[name] == nil {
+			files[name] = make(map[string][]PackageFile)
+		}
+		files[name][packageFromHeader(header, false).NEVRA()] = filesFromHeader(header)
+		return nil
+	})
+	return files, err
+}
+
+func addToolsToMCPServer(db *Database) {
+	mcpToolList := mcp.NewTool("rpmdb_list_packages",
+		mcp.WithDescription("List the installed packages read directly from the rpm database. Fast, does not refresh repositories and does not wait for a running zypper or dnf."),
+		mcp.WithString("pattern", mcp.Description("Shell pattern for the package names, e.g. 'kernel-*', empty for all packages")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pattern, _ := req.GetArguments()["pattern"].(string)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid pattern %q", pattern)), nil
+		}
+		packages, err := db.Packages()
+		if err != nil {
+			return nil, err
+		}
+		matching := []InstalledPackage{}
+		for _, pkg := range packages {
+			if pattern == "" {
+				matching = append(matching, pkg)
+			} else if match, _ := path.Match(pattern, pkg.Name); match {
+				matching = append(matching, pkg)
+			}
+		}
+		return utils.JSONToolResult(map[string]any{"database": db, "packages": matching})
+	})
+
+	mcpToolInfo := mcp.NewTool("rpmdb_package_info",
+		mcp.WithDescription("Show all installed versions of a package with description, vendor, license and install time, read directly from the rpm database."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Exact package name")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolInfo, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, _ := req.GetArguments()["name"].(string)
+		if name == "" {
+			return mcp.NewToolResultError("name is required"), nil
+		}
+		packages, err := db.PackagesByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(packages) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("package %s is not installed", name)), nil
+		}
+		return utils.JSONToolResult(map[string]any{"packages": packages})
+	})
+
+	mcpToolOwner := mcp.NewTool("rpmdb_file_owner",
+		mcp.WithDescription("Find the installed packages which contain a file, like rpm -qf."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("Absolute path of the file")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolOwner, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, _ := req.GetArguments()["path"].(string)
+		if !filepath.IsAbs(filePath) {
+			return mcp.NewToolResultError("path must be absolute"), nil
+		}
+		packages, err := db.FileOwners(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return utils.JSONToolResult(map[string]any{"path": filePath, "owned": len(packages) > 0, "packages": packages})
+	})
+
+	mcpToolFiles := mcp.NewTool("rpmdb_package_files",
+		mcp.WithDescription("List the files of an installed package with size, mode and whether they are config, doc or ghost files, like rpm -ql."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Exact package name")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolFiles, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, _ := req.GetArguments()["name"].(string)
+		if name == "" {
+			return mcp.NewToolResultError("name is required"), nil
+		}
+		files, err := db.PackageFiles(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("package %s is not installed", name)), nil
+		}
+		return utils.JSONToolResult(map[string]any{"packages": files})
+	})
+
+	for _, toolName := range toolNames {
+		utils.RecordRegisteredTool(toolName)
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		rpmdbDebug = debugMode == utils.Debug
+		db, err := Open()
+		if err != nil {
+			for _, toolName := range toolNames {
+				utils.RecordSkippedTool(toolName, "rpmdb", err.Error())
+			}
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-rpmdb")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if rpmdbDebug {
+			sysLog.Info(fmt.Sprintf("reading the %s rpm database %s", db.Backend, db.Path))
+		}
+		addToolsToMCPServer(db)
+	}
+}
@@ -0,0 +1,277 @@
+package rpmdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The packages of testdata/mkfixtures.py, vim is only in the WAL file of
+// the sqlite database.
+func fixtureNEVRAs() []string {
+	nevras := []string{
+		"bash-4.4-150400.27.3.2.x86_64",
+		"filesystem-15.0-11.8.1.noarch",
+		"kernel-default-6.4.0-150600.23.7.3.x86_64",
+		"kernel-default-6.4.0-150600.23.14.2.x86_64",
+		"shadow-2:4.8.1-150400.10.15.1.x86_64",
+		"vim-9.1.0330-150500.20.12.1.x86_64",
+	}
+	for i := 0; i < 40; i++ {
+		nevras = append(nevras, fmt.Sprintf("pkg%02d-1.%d-%d.1.x86_64", i, i, i))
+	}
+	sort.Strings(nevras)
+	return nevras
+}
+
+// readNEVRAs reads the headers directly, without the package cache.
+func readNEVRAs(db *Database) ([]string, error) {
+	var nevras []string
+	err := db.forEachHeader(func(header *rpmHeader) error {
+		nevras = append(nevras, packageFromHeader(header, false).NEVRA())
+		return nil
+	})
+	sort.Strings(nevras)
+	return nevras, err
+}
+
+// copyFixtures copies the given files of testdata into a new directory.
+func copyFixtures(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPackagesFixtures(t *testing.T) {
+	want := strings.Join(fixtureNEVRAs(), "\n")
+	for _, db := range []*Database{
+		{Path: "testdata/rpmdb.sqlite", Backend: "sqlite"},
+		{Path: "testdata/Packages.db", Backend: "ndb"},
+	} {
+		packages, err := db.Packages()
+		if err != nil {
+			t.Fatalf("%s: %v", db.Backend, err)
+		}
+		var nevras []string
+		for _, pkg := range packages {
+			nevras = append(nevras, pkg.NEVRA())
+		}
+		sort.Strings(nevras)
+		if got := strings.Join(nevras, "\n"); got != want {
+			t.Errorf("%s: packages\n%s\nwant\n%s", db.Backend, got, want)
+		}
+	}
+}
+
+func TestPackagesSortedByEVR(t *testing.T) {
+	db := &Database{Path: "testdata/Packages.db", Backend: "ndb"}
+	kernels, err := db.PackagesByName("kernel-default")
+	if err != nil || len(kernels) != 2 {
+		t.Fatalf("PackagesByName: %v %v", kernels, err)
+	}
+	packages, err := db.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var releases []string
+	for _, pkg := range packages {
+		if pkg.Name == "kernel-default" {
+			releases = append(releases, pkg.Release)
+		}
+	}
+	if strings.Join(releases, " ") != "150600.23.7.3 150600.23.14.2" {
+		t.Errorf("kernel releases in order %v", releases)
+	}
+}
+
+func TestOverflowPayload(t *testing.T) {
+	db := &Database{Path: "testdata/rpmdb.sqlite", Backend: "sqlite"}
+	packages, err := db.PackagesByName("bash")
+	if err != nil || len(packages) != 1 {
+		t.Fatalf("PackagesByName: %v %v", packages, err)
+	}
+	// the description is larger than a page of 512 bytes
+	if want := strings.Repeat("The GNU Bourne-Again Shell. ", 200); packages[0].Description != want {
+		t.Errorf("description of %d bytes, want %d", len(packages[0].Description), len(want))
+	}
+}
+
+func TestFileOwners(t *testing.T) {
+	db := &Database{Path: "testdata/rpmdb.sqlite", Backend: "sqlite"}
+	packages, err := db.FileOwners("/etc/vim.conf")
+	if err != nil || len(packages) != 1 || packages[0].Name != "vim" {
+		t.Errorf("FileOwners: %v %v", packages, err)
+	}
+}
+
+func TestWithoutWAL(t *testing.T) {
+	dir := copyFixtures(t, "rpmdb.sqlite")
+	nevras, err := readNEVRAs(&Database{Path: filepath.Join(dir, "rpmdb.sqlite"), Backend: "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nevra := range nevras {
+		if strings.HasPrefix(nevra, "vim-") {
+			t.Errorf("%s is only in the WAL file", nevra)
+		}
+	}
+	if len(nevras) != len(fixtureNEVRAs())-1 {
+		t.Errorf("%d packages, want %d", len(nevras), len(fixtureNEVRAs())-1)
+	}
+}
+
+func TestTruncatedWAL(t *testing.T) {
+	dir := copyFixtures(t, "rpmdb.sqlite", "rpmdb.sqlite-wal")
+	wal := filepath.Join(dir, "rpmdb.sqlite-wal")
+	info, err := os.Stat(wal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the commit frame is incomplete, so the whole transaction is ignored
+	if err := os.Truncate(wal, info.Size()-100); err != nil {
+		t.Fatal(err)
+	}
+	nevras, err := readNEVRAs(&Database{Path: filepath.Join(dir, "rpmdb.sqlite"), Backend: "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nevras) != len(fixtureNEVRAs())-1 {
+		t.Errorf("%d packages, want %d", len(nevras), len(fixtureNEVRAs())-1)
+	}
+}
+
+func TestTruncatedDatabases(t *testing.T) {
+	for _, fixture := range []struct {
+		name    string
+		backend string
+	}{
+		{"rpmdb.sqlite", "sqlite"},
+		{"Packages.db", "ndb"},
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		path := filepath.Join(dir, fixture.name)
+		for _, size := range []int{0, 10, 100, 511, len(data) / 2, len(data) - 1} {
+			if err := os.WriteFile(path, data[:size], 0644); err != nil {
+				t.Fatal(err)
+			}
+			nevras, err := readNEVRAs(&Database{Path: path, Backend: fixture.backend})
+			if err == nil && len(nevras) == len(fixtureNEVRAs()) {
+				t.Errorf("%s truncated to %d bytes: all packages read", fixture.name, size)
+			}
+		}
+	}
+}
+
+// TestCorruptedDatabases flips single bytes all over the fixtures. The
+// readers may fail or miss packages, but must not panic or hang.
+func TestCorruptedDatabases(t *testing.T) {
+	for _, fixture := range []struct {
+		name    string
+		backend string
+	}{
+		{"rpmdb.sqlite", "sqlite"},
+		{"Packages.db", "ndb"},
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		path := filepath.Join(dir, fixture.name)
+		corrupted := make([]byte, len(data))
+		for offset := 0; offset < len(data); offset += 3 {
+			copy(corrupted, data)
+			corrupted[offset] ^= 0xff
+			if err := os.WriteFile(path, corrupted, 0644); err != nil {
+				t.Fatal(err)
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s corrupted at %d: panic %v", fixture.name, offset, r)
+					}
+				}()
+				readNEVRAs(&Database{Path: path, Backend: fixture.backend})
+			}()
+		}
+	}
+}
+
+func TestPayloadSize(t *testing.T) {
+	db := &sqliteDB{pageSize: 512, usableSize: 512, pageCount: 4}
+	cell := make([]byte, 100)
+	for _, size := range []int64{-1, -1 << 62, 4*512 + 1, 1 << 62} {
+		if _, err := db.payload(cell, size); err == nil {
+			t.Errorf("payload size %d accepted", size)
+		}
+	}
+	if payload, err := db.payload(cell, 50); err != nil || len(payload) != 50 {
+		t.Errorf("payload of 50 bytes: %d %v", len(payload), err)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, blob := range [][]byte{
+		nil,
+		{0, 0, 0, 1},
+		// no entries
+		{0, 0, 0, 0, 0, 0, 0, 0},
+		// one entry, but no index
+		{0, 0, 0, 1, 0, 0, 0, 0},
+		// one entry, data size beyond the limit
+		{0, 0, 0, 1, 0x7f, 0xff, 0xff, 0xff},
+	} {
+		if _, err := parseHeader(blob); err == nil {
+			t.Errorf("parseHeader(%v) accepted", blob)
+		}
+	}
+	// a string array with a huge count and a single string
+	blob := []byte{0, 0, 0, 1, 0, 0, 0, 2,
+		0, 0, 0x04, 0x5d, 0, 0, 0, typeStringArray, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff,
+		'a', 0}
+	header, err := parseHeader(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := header.strings(tagBaseNames); len(values) != 1 || values[0] != "a" {
+		t.Errorf("strings = %v", values)
+	}
+}
+
+func TestFilesOfPackages(t *testing.T) {
+	db := &Database{Path: "testdata/Packages.db", Backend: "ndb"}
+	files, err := db.FilesOfPackages(func(name string) bool { return strings.HasPrefix(name, "kernel-") || name == "bash" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || len(files["kernel-default"]) != 2 || len(files["bash"]) != 1 {
+		t.Fatalf("files %v", files)
+	}
+	kernel := files["kernel-default"]["kernel-default-6.4.0-150600.23.14.2.x86_64"]
+	if len(kernel) != 2 || kernel[0].Path != "/usr/bin/kernel-default" || kernel[1].Path != "/etc/kernel-default.conf" {
+		t.Errorf("kernel files %+v", kernel)
+	}
+	single, err := db.PackageFiles("bash")
+	if err != nil || len(single) != 1 || len(single["bash-4.4-150400.27.3.2.x86_64"]) != 2 {
+		t.Errorf("PackageFiles: %v %v", single, err)
+	}
+	if missing, err := db.PackageFiles("emacs"); err != nil || missing == nil || len(missing) != 0 {
+		t.Errorf("PackageFiles of a missing package: %v %v", missing, err)
+	}
+}
@@ -0,0 +1,397 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A read-only reader for the few parts of the SQLite file format rpm
+// needs: the schema table and the rows of a rowid table, including
+// overflow pages and the pages of a not yet checkpointed WAL file.
+// See https://www.sqlite.org/fileformat2.html
+
+const sqliteHeaderMagic = "SQLite format 3\x00"
+const sqliteHeaderSize = 100
+
+// b-tree page types
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+)
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+)
+
+// b-trees deeper than this are broken or cyclic
+const sqliteMaxDepth = 32
+
+type sqliteDB struct {
+	file       *os.File
+	pageSize   int
+	usableSize int
+	pageCount  uint32
+	// latest committed version of pages in the WAL file
+	walPages map[uint32][]byte
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &sqliteDB{file: file}
+	if err := db.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := db.readWAL(path + "-wal"); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s-wal: %v", path, err)
+	}
+	return db, nil
+}
+
+func (db *sqliteDB) Close() error {
+	return db.file.Close()
+}
+
+func (db *sqliteDB) readHeader() error {
+	header := make([]byte, sqliteHeaderSize)
+	if _, err := db.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:16]) != sqliteHeaderMagic {
+		return errors.New("not a SQLite database")
+	}
+	db.pageSize = int(binary.BigEndian.Uint16(header[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+	db.usableSize = db.pageSize - int(header[20])
+	if encoding := binary.BigEndian.Uint32(header[56:60]); encoding > 1 {
+		return fmt.Errorf("unsupported text encoding %d", encoding)
+	}
+	db.pageCount = binary.BigEndian.Uint32(header[28:32])
+	if db.pageCount == 0 {
+		// written by SQLite before 3.7.0, the file size counts
+		info, err := db.file.Stat()
+		if err != nil {
+			return err
+		}
+		db.pageCount = uint32(info.Size() / int64(db.pageSize))
+	}
+	return nil
+}
+
+// walChecksum continues the checksum s0, s1 of the WAL file over data.
+func walChecksum(data []byte, order binary.ByteOrder, s0 uint32, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// readWAL reads the frames of the WAL file up to the last valid commit.
+// A missing or empty WAL file means the database file is complete.
+func (db *sqliteDB) readWAL(path string) error {
+	wal, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(wal) < walHeaderSize) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(wal[0:4]) {
+	case walMagicLE:
+		order = binary.LittleEndian
+	case walMagicBE:
+		order = binary.BigEndian
+	default:
+		return errors.New("invalid WAL header")
+	}
+	if int(binary.BigEndian.Uint32(wal[8:12])) != db.pageSize {
+		return errors.New("WAL page size differs from the database")
+	}
+	salt := wal[16:24]
+	s0, s1 := walChecksum(wal[:24], order, 0, 0)
+	if s0 != binary.BigEndian.Uint32(wal[24:28]) || s1 != binary.BigEndian.Uint32(wal[28:32]) {
+		return nil
+	}
+	pending := make(map[uint32][]byte)
+	committed := make(map[uint32][]byte)
+	for offset := walHeaderSize; offset+walFrameHeaderSize+db.pageSize <= len(wal); offset += walFrameHeaderSize + db.pageSize {
+		frame := wal[offset : offset+walFrameHeaderSize]
+		page := wal[offset+walFrameHeaderSize : offset+walFrameHeaderSize+db.pageSize]
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(frame[:8], order, s0, s1)
+		s0, s1 = walChecksum(page, order, s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:20]) || s1 != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame[0:4])] = page
+		if commitSize := binary.BigEndian.Uint32(frame[4:8]); commitSize != 0 {
+			for number, data := range pending {
+				committed[number] = data
+			}
+			pending = make(map[uint32][]byte)
+			db.pageCount = commitSize
+		}
+	}
+	if len(committed) > 0 {
+		db.walPages = committed
+	}
+	return nil
+}
+
+func (db *sqliteDB) readPage(number uint32) ([]byte, error) {
+	if number == 0 || (db.pageCount != 0 && number > db.pageCount) {
+		return nil, fmt.Errorf("page %d out of range", number)
+	}
+	if page, ok := db.walPages[number]; ok {
+		return page, nil
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(number-1)*int64(db.pageSize)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return page, nil
+}
+
+// readVarint decodes a SQLite varint, big-endian with 7 bits per byte
+// and all 8 bits in the ninth byte.
+func readVarint(data []byte) (int64, int) {
+	var value uint64
+	for i := 0; i < 9 && i < len(data); i++ {
+		if i == 8 {
+			return int64(value<<8 | uint64(data[i])), 9
+		}
+		value = value<<7 | uint64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return int64(value), i + 1
+		}
+	}
+	return int64(value), len(data)
+}
+
+// payload returns the whole payload of a table leaf cell, following the
+// overflow pages if it does not fit into the page.
+func (db *sqliteDB) payload(cell []byte, size int64) ([]byte, error) {
+	// the size is read from the page, a payload cannot be larger than
+	// the whole database
+	if size < 0 || size > int64(db.pageCount)*int64(db.pageSize) {
+		return nil, fmt.Errorf("invalid payload size %d", size)
+	}
+	maxLocal := int64(db.usableSize - 35)
+	if size <= maxLocal {
+		if int64(len(cell)) < size {
+			return nil, errors.New("cell exceeds page")
+		}
+		return cell[:size], nil
+	}
+	minLocal := int64((db.usableSize-12)*32/255 - 23)
+	local := minLocal + (size-minLocal)%int64(db.usableSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if int64(len(cell)) < local+4 {
+		return nil, errors.New("cell exceeds page")
+	}
+	data := make([]byte, 0, size)
+	data = append(data, cell[:local]...)
+	next := binary.BigEndian.Uint32(cell[local : local+4])
+	for int64(len(data)) < size {
+		if next == 0 {
+			return nil, errors.New("overflow chain too short")
+		}
+		page, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(page[0:4])
+		chunk := page[4:db.usableSize]
+		if remaining := size - int64(len(data)); int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// parseRecord decodes a record into int64, float64 (as raw bits),
+// []byte, string or nil values.
+func parseRecord(payload []byte) ([]any, error) {
+	headerSize, n := readVarint(payload)
+	if headerSize > int64(len(payload)) || headerSize < int64(n) {
+		return nil, errors.New("invalid record header")
+	}
+	var serialTypes []int64
+	for offset := n; offset < int(headerSize); {
+		serialType, n := readVarint(payload[offset:headerSize])
+		serialTypes = append(serialTypes, serialType)
+		offset += n
+	}
+	body := payload[headerSize:]
+	values := make([]any, 0, len(serialTypes))
+	for _, serialType := range serialTypes {
+		var size int
+		switch {
+		case serialType == 0 || serialType == 8 || serialType == 9:
+			size = 0
+		case serialType >= 1 && serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", serialType)
+		}
+		if size > len(body) {
+			return nil, errors.New("record exceeds payload")
+		}
+		field := body[:size]
+		body = body[size:]
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType <= 7:
+			// big-endian two's complement integer of size bytes
+			var value int64
+			if size > 0 && field[0]&0x80 != 0 {
+				value = -1
+			}
+			for _, b := range field {
+				value = value<<8 | int64(b)
+			}
+			values = append(values, value)
+		case serialType%2 == 0:
+			values = append(values, field)
+		default:
+			values = append(values, string(field))
+		}
+	}
+	return values, nil
+}
+
+// scanTable calls fn with the rowid and the values of every row of the
+// table b-tree starting at root.
+func (db *sqliteDB) scanTable(root uint32, fn func(rowid int64, values []any) error) error {
+	return db.scanPage(root, 0, make(map[uint32]bool), fn)
+}
+
+// scanPage walks the b-tree below page number. Every page is part of a
+// b-tree only once, so visited pages mean a broken or cyclic tree.
+func (db *sqliteDB) scanPage(number uint32, depth int, visited map[uint32]bool, fn func(rowid int64, values []any) error) error {
+	if depth > sqliteMaxDepth {
+		return errors.New("b-tree too deep")
+	}
+	if visited[number] {
+		return fmt.Errorf("page %d is referenced twice", number)
+	}
+	visited[number] = true
+	page, err := db.readPage(number)
+	if err != nil {
+		return err
+	}
+	headerOffset := 0
+	if number == 1 {
+		headerOffset = sqliteHeaderSize
+	}
+	header := page[headerOffset:]
+	cellCount := int(binary.BigEndian.Uint16(header[3:5]))
+	switch header[0] {
+	case sqliteInteriorTable:
+		pointers := header[12:]
+		if len(pointers) < 2*cellCount {
+			return errors.New("invalid cell count")
+		}
+		for i := 0; i < cellCount; i++ {
+			cellOffset := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if cellOffset+4 > len(page) {
+				return errors.New("cell out of page")
+			}
+			if err := db.scanPage(binary.BigEndian.Uint32(page[cellOffset:]), depth+1, visited, fn); err != nil {
+				return err
+			}
+		}
+		return db.scanPage(binary.BigEndian.Uint32(header[8:12]), depth+1, visited, fn)
+	case sqliteLeafTable:
+		pointers := header[8:]
+		if len(pointers) < 2*cellCount {
+			return errors.New("invalid cell count")
+		}
+		for i := 0; i < cellCount; i++ {
+			cellOffset := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if cellOffset >= db.usableSize {
+				return errors.New("cell out of page")
+			}
+			cell := page[cellOffset:db.usableSize]
+			size, n := readVarint(cell)
+			rowid, m := readVarint(cell[n:])
+			payload, err := db.payload(cell[n+m:], size)
+			if err != nil {
+				return err
+			}
+			values, err := parseRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("page %d is not a table b-tree page", number)
+	}
+}
+
+// tableRoot looks up the root page of table in the schema table.
+func (db *sqliteDB) tableRoot(table string) (uint32, error) {
+	var root uint32
+	errFound := errors.New("found")
+	err := db.scanTable(1, func(rowid int64, values []any) error {
+		if len(values) < 4 {
+			return nil
+		}
+		if objectType, _ := values[0].(string); objectType != "table" {
+			return nil
+		}
+		if name, _ := values[1].(string); name != table {
+			return nil
+		}
+		if rootPage, ok := values[3].(int64); ok {
+			root = uint32(rootPage)
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return root, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no table %s", table)
+}
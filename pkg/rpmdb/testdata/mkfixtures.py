@@ -0,0 +1,106 @@
+#!/usr/bin/python3
+# Writes the rpm database fixtures of the tests: rpmdb.sqlite with a
+# not yet checkpointed rpmdb.sqlite-wal, and an ndb Packages.db.
+# The headers only carry the tags the reader uses.
+
+import os
+import shutil
+import sqlite3
+import struct
+import tempfile
+
+TAG_NAME, TAG_VERSION, TAG_RELEASE, TAG_EPOCH = 1000, 1001, 1002, 1003
+TAG_SUMMARY, TAG_DESCRIPTION, TAG_ARCH = 1004, 1005, 1022
+TAG_DIRINDEXES, TAG_BASENAMES, TAG_DIRNAMES = 1116, 1117, 1118
+TYPE_INT32, TYPE_STRING, TYPE_STRING_ARRAY, TYPE_I18NSTRING = 4, 6, 8, 9
+
+# name, epoch, version, release, arch
+PACKAGES = [("pkg%02d" % i, 0, "1.%d" % i, "%d.1" % i, "x86_64") for i in range(40)]
+PACKAGES += [
+    ("bash", 0, "4.4", "150400.27.3.2", "x86_64"),
+    ("kernel-default", 0, "6.4.0", "150600.23.7.3", "x86_64"),
+    ("kernel-default", 0, "6.4.0", "150600.23.14.2", "x86_64"),
+    ("shadow", 2, "4.8.1", "150400.10.15.1", "x86_64"),
+    ("filesystem", 0, "15.0", "11.8.1", "noarch"),
+]
+# added in the WAL file only
+WAL_PACKAGES = [("vim", 0, "9.1.0330", "150500.20.12.1", "x86_64")]
+
+
+def header(name, epoch, version, release, arch):
+    entries = [
+        (TAG_NAME, TYPE_STRING, [name]),
+        (TAG_VERSION, TYPE_STRING, [version]),
+        (TAG_RELEASE, TYPE_STRING, [release]),
+        (TAG_SUMMARY, TYPE_I18NSTRING, ["Fixture package " + name]),
+        (TAG_ARCH, TYPE_STRING, [arch]),
+        (TAG_DIRINDEXES, TYPE_INT32, [0, 1]),
+        (TAG_BASENAMES, TYPE_STRING_ARRAY, [name, name + ".conf"]),
+        (TAG_DIRNAMES, TYPE_STRING_ARRAY, ["/usr/bin/", "/etc/"]),
+    ]
+    if epoch:
+        entries.append((TAG_EPOCH, TYPE_INT32, [epoch]))
+    if name == "bash":
+        # larger than a page, stored in overflow pages
+        entries.append((TAG_DESCRIPTION, TYPE_I18NSTRING, ["The GNU Bourne-Again Shell. " * 200]))
+    index, data = b"", b""
+    for tag, typ, values in sorted(entries):
+        if typ == TYPE_INT32:
+            while len(data) % 4:
+                data += b"\0"
+            encoded = b"".join(struct.pack(">i", value) for value in values)
+        else:
+            encoded = b"".join(value.encode() + b"\0" for value in values)
+        index += struct.pack(">iIiI", tag, typ, len(data), len(values))
+        data += encoded
+    return struct.pack(">II", len(entries), len(data)) + index + data
+
+
+def write_sqlite(directory):
+    tmp = tempfile.mkdtemp()
+    path = os.path.join(tmp, "rpmdb.sqlite")
+    db = sqlite3.connect(path)
+    db.execute("PRAGMA page_size = 512")
+    db.execute("PRAGMA journal_mode = WAL")
+    db.execute("PRAGMA wal_autocheckpoint = 0")
+    db.execute("CREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)")
+    for package in PACKAGES:
+        db.execute("INSERT INTO Packages (blob) VALUES (?)", (header(*package),))
+    db.commit()
+    db.execute("PRAGMA wal_checkpoint(TRUNCATE)")
+    for package in WAL_PACKAGES:
+        db.execute("INSERT INTO Packages (blob) VALUES (?)", (header(*package),))
+    db.commit()
+    # copy while the connection is open, closing it checkpoints the WAL
+    shutil.copy(path, os.path.join(directory, "rpmdb.sqlite"))
+    shutil.copy(path + "-wal", os.path.join(directory, "rpmdb.sqlite-wal"))
+    db.close()
+    shutil.rmtree(tmp)
+
+
+def write_ndb(directory):
+    page_size, slot_pages = 4096, 1
+    slots, blobs = [], b""
+    # blobs start behind the slot pages, counted in 16 byte blocks
+    block = slot_pages * page_size // 16
+    for index, package in enumerate(PACKAGES + WAL_PACKAGES, start=1):
+        data = header(*package)
+        blob = struct.pack("<4sIII", b"BlbS", index, 1, len(data)) + data
+        # tail: checksum, length and magic
+        blob += b"\0" * (-(len(blob) + 12) % 16) + struct.pack("<I", 0) + struct.pack("<I", len(data)) + b"BlbE"
+        slots.append(struct.pack("<4sIII", b"Slot", index, block, len(blob) // 16))
+        block += len(blob) // 16
+        blobs += blob
+    head = struct.pack("<4sIIII", b"RpmP", 0, 1, slot_pages, len(slots) + 1).ljust(32, b"\0")
+    slot_area = head + b"".join(slots)
+    free = struct.pack("<4sIII", b"Slot", 0, 0, 0)
+    while len(slot_area) < slot_pages * page_size:
+        slot_area += free
+    with open(os.path.join(directory, "Packages.db"), "wb") as ndb:
+        ndb.write(slot_area + blobs)
+
+
+if __name__ == "__main__":
+    directory = os.path.dirname(os.path.abspath(__file__))
+    write_sqlite(directory)
+    write_ndb(directory)
@@ -0,0 +1,532 @@
+package sbom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/repomd"
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+	"mcp-server-admintasks/pkg/zypper"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var sbomDebug bool
+
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+const resourceURIPrefix = "admintasks://sbom/"
+
+// gpg-pubkey entries of the rpm database are keys, not software
+const rpmPubkeyName = "gpg-pubkey"
+
+// Something like "GPL-2.0-or-later AND (MIT OR BSD-3-Clause)", rpm
+// licenses which are no SPDX expression are reported as NOASSERTION.
+var spdxExpressionPattern = regexp.MustCompile(`^[A-Za-z0-9.+\-() ]+$`)
+
+// SPDX identifiers allow letters, numbers, "." and "-"
+var spdxIDInvalidPattern = regexp.MustCompile(`[^A-Za-z0-9.\-]`)
+
+// Component is an installed package with everything the SBOM formats
+// need.
+type Component struct {
+	Name       string
+	Version    string
+	Arch       string
+	Epoch      int64
+	Vendor     string
+	License    string
+	SourceRPM  string
+	Repository string
+	PURL       string
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version"`
+	Publisher  string              `json:"publisher,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string `json:"timestamp"`
+		Tools     struct {
+			Components []cycloneDXComponent `json:"components"`
+		} `json:"tools"`
+		Component cycloneDXComponent `json:"component"`
+	} `json:"metadata"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo"`
+	Supplier              string            `json:"supplier"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	LicenseComments       string            `json:"licenseComments,omitempty"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+// purlNamespace maps the distribution to the namespace of rpm package
+// URLs, e.g. pkg:rpm/opensuse/... or pkg:rpm/fedora/...
+func purlNamespace() string {
+	id := utils.DetectedOSRelease.ID
+	switch {
+	case strings.HasPrefix(id, "opensuse"):
+		return "opensuse"
+	case strings.HasPrefix(id, "sle"), strings.HasPrefix(id, "sl-"):
+		return "suse"
+	case id == "":
+		return "unknown"
+	}
+	return id
+}
+
+func purlDistro() string {
+	distro := utils.DetectedOSRelease.ID
+	if utils.DetectedOSRelease.VersionID != "" {
+		distro += "-" + utils.DetectedOSRelease.VersionID
+	}
+	return distro
+}
+
+// purlEscape percent-encodes like url.PathEscape, and "+" as well.
+func purlEscape(value string) string {
+	return strings.ReplaceAll(url.PathEscape(value), "+", "%2B")
+}
+
+// packageURL returns the purl of an rpm, see
+// https://github.com/package-url/purl-spec
+func packageURL(pkg rpmdb.InstalledPackage) string {
+	// qualifiers sorted by key
+	var qualifiers []string
+	if pkg.Arch != "" {
+		qualifiers = append(qualifiers, "arch="+url.QueryEscape(pkg.Arch))
+	}
+	if distro := purlDistro(); distro != "" {
+		qualifiers = append(qualifiers, "distro="+url.QueryEscape(distro))
+	}
+	if pkg.Epoch != 0 {
+		qualifiers = append(qualifiers, fmt.Sprintf("epoch=%d", pkg.Epoch))
+	}
+	purl := fmt.Sprintf("pkg:rpm/%s/%s@%s", purlNamespace(), purlEscape(pkg.Name), purlEscape(pkg.Version+"-"+pkg.Release))
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// repositoryOrigins maps name-evr.arch to the repository the package was
+// installed from, first from the zypp history, then from the cached
+// metadata of the enabled repositories.
+func repositoryOrigins(packages []rpmdb.InstalledPackage) map[string]string {
+	origins := make(map[string]string)
+	if events, err := zypper.ReadZyppHistory(); err == nil {
+		for _, event := range events {
+			if event.Action == "install" && event.Repository != "" {
+				origins[event.Package+"-"+event.Version+"."+event.Arch] = event.Repository
+			}
+		}
+	}
+	missing := false
+	for _, pkg := range packages {
+		if _, ok := origins[pkg.NEVRA()]; !ok {
+			missing = true
+			break
+		}
+	}
+	if !missing || !utils.MatchesDistribution([]string{"suse"}) {
+		return origins
+	}
+	repos, err := repomd.EnabledRepositories()
+	if err != nil {
+		return origins
+	}
+	// repos are sorted by priority, the first match wins
+	for _, repo := range repos {
+		repoPackages, err := repomd.RepositoryPackages(repo)
+		if err != nil {
+			continue
+		}
+		for _, repoPackage := range repoPackages {
+			key := repoPackage.Name + "-" + repoPackage.Version + "." + repoPackage.Arch
+			if _, ok := origins[key]; !ok {
+				origins[key] = repoPackage.Repository
+			}
+		}
+	}
+	return origins
+}
+
+// Components returns the installed packages as SBOM components, sorted
+// by name.
+func Components() ([]Component, error) {
+	db, err := rpmdb.Open()
+	if err != nil {
+		return nil, err
+	}
+	packages, err := db.Packages()
+	if err != nil {
+		return nil, err
+	}
+	origins := repositoryOrigins(packages)
+	var components []Component
+	for _, pkg := range packages {
+		if pkg.Name == rpmPubkeyName {
+			continue
+		}
+		components = append(components, Component{
+			Name:       pkg.Name,
+			Version:    pkg.EVR(),
+			Arch:       pkg.Arch,
+			Epoch:      pkg.Epoch,
+			Vendor:     pkg.Vendor,
+			License:    pkg.License,
+			SourceRPM:  pkg.SourceRPM,
+			Repository: origins[pkg.NEVRA()],
+			PURL:       packageURL(pkg),
+		})
+	}
+	return components, nil
+}
+
+// hostName and newUUID identify the documents, the tests replace them
+var hostName = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return hostname
+}
+
+var newUUID = uuid.NewString
+
+func cycloneDX(components []Component, created time.Time) cycloneDXDocument {
+	var document cycloneDXDocument
+	document.BOMFormat = "CycloneDX"
+	document.SpecVersion = "1.5"
+	document.SerialNumber = "urn:uuid:" + newUUID()
+	document.Version = 1
+	document.Metadata.Timestamp = created.UTC().Format(time.RFC3339)
+	document.Metadata.Tools.Components = []cycloneDXComponent{{
+		Type:    "application",
+		BOMRef:  utils.ServerName,
+		Name:    utils.ServerName,
+		Version: utils.ServerVersion,
+	}}
+	document.Metadata.Component = cycloneDXComponent{
+		Type:    "operating-system",
+		BOMRef:  "host:" + hostName(),
+		Name:    utils.DetectedOSRelease.ID,
+		Version: utils.DetectedOSRelease.VersionID,
+		Properties: []cycloneDXProperty{
+			{Name: "hostname", Value: hostName()},
+			{Name: "pretty_name", Value: utils.DetectedOSRelease.PrettyName},
+		},
+	}
+	document.Components = []cycloneDXComponent{}
+	for _, component := range components {
+		entry := cycloneDXComponent{
+			Type:      "library",
+			BOMRef:    component.PURL,
+			Name:      component.Name,
+			Version:   component.Version,
+			Publisher: component.Vendor,
+			PURL:      component.PURL,
+			Properties: []cycloneDXProperty{
+				{Name: "rpm:arch", Value: component.Arch},
+			},
+		}
+		if component.License != "" {
+			var license cycloneDXLicense
+			license.License.Name = component.License
+			entry.Licenses = []cycloneDXLicense{license}
+		}
+		if component.SourceRPM != "" {
+			entry.Properties = append(entry.Properties, cycloneDXProperty{Name: "rpm:sourcerpm", Value: component.SourceRPM})
+		}
+		if component.Repository != "" {
+			entry.Properties = append(entry.Properties, cycloneDXProperty{Name: "rpm:repository", Value: component.Repository})
+		}
+		document.Components = append(document.Components, entry)
+	}
+	return document
+}
+
+func spdxLicense(license string) (string, string) {
+	if license != "" && spdxExpressionPattern.MatchString(license) {
+		return license, ""
+	}
+	if license == "" {
+		return "NOASSERTION", ""
+	}
+	return "NOASSERTION", "rpm License: " + license
+}
+
+func spdxID(index int, name string) string {
+	return fmt.Sprintf("SPDXRef-Package-%d-%s", index, spdxIDInvalidPattern.ReplaceAllString(name, "-"))
+}
+
+func spdx(components []Component, created time.Time) spdxDocument {
+	var document spdxDocument
+	hostname := hostName()
+	document.SPDXVersion = "SPDX-2.3"
+	document.DataLicense = "CC0-1.0"
+	document.SPDXID = "SPDXRef-DOCUMENT"
+	document.Name = hostname + "-installed-packages"
+	document.DocumentNamespace = fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", url.PathEscape(document.Name), newUUID())
+	document.CreationInfo.Created = created.UTC().Format(time.RFC3339)
+	document.CreationInfo.Creators = []string{"Tool: " + utils.ServerName + "-" + utils.ServerVersion}
+	operatingSystemID := "SPDXRef-OperatingSystem"
+	document.Packages = []spdxPackage{{
+		Name:                  utils.DetectedOSRelease.ID,
+		SPDXID:                operatingSystemID,
+		VersionInfo:           utils.DetectedOSRelease.VersionID,
+		Supplier:              "NOASSERTION",
+		DownloadLocation:      "NOASSERTION",
+		LicenseConcluded:      "NOASSERTION",
+		LicenseDeclared:       "NOASSERTION",
+		PrimaryPackagePurpose: "OPERATING-SYSTEM",
+	}}
+	document.Relationships = []spdxRelationship{{
+		SPDXElementID:      document.SPDXID,
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: operatingSystemID,
+	}}
+	for i, component := range components {
+		licenseDeclared, licenseComments := spdxLicense(component.License)
+		entry := spdxPackage{
+			Name:             component.Name,
+			SPDXID:           spdxID(i+1, component.Name),
+			VersionInfo:      component.Version,
+			Supplier:         "NOASSERTION",
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  licenseDeclared,
+			LicenseComments:  licenseComments,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  component.PURL,
+			}},
+		}
+		if component.Vendor != "" {
+			entry.Supplier = "Organization: " + component.Vendor
+		}
+		var sourceInfo []string
+		if component.SourceRPM != "" {
+			sourceInfo = append(sourceInfo, "built from source rpm "+component.SourceRPM)
+		}
+		if component.Repository != "" {
+			sourceInfo = append(sourceInfo, "installed from repository "+component.Repository)
+		}
+		entry.SourceInfo = strings.Join(sourceInfo, ", ")
+		document.Packages = append(document.Packages, entry)
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      operatingSystemID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: entry.SPDXID,
+		})
+	}
+	return document
+}
+
+// Generate returns the SBOM of the installed packages in format.
+func Generate(format string) ([]byte, error) {
+	if format != FormatCycloneDX && format != FormatSPDX {
+		return nil, fmt.Errorf("unknown SBOM format %q, use %s or %s", format, FormatCycloneDX, FormatSPDX)
+	}
+	components, err := Components()
+	if err != nil {
+		return nil, err
+	}
+	created := time.Now()
+	var document any
+	switch format {
+	case FormatCycloneDX:
+		document = cycloneDX(components, created)
+	case FormatSPDX:
+		document = spdx(components, created)
+	}
+	return encodeDocument(document)
+}
+
+func encodeDocument(document any) ([]byte, error) {
+	// purls and vendors are more readable without \u0026 for "&"
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeSBOM stores the SBOM in the sbom_dir of the server config, the
+// file name is chosen by the server, not by the caller.
+func writeSBOM(format string, document []byte) (string, error) {
+	dir := utils.AdminTasksConfig.SBOMDir
+	if dir == "" {
+		return "", errors.New("no sbom_dir in " + utils.ServerConfigPath)
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("sbom_dir %s is not absolute", dir)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(dir, fmt.Sprintf("%s-%s-%s.json", hostName(), format, time.Now().UTC().Format("20060102T150405Z")))
+	tmpFile, err := os.CreateTemp(dir, ".sbom-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(document); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err := tmpFile.Chmod(0640); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	return filePath, os.Rename(tmpFile.Name(), filePath)
+}
+
+func addToolsAndResourcesToMCPServer() {
+	for _, format := range []string{FormatCycloneDX, FormatSPDX} {
+		resourceURI := resourceURIPrefix + format
+		resource := mcp.NewResource(resourceURI, "sbom-"+format,
+			mcp.WithResourceDescription(fmt.Sprintf("Software bill of materials of the installed packages as %s JSON", format)),
+			mcp.WithMIMEType("application/json"),
+		)
+		utils.AddResource(resource, func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			document, err := Generate(format)
+			if err != nil {
+				return nil, err
+			}
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{URI: resourceURI, MIMEType: "application/json", Text: string(document)},
+			}, nil
+		})
+	}
+
+	mcpToolSBOM := mcp.NewTool("sbom_generate",
+		mcp.WithDescription("Build a software bill of materials of the installed rpm packages (name, version, arch, vendor, license, source rpm, repository of origin). It is available as resource "+resourceURIPrefix+"cyclonedx or "+resourceURIPrefix+"spdx; this tool can store it in the directory the admin configured for SBOMs."),
+		mcp.WithString("format", mcp.Required(), mcp.Description("cyclonedx (CycloneDX 1.5) or spdx (SPDX 2.3)")),
+		mcp.WithBoolean("write", mcp.Description("Store the SBOM in the configured sbom_dir instead of returning it")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolSBOM, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		format, _ := req.GetArguments()["format"].(string)
+		write, _ := req.GetArguments()["write"].(bool)
+		document, err := Generate(strings.ToLower(format))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if !write {
+			return mcp.NewToolResultText(string(document)), nil
+		}
+		filePath, err := writeSBOM(strings.ToLower(format), document)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		jsonData, err := json.Marshal(map[string]any{"written": filePath, "size": len(document), "resource": resourceURIPrefix + strings.ToLower(format)})
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(jsonData)), nil
+	})
+	utils.RecordRegisteredTool("sbom_generate")
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		sbomDebug = debugMode == utils.Debug
+		db, err := rpmdb.Open()
+		if err != nil {
+			utils.RecordSkippedTool("sbom_generate", "rpmdb", err.Error())
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-sbom")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if sbomDebug {
+			sysLog.Info("SBOMs from " + db.Path)
+		}
+		addToolsAndResourcesToMCPServer()
+	}
+}
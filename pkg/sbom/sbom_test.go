@@ -0,0 +1,143 @@
+package sbom
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func setOSRelease(t *testing.T, release utils.OSRelease) {
+	t.Helper()
+	saved := utils.DetectedOSRelease
+	utils.DetectedOSRelease = release
+	t.Cleanup(func() { utils.DetectedOSRelease = saved })
+}
+
+func TestPurlEscape(t *testing.T) {
+	for value, want := range map[string]string{
+		"bash":           "bash",
+		"libstdc++6":     "libstdc%2B%2B6",
+		"1.0~rc1-1.1":    "1.0~rc1-1.1",
+		"name with/path": "name%20with%2Fpath",
+		"a?b":            "a%3Fb",
+	} {
+		if got := purlEscape(value); got != want {
+			t.Errorf("purlEscape(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestPackageURL(t *testing.T) {
+	for _, tc := range []struct {
+		release utils.OSRelease
+		pkg     rpmdb.InstalledPackage
+		want    string
+	}{
+		{utils.OSRelease{ID: "opensuse-leap", VersionID: "15.6"},
+			rpmdb.InstalledPackage{Name: "bash", Version: "5.2.15", Release: "150500.3.2", Arch: "x86_64"},
+			"pkg:rpm/opensuse/bash@5.2.15-150500.3.2?arch=x86_64&distro=opensuse-leap-15.6"},
+		{utils.OSRelease{ID: "opensuse-tumbleweed", VersionID: "20241018"},
+			rpmdb.InstalledPackage{Name: "libstdc++6", Version: "14.2.0+git10526", Release: "1.1", Arch: "x86_64"},
+			"pkg:rpm/opensuse/libstdc%2B%2B6@14.2.0%2Bgit10526-1.1?arch=x86_64&distro=opensuse-tumbleweed-20241018"},
+		{utils.OSRelease{ID: "sles", VersionID: "15.6"},
+			rpmdb.InstalledPackage{Name: "mozilla-nss", Epoch: 1, Version: "3.101.2", Release: "150400.3.54.1", Arch: "x86_64"},
+			"pkg:rpm/suse/mozilla-nss@3.101.2-150400.3.54.1?arch=x86_64&distro=sles-15.6&epoch=1"},
+		{utils.OSRelease{ID: "fedora", VersionID: "40"},
+			rpmdb.InstalledPackage{Name: "tzdata", Version: "2024a", Release: "5.fc40", Arch: "noarch"},
+			"pkg:rpm/fedora/tzdata@2024a-5.fc40?arch=noarch&distro=fedora-40"},
+		// without os-release and arch
+		{utils.OSRelease{},
+			rpmdb.InstalledPackage{Name: "gpg-pubkey", Version: "3fa1d6ce", Release: "67c856ee"},
+			"pkg:rpm/unknown/gpg-pubkey@3fa1d6ce-67c856ee"},
+	} {
+		setOSRelease(t, tc.release)
+		if got := packageURL(tc.pkg); got != tc.want {
+			t.Errorf("packageURL(%s)\n= %s\nwant %s", tc.pkg.Name, got, tc.want)
+		}
+	}
+}
+
+func TestSPDXLicense(t *testing.T) {
+	for _, tc := range []struct {
+		license  string
+		declared string
+		comments string
+	}{
+		{"MIT", "MIT", ""},
+		{"GPL-2.0-or-later AND (MIT OR BSD-3-Clause)", "GPL-2.0-or-later AND (MIT OR BSD-3-Clause)", ""},
+		{"LGPL-2.1+", "LGPL-2.1+", ""},
+		{"", "NOASSERTION", ""},
+		{"BSD-3-Clause, MIT", "NOASSERTION", "rpm License: BSD-3-Clause, MIT"},
+		{"SUSE-Proprietary/Commercial", "NOASSERTION", "rpm License: SUSE-Proprietary/Commercial"},
+	} {
+		declared, comments := spdxLicense(tc.license)
+		if declared != tc.declared || comments != tc.comments {
+			t.Errorf("spdxLicense(%q) = %q, %q, want %q, %q", tc.license, declared, comments, tc.declared, tc.comments)
+		}
+	}
+}
+
+func TestSPDXID(t *testing.T) {
+	for _, tc := range []struct {
+		index int
+		name  string
+		want  string
+	}{
+		{1, "bash", "SPDXRef-Package-1-bash"},
+		{2, "libstdc++6", "SPDXRef-Package-2-libstdc--6"},
+		{3, "perl_base", "SPDXRef-Package-3-perl-base"},
+		{4, "python311-3.11", "SPDXRef-Package-4-python311-3.11"},
+	} {
+		if got := spdxID(tc.index, tc.name); got != tc.want {
+			t.Errorf("spdxID(%d, %q) = %q, want %q", tc.index, tc.name, got, tc.want)
+		}
+	}
+}
+
+var goldenComponents = []Component{
+	{Name: "bash", Version: "5.2.15-150500.3.2", Arch: "x86_64", Vendor: "SUSE LLC <https://www.suse.com/>", License: "GPL-3.0-or-later",
+		SourceRPM: "bash-5.2.15-150500.3.2.src.rpm", Repository: "repo-oss", PURL: "pkg:rpm/opensuse/bash@5.2.15-150500.3.2?arch=x86_64&distro=opensuse-leap-15.6"},
+	{Name: "libstdc++6", Version: "13.3.0+git8781-150000.1.12.1", Arch: "x86_64", Vendor: "SUSE LLC <https://www.suse.com/>", License: "GPL-3.0-or-later WITH GCC-exception-3.1",
+		SourceRPM: "gcc13-13.3.0+git8781-150000.1.12.1.src.rpm", PURL: "pkg:rpm/opensuse/libstdc%2B%2B6@13.3.0%2Bgit8781-150000.1.12.1?arch=x86_64&distro=opensuse-leap-15.6"},
+	{Name: "local-tool", Version: "1.0-1", Arch: "noarch", License: "Proprietary, see /usr/share/doc",
+		PURL: "pkg:rpm/opensuse/local-tool@1.0-1?arch=noarch&distro=opensuse-leap-15.6"},
+}
+
+func TestGoldenDocuments(t *testing.T) {
+	setOSRelease(t, utils.OSRelease{ID: "opensuse-leap", VersionID: "15.6", PrettyName: "openSUSE Leap 15.6"})
+	savedHostName, savedUUID := hostName, newUUID
+	defer func() { hostName, newUUID = savedHostName, savedUUID }()
+	hostName = func() string { return "testhost" }
+	newUUID = func() string { return "6c3f9b8e-2d1a-4c5e-9f7b-0a1b2c3d4e5f" }
+	created := time.Date(2024, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	for format, document := range map[string]any{
+		FormatCycloneDX: cycloneDX(goldenComponents, created),
+		FormatSPDX:      spdx(goldenComponents, created),
+	} {
+		got, err := encodeDocument(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := "testdata/" + format + ".json"
+		if *update {
+			if err := os.WriteFile(golden, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s document differs from %s:\n%s", format, golden, got)
+		}
+	}
+}
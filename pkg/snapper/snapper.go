@@ -0,0 +1,418 @@
+package snapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var snapperDebug bool
+
+// Only the snapper config of the root file system is used
+const snapperConfig = "root"
+const snapperConfigFile = "/etc/snapper/configs/" + snapperConfig
+
+// BTRFS_SUPER_MAGIC from statfs(2)
+const btrfsSuperMagic = 0x9123683e
+
+// Keys of the snapper userdata which mark the snapshots of the server
+const (
+	userdataTool    = "mcp_tool"
+	userdataSession = "mcp_session"
+	userdataCall    = "mcp_call"
+)
+
+var snapperCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "snapper",
+	Description:       "Command-line program for filesystem snapshot management",
+	NeedsRootHandling: true,
+	DefaultParameters: []string{"--config", snapperConfig},
+	SubCommands: map[string]utils.SingleSubCmd{
+		"list": {
+			CmdGroup:       "Snapshot Commands",
+			Summary:        "List the snapshot pairs created around tool calls of this server.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			Parameters:     []string{},
+		},
+		"status": {
+			CmdGroup:       "Snapshot Commands",
+			Summary:        "Show the files changed between the pre and post snapshot of a pair, or the diff of a single file.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			Parameters:     []string{"PRE number", "POST number", "PATH"},
+		},
+		"undochange": {
+			CmdGroup:       "Snapshot Commands",
+			Summary:        "Revert the changes between the pre and post snapshot of a pair created by this server.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PRE number", "POST number", "PATH"},
+		},
+	},
+}
+
+type snapperSnapshot struct {
+	Number      int               `json:"number"`
+	Type        string            `json:"type"`
+	PreNumber   *int              `json:"pre-number"`
+	Date        string            `json:"date"`
+	Description string            `json:"description"`
+	Userdata    map[string]string `json:"userdata"`
+}
+
+type SnapshotPair struct {
+	Pre         int    `json:"pre"`
+	Post        int    `json:"post,omitempty"`
+	Date        string `json:"date"`
+	Tool        string `json:"tool"`
+	Session     string `json:"session"`
+	CallID      string `json:"call_id"`
+	Description string `json:"description"`
+}
+
+type ChangedFile struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+}
+
+// snapperHook takes a pre snapshot before and a post snapshot after
+// every mutating zypper tool call.
+type snapperHook struct{}
+
+func (hook snapperHook) Name() string {
+	return "snapper"
+}
+
+// snapper separates userdata with "," and "="
+func userdataValue(value string) string {
+	return strings.NewReplacer(",", "_", "=", "_").Replace(value)
+}
+
+func userdataFor(call *utils.MutatingToolCall) string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%s",
+		userdataTool, userdataValue(call.Tool),
+		userdataSession, userdataValue(call.SessionID),
+		userdataCall, userdataValue(call.CallID))
+}
+
+func createSnapshot(ctx context.Context, params ...string) (int, error) {
+	params = append([]string{"--cleanup-algorithm", "number", "--print-number"}, params...)
+	result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "create", params...)
+	if err != nil {
+		return 0, err
+	}
+	if result.ExitCode != 0 {
+		return 0, fmt.Errorf("snapper create failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	return strconv.Atoi(strings.TrimSpace(result.Stdout))
+}
+
+func (hook snapperHook) Before(ctx context.Context, call *utils.MutatingToolCall) (string, error) {
+	if call.Executable != "zypper" {
+		return "", nil
+	}
+	number, err := createSnapshot(ctx, "--type", "pre",
+		"--description", "mcp-server-admintasks: "+call.Tool,
+		"--userdata", userdataFor(call))
+	if err != nil {
+		return "", err
+	}
+	call.State["snapper_pre"] = number
+	return "", nil
+}
+
+// PreSnapshot returns the number of the pre snapshot taken before the
+// mutating tool call of ctx.
+func PreSnapshot(ctx context.Context) (int, bool) {
+	call := utils.MutatingCallFromContext(ctx)
+	if call == nil {
+		return 0, false
+	}
+	number, ok := call.State["snapper_pre"].(int)
+	return number, ok
+}
+
+func (hook snapperHook) After(ctx context.Context, call *utils.MutatingToolCall, result *mcp.CallToolResult) (string, error) {
+	preNumber, ok := call.State["snapper_pre"].(int)
+	if !ok {
+		return "", nil
+	}
+	if call.Unchanged {
+		// no pair for a call which did nothing
+		result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "delete", strconv.Itoa(preNumber))
+		if err == nil && result.ExitCode != 0 {
+			err = fmt.Errorf("snapper delete %d failed: %s", preNumber, strings.TrimSpace(result.Stderr))
+		}
+		return "", err
+	}
+	postNumber, err := createSnapshot(ctx, "--type", "post",
+		"--pre-number", strconv.Itoa(preNumber),
+		"--description", "mcp-server-admintasks: "+call.Tool,
+		"--userdata", userdataFor(call))
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := json.Marshal(map[string]any{"snapper": map[string]int{"pre": preNumber, "post": postNumber}})
+	return string(jsonData), err
+}
+
+func listSnapshots(ctx context.Context) ([]snapperSnapshot, error) {
+	result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "", "--jsonout", "list", "--disable-used-space")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("snapper list failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	var snapshots map[string][]snapperSnapshot
+	if err := json.Unmarshal([]byte(result.Stdout), &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to parse snapper output: %v", err)
+	}
+	return snapshots[snapperConfig], nil
+}
+
+// ListAgentSnapshotPairs returns the snapshot pairs created by this
+// server, the most recent first.
+func ListAgentSnapshotPairs(ctx context.Context) ([]SnapshotPair, error) {
+	snapshots, err := listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make(map[int]*SnapshotPair)
+	for _, snapshot := range snapshots {
+		if snapshot.Userdata[userdataTool] == "" {
+			continue
+		}
+		switch snapshot.Type {
+		case "pre":
+			pairs[snapshot.Number] = &SnapshotPair{
+				Pre:         snapshot.Number,
+				Date:        snapshot.Date,
+				Tool:        snapshot.Userdata[userdataTool],
+				Session:     snapshot.Userdata[userdataSession],
+				CallID:      snapshot.Userdata[userdataCall],
+				Description: snapshot.Description,
+			}
+		case "post":
+			if snapshot.PreNumber != nil {
+				if pair, ok := pairs[*snapshot.PreNumber]; ok {
+					pair.Post = snapshot.Number
+				}
+			}
+		}
+	}
+	var pairList []SnapshotPair
+	for _, pair := range pairs {
+		pairList = append(pairList, *pair)
+	}
+	sort.Slice(pairList, func(i, j int) bool { return pairList[i].Pre > pairList[j].Pre })
+	return pairList, nil
+}
+
+func findAgentPair(ctx context.Context, pre int, post int) error {
+	pairs, err := ListAgentSnapshotPairs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if pair.Pre == pre && pair.Post == post {
+			return nil
+		}
+	}
+	return fmt.Errorf("%d..%d is not a snapshot pair created by this server", pre, post)
+}
+
+// parseSnapperStatus parses lines like "c..... /etc/zypp/zypp.conf".
+func parseSnapperStatus(output string) []ChangedFile {
+	var files []ChangedFile
+	for _, line := range strings.Split(output, "\n") {
+		status, path, found := strings.Cut(line, " ")
+		if !found || !strings.HasPrefix(path, "/") {
+			continue
+		}
+		files = append(files, ChangedFile{Status: status, Path: path})
+	}
+	return files
+}
+
+func numberArgument(req mcp.CallToolRequest, name string) (int, error) {
+	number, ok := req.GetArguments()[name].(float64)
+	if !ok || number < 1 {
+		return 0, fmt.Errorf("%s must be a snapshot number", name)
+	}
+	return int(number), nil
+}
+
+func pathArgument(req mcp.CallToolRequest) (string, error) {
+	path, _ := req.GetArguments()["path"].(string)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return "", errors.New("path must be absolute")
+	}
+	return path, nil
+}
+
+func addToolsToMCPServer() {
+	mcpToolList := mcp.NewTool("snapper_list_agent_snapshots",
+		mcp.WithDescription(snapperCmd.SubCommands["list"].Summary+" Each pair has the tool, MCP session and call it was taken for."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pairs, err := ListAgentSnapshotPairs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return utils.JSONToolResult(map[string]any{"snapshot_pairs": pairs})
+	})
+	utils.RecordRegisteredTool("snapper_list_agent_snapshots")
+
+	mcpToolDiff := mcp.NewTool("snapper_diff",
+		mcp.WithDescription(snapperCmd.SubCommands["status"].Summary),
+		mcp.WithNumber("pre", mcp.Required(), mcp.Description("PRE snapshot number")),
+		mcp.WithNumber("post", mcp.Required(), mcp.Description("POST snapshot number")),
+		mcp.WithString("path", mcp.Description("PATH of a single file to show the diff for, empty for the list of changed files")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolDiff, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pre, err := numberArgument(req, "pre")
+		if err != nil {
+			return nil, err
+		}
+		post, err := numberArgument(req, "post")
+		if err != nil {
+			return nil, err
+		}
+		path, err := pathArgument(req)
+		if err != nil {
+			return nil, err
+		}
+		snapshotRange := fmt.Sprintf("%d..%d", pre, post)
+		if path != "" {
+			result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "diff", snapshotRange, path)
+			if err != nil {
+				return nil, err
+			}
+			if result.ExitCode != 0 {
+				return mcp.NewToolResultError(strings.TrimSpace(result.Stderr)), nil
+			}
+			return mcp.NewToolResultText(result.Stdout), nil
+		}
+		result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "status", snapshotRange)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			return mcp.NewToolResultError(strings.TrimSpace(result.Stderr)), nil
+		}
+		return utils.JSONToolResult(map[string]any{"pre": pre, "post": post, "changed_files": parseSnapperStatus(result.Stdout)})
+	})
+	utils.RecordRegisteredTool("snapper_diff")
+
+	mcpToolUndo := mcp.NewTool("snapper_undo",
+		mcp.WithDescription(snapperCmd.SubCommands["undochange"].Summary),
+		mcp.WithNumber("pre", mcp.Required(), mcp.Description("PRE snapshot number")),
+		mcp.WithNumber("post", mcp.Required(), mcp.Description("POST snapshot number")),
+		mcp.WithString("path", mcp.Description("PATH of a single file to revert, empty to revert all changes of the pair")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolUndo, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pre, err := numberArgument(req, "pre")
+		if err != nil {
+			return nil, err
+		}
+		post, err := numberArgument(req, "post")
+		if err != nil {
+			return nil, err
+		}
+		path, err := pathArgument(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := findAgentPair(ctx, pre, post); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		params := []string{fmt.Sprintf("%d..%d", pre, post)}
+		if path != "" {
+			params = append(params, path)
+		}
+		result, err := utils.RunSystemCmd(ctx, snapperCmd, true, "undochange", params...)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			return mcp.NewToolResultError(strings.TrimSpace(result.Stdout + result.Stderr)), nil
+		}
+		return utils.JSONToolResult(map[string]any{"pre": pre, "post": post, "success": true, "output": strings.TrimSpace(result.Stdout)})
+	})
+	utils.RecordRegisteredTool("snapper_undo")
+	utils.MarkToolMutating("snapper_undo", snapperCmd.Executable)
+}
+
+// IsApplicable checks for a btrfs root file system with a snapper
+// config, snapshots are not possible otherwise.
+func IsApplicable() (bool, string) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs("/", &stat); err != nil || stat.Type != btrfsSuperMagic {
+		return false, "root file system is not btrfs"
+	}
+	if _, err := os.Stat(snapperConfigFile); err != nil {
+		return false, "no snapper config " + snapperConfig
+	}
+	return true, ""
+}
+
+func runTests() {
+	// Convert struct to JSON
+	jsonData, err := json.MarshalIndent(snapperCmd, "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+		return
+	}
+	// Write JSON to file
+	err = os.WriteFile("snapper.json", jsonData, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		snapperDebug = debugMode == utils.Debug
+		if applicable, reason := IsApplicable(); !applicable {
+			for _, toolName := range []string{"snapper_list_agent_snapshots", "snapper_diff", "snapper_undo"} {
+				utils.RecordSkippedTool(toolName, snapperCmd.Executable, reason)
+			}
+			return
+		}
+		if !utils.IsSubCmdAvailable(snapperCmd, "snapper_list_agent_snapshots", snapperCmd.SubCommands["list"]) {
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-snapper")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if snapperDebug {
+			sysLog.Info("taking snapper snapshots around mutating zypper tools")
+		}
+		utils.RegisterMutationHook(snapperHook{})
+		addToolsToMCPServer()
+	case utils.Test:
+		snapperDebug = true
+		runTests()
+	}
+}
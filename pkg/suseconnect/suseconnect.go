@@ -0,0 +1,507 @@
+package suseconnect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var suseConnectDebug bool
+
+// Registration codes of extensions, readable by the server only
+const defaultRegistrationCodesFile = "/etc/mcp-server-admintasks/regcodes.json"
+
+// "sle-module-containers/15.6/x86_64"
+var productPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*/[0-9][0-9.]*/[A-Za-z0-9_]+$`)
+
+// "Activate with: SUSEConnect -p sle-module-live-patching/15.6/x86_64 -r ADDITIONAL REGCODE"
+var activateLinePattern = regexp.MustCompile(`^(Activate|Deactivate) with: SUSEConnect (?:-d )?-p (\S+)(.*)$`)
+
+var suseConnectCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "SUSEConnect",
+	Description:       "Register SUSE Linux Enterprise installations with the SUSE Customer Center or a local registration server, and activate modules and extensions",
+	NeedsRootHandling: true,
+	DefaultParameters: []string{},
+	Distributions:     []string{"sles", "sled", "sles_sap", "sle-micro", "sl-micro"},
+	SubCommands: map[string]utils.SingleSubCmd{
+		"--status": {
+			CmdGroup:       "Registration Commands",
+			Summary:        "Show the registration status of the installed products as JSON.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     false,
+			Parameters:     []string{},
+		},
+		"--list-extensions": {
+			CmdGroup:       "Registration Commands",
+			Summary:        "List the modules and extensions available for the registered base product.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     false,
+			Parameters:     []string{},
+		},
+		"--product": {
+			CmdGroup:       "Registration Commands",
+			Summary:        "Activate a module or extension.",
+			Description:    "PRODUCT is identifier/version/arch as shown by --list-extensions. Extensions which need an additional registration code get it from the registration codes file of the server.",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PRODUCT"},
+		},
+		"--de-register --product": {
+			CmdGroup:       "Registration Commands",
+			Summary:        "Deactivate a module or extension.",
+			Description:    "PRODUCT is identifier/version/arch as shown by --list-extensions.",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PRODUCT"},
+		},
+	},
+}
+
+// ProductStatus is an entry of SUSEConnect --status, without the
+// registration code.
+type ProductStatus struct {
+	Identifier         string `json:"identifier"`
+	Version            string `json:"version"`
+	Arch               string `json:"arch"`
+	Status             string `json:"status"`
+	Name               string `json:"name,omitempty"`
+	Type               string `json:"type,omitempty"`
+	StartsAt           string `json:"starts_at,omitempty"`
+	ExpiresAt          string `json:"expires_at,omitempty"`
+	SubscriptionStatus string `json:"subscription_status,omitempty"`
+}
+
+func (status ProductStatus) Product() string {
+	return status.Identifier + "/" + status.Version + "/" + status.Arch
+}
+
+// Extension is a module or extension of SUSEConnect --list-extensions.
+// Parent is the product it depends on, "" for the base product.
+type Extension struct {
+	Name            string `json:"name"`
+	Product         string `json:"product"`
+	Parent          string `json:"parent,omitempty"`
+	Activated       bool   `json:"activated"`
+	Available       bool   `json:"available"`
+	NeedsRegcode    bool   `json:"needs_regcode"`
+	RegcodeProvided bool   `json:"regcode_provided"`
+}
+
+// ActivationResult is returned by Activate and Deactivate.
+type ActivationResult struct {
+	Product  string         `json:"product"`
+	Action   string         `json:"action"`
+	Success  bool           `json:"success"`
+	ExitCode int            `json:"exit_code"`
+	Status   *ProductStatus `json:"status,omitempty"`
+	Output   string         `json:"output"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// newSystemCmd creates the SUSEConnect process, the tests replace it
+var newSystemCmd = utils.NewSystemCmdContext
+
+// run runs SUSEConnect as root against the configured registration
+// server. secret is masked in the output and never logged. SUSEConnect
+// only takes the registration code as argument, see the README for the
+// sudo log.
+func run(ctx context.Context, secret string, params ...string) (utils.CmdResult, error) {
+	var result utils.CmdResult
+	if utils.AdminTasksConfig.RegistrationURL != "" {
+		params = append(params, "--url", utils.AdminTasksConfig.RegistrationURL)
+	}
+	if secret != "" {
+		params = append(params, "--regcode", secret)
+	}
+	mask := func(text string) string {
+		if secret == "" {
+			return text
+		}
+		return strings.ReplaceAll(text, secret, utils.RedactionMask)
+	}
+	cmd, err := newSystemCmd(ctx, suseConnectCmd, true, "", params...)
+	if err != nil {
+		return result, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	result.Stdout = mask(stdout.String())
+	result.Stderr = mask(stderr.String())
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return result, errors.New(mask(err.Error()))
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, nil
+}
+
+func commandError(result utils.CmdResult) error {
+	message := strings.TrimSpace(result.Stderr)
+	if message == "" {
+		message = strings.TrimSpace(result.Stdout)
+	}
+	return fmt.Errorf("SUSEConnect failed with exit code %d: %s", result.ExitCode, message)
+}
+
+func parseStatus(output string) ([]ProductStatus, error) {
+	statuses := []ProductStatus{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &statuses); err != nil {
+		return nil, fmt.Errorf("failed to decode SUSEConnect status: %v", err)
+	}
+	return statuses, nil
+}
+
+// Status returns the registration status of the installed products.
+func Status(ctx context.Context) ([]ProductStatus, error) {
+	result, err := run(ctx, "", "--status")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, commandError(result)
+	}
+	return parseStatus(result.Stdout)
+}
+
+// parseExtensions parses the tree of SUSEConnect --list-extensions, where
+// each product is a name line followed by its activation command, indented
+// by four spaces per level.
+func parseExtensions(output string) []Extension {
+	extensions := []Extension{}
+	// products of the enclosing levels
+	var parents []string
+	name := ""
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		matches := activateLinePattern.FindStringSubmatch(trimmed)
+		if matches == nil {
+			name = trimmed
+			continue
+		}
+		level := (len(line)-len(strings.TrimLeft(line, " ")))/4 - 1
+		if level < 0 {
+			level = 0
+		}
+		if level < len(parents) {
+			parents = parents[:level]
+		}
+		extension := Extension{
+			Name:         name,
+			Product:      matches[2],
+			Activated:    matches[1] == "Deactivate",
+			Available:    true,
+			NeedsRegcode: strings.Contains(matches[3], "-r "),
+		}
+		if strings.HasSuffix(name, "(Activated)") {
+			extension.Activated = true
+			extension.Name = strings.TrimSpace(strings.TrimSuffix(name, "(Activated)"))
+		}
+		if strings.HasPrefix(name, "(Not available)") {
+			extension.Available = false
+			extension.Name = strings.TrimSpace(strings.TrimPrefix(name, "(Not available)"))
+		}
+		if len(parents) > 0 {
+			extension.Parent = parents[len(parents)-1]
+		}
+		for len(parents) < level {
+			parents = append(parents, "")
+		}
+		parents = append(parents, extension.Product)
+		extensions = append(extensions, extension)
+		name = ""
+	}
+	return extensions
+}
+
+// ListExtensions returns the modules and extensions available for the
+// registered base product.
+func ListExtensions(ctx context.Context) ([]Extension, error) {
+	result, err := run(ctx, "", "--list-extensions")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, commandError(result)
+	}
+	extensions := parseExtensions(result.Stdout)
+	codes, _ := readRegistrationCodes()
+	for i := range extensions {
+		extensions[i].RegcodeProvided = registrationCode(codes, extensions[i].Product) != ""
+	}
+	return extensions, nil
+}
+
+func registrationCodesFile() string {
+	if utils.AdminTasksConfig.RegistrationCodesFile != "" {
+		return utils.AdminTasksConfig.RegistrationCodesFile
+	}
+	return defaultRegistrationCodesFile
+}
+
+// readRegistrationCodes reads the registration codes by product, either
+// identifier/version/arch or identifier only. The file must not be
+// accessible by group or others.
+func readRegistrationCodes() (map[string]string, error) {
+	path := registrationCodesFile()
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s must not be accessible by group or others (mode %04o)", path, info.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	codes := map[string]string{}
+	if err := json.Unmarshal(content, &codes); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return codes, nil
+}
+
+func registrationCode(codes map[string]string, product string) string {
+	if code, ok := codes[product]; ok {
+		return code
+	}
+	return codes[strings.SplitN(product, "/", 2)[0]]
+}
+
+func findExtension(extensions []Extension, product string) (Extension, bool) {
+	for _, extension := range extensions {
+		if extension.Product == product {
+			return extension, true
+		}
+	}
+	return Extension{}, false
+}
+
+func productStatus(ctx context.Context, product string) *ProductStatus {
+	statuses, err := Status(ctx)
+	if err != nil {
+		return nil
+	}
+	for _, status := range statuses {
+		if status.Product() == product {
+			return &status
+		}
+	}
+	return nil
+}
+
+func changeActivation(ctx context.Context, action string, product string, secret string, params ...string) (ActivationResult, error) {
+	result, err := run(ctx, secret, params...)
+	if err != nil {
+		return ActivationResult{}, err
+	}
+	activation := ActivationResult{
+		Product:  product,
+		Action:   action,
+		Success:  result.ExitCode == 0,
+		ExitCode: result.ExitCode,
+		Output:   result.Stdout,
+	}
+	if !activation.Success {
+		activation.Error = commandError(result).Error()
+	}
+	activation.Status = productStatus(ctx, product)
+	return activation, nil
+}
+
+// Activate activates product, with the registration code of the server
+// if the extension needs one.
+func Activate(ctx context.Context, product string) (ActivationResult, error) {
+	if !productPattern.MatchString(product) {
+		return ActivationResult{}, fmt.Errorf("invalid product %q, expected identifier/version/arch", product)
+	}
+	extensions, err := ListExtensions(ctx)
+	if err != nil {
+		return ActivationResult{}, err
+	}
+	extension, ok := findExtension(extensions, product)
+	if !ok {
+		return ActivationResult{}, fmt.Errorf("%s is not an extension of the registered base product", product)
+	}
+	if extension.Activated {
+		return ActivationResult{}, fmt.Errorf("%s is already activated", product)
+	}
+	if !extension.Available {
+		return ActivationResult{}, fmt.Errorf("%s is not available on the registration server", product)
+	}
+	secret := ""
+	if extension.NeedsRegcode {
+		codes, err := readRegistrationCodes()
+		if err != nil {
+			return ActivationResult{}, err
+		}
+		secret = registrationCode(codes, product)
+		if secret == "" {
+			return ActivationResult{}, fmt.Errorf("%s needs a registration code, add it to %s", product, registrationCodesFile())
+		}
+	}
+	return changeActivation(ctx, "activate", product, secret, "--product", product)
+}
+
+// Deactivate deactivates product. The base product cannot be deactivated.
+func Deactivate(ctx context.Context, product string) (ActivationResult, error) {
+	if !productPattern.MatchString(product) {
+		return ActivationResult{}, fmt.Errorf("invalid product %q, expected identifier/version/arch", product)
+	}
+	extensions, err := ListExtensions(ctx)
+	if err != nil {
+		return ActivationResult{}, err
+	}
+	extension, ok := findExtension(extensions, product)
+	if !ok {
+		return ActivationResult{}, fmt.Errorf("%s is not an extension of the registered base product", product)
+	}
+	if !extension.Activated {
+		return ActivationResult{}, fmt.Errorf("%s is not activated", product)
+	}
+	for _, other := range extensions {
+		if other.Parent == product && other.Activated {
+			return ActivationResult{}, fmt.Errorf("%s is needed by the activated %s", product, other.Product)
+		}
+	}
+	return changeActivation(ctx, "deactivate", product, "", "--de-register", "--product", product)
+}
+
+func activationToolResult(result ActivationResult) (*mcp.CallToolResult, error) {
+	jsonData, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return mcp.NewToolResultError(string(jsonData)), nil
+	}
+	return mcp.NewToolResultText(string(jsonData)), nil
+}
+
+func addToolsToMCPServer() {
+	statusCmd := suseConnectCmd.SubCommands["--status"]
+	if utils.IsSubCmdAvailable(suseConnectCmd, "suseconnect_status", statusCmd) {
+		mcpToolStatus := mcp.NewTool("suseconnect_status",
+			mcp.WithDescription(statusCmd.Summary+" Contains the subscription state and expiry, but not the registration codes."),
+		)
+		utils.AdminTasksMCPServer.AddTool(mcpToolStatus, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			statuses, err := Status(ctx)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return utils.JSONToolResult(statuses)
+		})
+		utils.RecordRegisteredTool("suseconnect_status")
+	}
+
+	listCmd := suseConnectCmd.SubCommands["--list-extensions"]
+	if utils.IsSubCmdAvailable(suseConnectCmd, "suseconnect_list_extensions", listCmd) {
+		mcpToolList := mcp.NewTool("suseconnect_list_extensions",
+			mcp.WithDescription(listCmd.Summary+" Shows which are activated, which need an additional registration code and whether the server has one for them."),
+		)
+		utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			extensions, err := ListExtensions(ctx)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return utils.JSONToolResult(extensions)
+		})
+		utils.RecordRegisteredTool("suseconnect_list_extensions")
+	}
+
+	for _, tool := range []struct {
+		name   string
+		subcmd string
+		change func(context.Context, string) (ActivationResult, error)
+	}{
+		{"suseconnect_activate", "--product", Activate},
+		{"suseconnect_deactivate", "--de-register --product", Deactivate},
+	} {
+		newCmd := suseConnectCmd.SubCommands[tool.subcmd]
+		if utils.DetectedOSRelease.ReadOnlyRoot {
+			utils.RecordSkippedTool(tool.name, suseConnectCmd.Executable, "read-only root file system, use transactional-update register")
+			continue
+		}
+		if !utils.IsSubCmdAvailable(suseConnectCmd, tool.name, newCmd) {
+			continue
+		}
+		change := tool.change
+		mcpTool := mcp.NewTool(tool.name,
+			mcp.WithDescription(newCmd.Summary+" "+newCmd.Description+" The result contains the registration status of the product afterwards."),
+			mcp.WithString("product", mcp.Required(), mcp.Description("Product as identifier/version/arch, e.g. sle-module-containers/15.6/x86_64")),
+		)
+		utils.AdminTasksMCPServer.AddTool(mcpTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			product, _ := req.GetArguments()["product"].(string)
+			result, err := change(ctx, strings.TrimSpace(product))
+			if err != nil {
+				// refused before SUSEConnect changed anything
+				utils.MarkCallUnchanged(ctx)
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return activationToolResult(result)
+		})
+		utils.RecordRegisteredTool(tool.name)
+		utils.MarkToolMutating(tool.name, suseConnectCmd.Executable)
+	}
+}
+
+func runTests() {
+	// Convert struct to JSON
+	jsonData, err := json.MarshalIndent(suseConnectCmd, "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+		return
+	}
+	// Write JSON to file
+	err = os.WriteFile("suseconnect.json", jsonData, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		suseConnectDebug = debugMode == utils.Debug
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-suseconnect")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if suseConnectDebug {
+			sysLog.Info("registration codes are read from " + registrationCodesFile())
+		}
+		addToolsToMCPServer()
+	case utils.Test:
+		suseConnectDebug = true
+		runTests()
+	}
+}
@@ -0,0 +1,196 @@
+package suseconnect
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcp-server-admintasks/pkg/utils"
+)
+
+const testRegistrationURL = "https://rmt.example.com"
+
+// fakeSUSEConnect runs testdata/SUSEConnect instead of SUSEConnect for
+// the test, with the given registration codes file. It returns the file
+// the command lines are written to.
+func fakeSUSEConnect(t *testing.T, codes map[string]string, mode os.FileMode) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	fake, err := filepath.Abs("testdata/SUSEConnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedCmd, savedConfig := newSystemCmd, utils.AdminTasksConfig
+	t.Cleanup(func() { newSystemCmd, utils.AdminTasksConfig = savedCmd, savedConfig })
+	newSystemCmd = func(ctx context.Context, systemCmd utils.SystemCmd, isRootRequired bool, subcmd string, params ...string) (*exec.Cmd, error) {
+		if systemCmd.Executable != "SUSEConnect" || !isRootRequired || subcmd != "" {
+			t.Errorf("unexpected command %s %v %q", systemCmd.Executable, isRootRequired, subcmd)
+		}
+		cmd := exec.CommandContext(ctx, fake, append(append([]string{}, systemCmd.DefaultParameters...), params...)...)
+		cmd.Env = append(utils.ChildEnvironment(), "SUSECONNECT_ARGS="+argsFile)
+		return cmd, nil
+	}
+	utils.AdminTasksConfig.RegistrationURL = testRegistrationURL
+	utils.AdminTasksConfig.RegistrationCodesFile = filepath.Join(dir, "regcodes.json")
+	if codes != nil {
+		content, _ := json.Marshal(codes)
+		if err := os.WriteFile(utils.AdminTasksConfig.RegistrationCodesFile, content, mode); err != nil {
+			t.Fatal(err)
+		}
+		// independent of the umask
+		if err := os.Chmod(utils.AdminTasksConfig.RegistrationCodesFile, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return argsFile
+}
+
+// commandLines returns the command lines the fake was called with.
+func commandLines(t *testing.T, argsFile string) []string {
+	t.Helper()
+	content, err := os.ReadFile(argsFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestParseExtensions(t *testing.T) {
+	output, err := os.ReadFile("testdata/list-extensions.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Extension{
+		{Name: "Basesystem Module 15 SP6 x86_64", Product: "sle-module-basesystem/15.6/x86_64", Activated: true, Available: true},
+		{Name: "Containers Module 15 SP6 x86_64", Product: "sle-module-containers/15.6/x86_64", Parent: "sle-module-basesystem/15.6/x86_64", Available: true},
+		{Name: "Desktop Applications Module 15 SP6 x86_64", Product: "sle-module-desktop-applications/15.6/x86_64", Parent: "sle-module-basesystem/15.6/x86_64", Activated: true, Available: true},
+		{Name: "Development Tools Module 15 SP6 x86_64", Product: "sle-module-development-tools/15.6/x86_64", Parent: "sle-module-desktop-applications/15.6/x86_64", Available: true},
+		{Name: "SUSE Linux Enterprise Workstation Extension 15 SP6 x86_64", Product: "sle-we/15.6/x86_64", Parent: "sle-module-desktop-applications/15.6/x86_64", Available: true, NeedsRegcode: true},
+		{Name: "SUSE Linux Enterprise Live Patching 15 SP6 x86_64", Product: "sle-module-live-patching/15.6/x86_64", NeedsRegcode: true},
+	}
+	if extensions := parseExtensions(string(output)); !reflect.DeepEqual(extensions, want) {
+		t.Errorf("extensions\n%+v\nwant\n%+v", extensions, want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	argsFile := fakeSUSEConnect(t, nil, 0)
+	statuses, err := Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || statuses[0].Product() != "SLES/15.6/x86_64" || statuses[0].SubscriptionStatus != "ACTIVE" || statuses[2].Status != "Registered" {
+		t.Errorf("statuses %+v", statuses)
+	}
+	// the registration codes of the products are left out
+	jsonData, _ := json.Marshal(statuses)
+	if strings.Contains(string(jsonData), "REGCODE") {
+		t.Errorf("registration code in the status: %s", jsonData)
+	}
+	if lines := commandLines(t, argsFile); !reflect.DeepEqual(lines, []string{"--status --url " + testRegistrationURL}) {
+		t.Errorf("command lines %q", lines)
+	}
+}
+
+func TestListExtensionsRegcodeProvided(t *testing.T) {
+	fakeSUSEConnect(t, map[string]string{"sle-we": "WE-SECRET-9876"}, 0600)
+	extensions, err := ListExtensions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, extension := range extensions {
+		if want := extension.Product == "sle-we/15.6/x86_64"; extension.RegcodeProvided != want {
+			t.Errorf("%s: regcode provided %v", extension.Product, extension.RegcodeProvided)
+		}
+	}
+}
+
+func TestActivate(t *testing.T) {
+	const secret = "WE-SECRET-9876"
+	// the code of identifier/version/arch is preferred over the identifier
+	argsFile := fakeSUSEConnect(t, map[string]string{"sle-we/15.6/x86_64": secret, "sle-we": "OTHER-CODE"}, 0600)
+	result, err := Activate(context.Background(), "sle-we/15.6/x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Status == nil || result.Status.Status != "Registered" {
+		t.Errorf("activation %+v", result)
+	}
+	if strings.Contains(result.Output, secret) || !strings.Contains(result.Output, "with code "+utils.RedactionMask) {
+		t.Errorf("output %q", result.Output)
+	}
+	lines := commandLines(t, argsFile)
+	want := []string{
+		"--list-extensions --url " + testRegistrationURL,
+		"--product sle-we/15.6/x86_64 --url " + testRegistrationURL + " --regcode " + secret,
+		"--status --url " + testRegistrationURL,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("command lines\n%q\nwant\n%q", lines, want)
+	}
+}
+
+func TestActivateFailureMasked(t *testing.T) {
+	const secret = "WRONG-CODE"
+	fakeSUSEConnect(t, map[string]string{"sle-we": secret}, 0600)
+	result, err := Activate(context.Background(), "sle-we/15.6/x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Success || result.ExitCode != 67 {
+		t.Errorf("activation %+v", result)
+	}
+	jsonData, _ := json.Marshal(result)
+	if strings.Contains(string(jsonData), secret) || !strings.Contains(result.Error, "Invalid registration code "+utils.RedactionMask) {
+		t.Errorf("result %s", jsonData)
+	}
+}
+
+func TestActivateRefused(t *testing.T) {
+	for _, tc := range []struct {
+		product string
+		codes   map[string]string
+		mode    os.FileMode
+		err     string
+	}{
+		{"sle-we/15.6/x86_64", map[string]string{"sle-we": "WE-SECRET-9876"}, 0640, "must not be accessible by group or others"},
+		{"sle-we/15.6/x86_64", map[string]string{"sle-we": "WE-SECRET-9876"}, 0604, "must not be accessible by group or others"},
+		{"sle-we/15.6/x86_64", nil, 0, "needs a registration code"},
+		{"sle-module-basesystem/15.6/x86_64", nil, 0, "already activated"},
+		{"sle-module-live-patching/15.6/x86_64", nil, 0, "not available"},
+		{"sle-ha/15.6/x86_64", nil, 0, "not an extension"},
+		{"sle-we; reboot", nil, 0, "invalid product"},
+	} {
+		argsFile := fakeSUSEConnect(t, tc.codes, tc.mode)
+		_, err := Activate(context.Background(), tc.product)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s with mode %04o: %v, want %q", tc.product, tc.mode, err, tc.err)
+		}
+		for _, line := range commandLines(t, argsFile) {
+			if strings.Contains(line, "--product") {
+				t.Errorf("%s: SUSEConnect called with %q", tc.product, line)
+			}
+		}
+	}
+}
+
+func TestReadRegistrationCodes(t *testing.T) {
+	fakeSUSEConnect(t, nil, 0)
+	if codes, err := readRegistrationCodes(); err != nil || len(codes) != 0 {
+		t.Errorf("missing file: %v %v", codes, err)
+	}
+	fakeSUSEConnect(t, map[string]string{"sle-we": "WE-SECRET-9876"}, 0400)
+	if codes, err := readRegistrationCodes(); err != nil || codes["sle-we"] != "WE-SECRET-9876" {
+		t.Errorf("mode 0400: %v %v", codes, err)
+	}
+	fakeSUSEConnect(t, map[string]string{"sle-we": "WE-SECRET-9876"}, 0660)
+	if _, err := readRegistrationCodes(); err == nil {
+		t.Error("mode 0660 accepted")
+	}
+}
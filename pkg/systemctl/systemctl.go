@@ -32,6 +32,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			IsEnabled:      true,
 			IsRootRequired: false,
 			Parameters:     []string{"UNIT name or PATTERN / regular expression"},
+			MinVersion:     "252",
 		},
 		"list-paths": {
 			CmdGroup:       "Unit Commands",
@@ -40,6 +41,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			IsEnabled:      true,
 			IsRootRequired: false,
 			Parameters:     []string{"UNIT name or PATTERN / regular expression"},
+			MinVersion:     "254",
 		},
 		"list-sockets": {
 			CmdGroup:       "Unit Commands",
@@ -119,6 +121,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"stop": {
@@ -127,6 +130,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"reload": {
@@ -135,6 +139,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"restart": {
@@ -143,6 +148,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"try-restart": {
@@ -151,6 +157,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"reload-or-restart": {
@@ -159,6 +166,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"try-reload-or-restart": {
@@ -167,6 +175,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"isolate": {
@@ -175,6 +184,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"kill": {
@@ -183,6 +193,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"clean": {
@@ -191,7 +202,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
+			MinVersion:     "243",
 		},
 		"freeze": {
 			CmdGroup:       "Unit Commands",
@@ -199,7 +212,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name or PATTERN / regular expression"},
+			MinVersion:     "246",
 		},
 		"thaw": {
 			CmdGroup:       "Unit Commands",
@@ -207,7 +222,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name or PATTERN / regular expression"},
+			MinVersion:     "246",
 		},
 		"set-property": {
 			CmdGroup:       "Unit Commands",
@@ -215,6 +232,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name", "PROPERTY name", "VALUE"},
 		},
 		"bind": {
@@ -223,7 +241,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name", "PATH"},
+			MinVersion:     "246",
 		},
 		"mount-image": {
 			CmdGroup:       "Unit Commands",
@@ -231,7 +251,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name", "PATH", "OPTIONS"},
+			MinVersion:     "248",
 		},
 		"service-log-level": {
 			CmdGroup:       "Unit Commands",
@@ -239,7 +261,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "Get/set logging threshold for service. If the optional argument LEVEL is provided, then change the current log level of the service to LEVEL. The log level should be a typical syslog log level, i.e. a value in the range 0...7 or one of the strings emerg, alert, crit, err, warning, notice, info, debug; see syslog(3) for details.",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"SERVICE name", "LEVEL"},
+			MinVersion:     "247",
 		},
 		"service-log-target": {
 			CmdGroup:       "Unit Commands",
@@ -247,7 +271,9 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "If the optional argument TARGET is provided, then change the current log target of the service to TARGET. The log target should be one of the strings console (for log output to the service's standard error stream), kmsg (for log output to the kernel log buffer), journal (for log output to systemd-journald.service(8) using the native journal protocol), syslog (for log output to the classic syslog socket /dev/log), null (for no log output whatsoever) or auto (for an automatically determined choice, typically equivalent to console if the service is invoked interactively, and journal or syslog otherwise).",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"SERVICE name", "TARGET"},
+			MinVersion:     "247",
 		},
 		"reset-failed": {
 			CmdGroup:       "Unit Commands",
@@ -255,6 +281,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name or PATTERN / regular expression"},
 		},
 		"whoami": {
@@ -264,6 +291,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			IsEnabled:      false,
 			IsRootRequired: false,
 			Parameters:     []string{"PID"},
+			MinVersion:     "254",
 		},
 		"list-unit-files": {
 			CmdGroup:       "UnitFile Commands",
@@ -279,6 +307,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT or PATH: what to enable"},
 		},
 		"disable": {
@@ -287,6 +316,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT or PATH: what to disable"},
 		},
 		"reenable": {
@@ -295,6 +325,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"preset": {
 			CmdGroup:       "UnitFile Commands",
@@ -302,6 +333,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"preset-all": {
 			CmdGroup:       "UnitFile Commands",
@@ -309,6 +341,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"is-enabled": {
 			CmdGroup:       "UnitFile Commands",
@@ -324,6 +357,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"unmask": {
@@ -332,6 +366,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"link": {
@@ -340,6 +375,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"PATH"},
 		},
 		"revert": {
@@ -348,6 +384,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"add-wants": {
@@ -356,6 +393,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"TARGET", "UNIT name"},
 		},
 		"add-requires": {
@@ -364,6 +402,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"TARGET", "UNIT name"},
 		},
 		"edit": {
@@ -372,6 +411,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"UNIT name"},
 		},
 		"get-default": {
@@ -387,6 +427,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{"TARGET name"},
 		},
 		"list-machines": {
@@ -411,6 +452,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"show-environment": {
 			CmdGroup:       "Environment Commands",
@@ -425,6 +467,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"unset-environment": {
 			CmdGroup:       "Environment Commands",
@@ -432,6 +475,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"import-environment": {
 			CmdGroup:       "Environment Commands",
@@ -439,6 +483,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"daemon-reload": {
 			CmdGroup:       "ManagerState Commands",
@@ -446,6 +491,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"daemon-reexec": {
 			CmdGroup:       "ManagerState Commands",
@@ -453,6 +499,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"log-level": {
 			CmdGroup:       "ManagerState Commands",
@@ -488,6 +535,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"rescue": {
 			CmdGroup:       "System Commands",
@@ -495,6 +543,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"emergency": {
 			CmdGroup:       "System Commands",
@@ -502,6 +551,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"halt": {
 			CmdGroup:       "System Commands",
@@ -509,6 +559,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"poweroff": {
 			CmdGroup:       "System Commands",
@@ -516,6 +567,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"reboot": {
 			CmdGroup:       "System Commands",
@@ -523,6 +575,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"kexec": {
 			CmdGroup:       "System Commands",
@@ -530,6 +583,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"soft-reboot": {
 			CmdGroup:       "System Commands",
@@ -537,6 +591,8 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
+			MinVersion:     "254",
 		},
 		"exit": {
 			CmdGroup:       "System Commands",
@@ -544,6 +600,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"switch-root": {
 			CmdGroup:       "System Commands",
@@ -551,6 +608,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"sleep": {
 			CmdGroup:       "System Commands",
@@ -558,6 +616,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"suspend": {
 			CmdGroup:       "System Commands",
@@ -565,6 +624,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"hibernate": {
 			CmdGroup:       "System Commands",
@@ -572,6 +632,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"hybrid-sleep": {
 			CmdGroup:       "System Commands",
@@ -579,6 +640,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 		"suspend-then-hibernate": {
 			CmdGroup:       "System Commands",
@@ -586,6 +648,7 @@ var systemCtlCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 		},
 	},
 }
@@ -624,6 +687,7 @@ func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
 	switch debugMode {
 	case utils.Production:
 		systemctlDebug = false
+		utils.ResolveSystemCmd(systemCtlCmd)
 		if initMode == utils.Typed {
 			for key := range systemCtlCmd.SubCommands {
 				utils.AddToolToMCPServer(systemCtlCmd, jsonSystemCtlSubCmds, key, systemCtlCmd.SubCommands[key])
@@ -631,6 +695,7 @@ func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
 		}
 	case utils.Debug:
 		systemctlDebug = true
+		utils.ResolveSystemCmd(systemCtlCmd)
 		if initMode == utils.Typed {
 			for key := range systemCtlCmd.SubCommands {
 				utils.AddToolToMCPServer(systemCtlCmd, jsonSystemCtlSubCmds, key, systemCtlCmd.SubCommands[key])
@@ -0,0 +1,112 @@
+package systemctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+)
+
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+$`)
+
+// states of ActiveState which change by themselves
+var transientStates = map[string]bool{"activating": true, "deactivating": true, "reloading": true, "refreshing": true}
+
+// UnitName appends .service to names without a unit type, like systemctl.
+func UnitName(name string) (string, error) {
+	if !unitNamePattern.MatchString(name) || strings.HasPrefix(name, "-") {
+		return "", fmt.Errorf("invalid unit name %q", name)
+	}
+	if !strings.Contains(name, ".") {
+		return name + ".service", nil
+	}
+	return name, nil
+}
+
+// ActiveState returns the ActiveState of unit, e.g. active or failed.
+func ActiveState(ctx context.Context, unit string) (string, error) {
+	result, err := utils.RunSystemCmd(ctx, systemCtlCmd, false, "show", "--property=ActiveState", "--value", unit)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("systemctl show %s failed: %s", unit, strings.TrimSpace(result.Stderr))
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// RestartUnit restarts unit and waits up to timeout until it leaves the
+// transient states. It returns the final ActiveState.
+func RestartUnit(ctx context.Context, unit string, timeout time.Duration) (string, error) {
+	result, err := utils.RunSystemCmd(ctx, systemCtlCmd, true, "restart", unit)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		state, _ := ActiveState(ctx, unit)
+		return state, fmt.Errorf("systemctl restart %s failed: %s", unit, strings.TrimSpace(result.Stderr))
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := ActiveState(ctx, unit)
+		if err != nil || !transientStates[state] || time.Now().After(deadline) {
+			return state, err
+		}
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// OwnUnit returns the unit the server runs in, taken from the systemd
+// cgroup, or "" if it was not started by systemd.
+func OwnUnit() string {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	return unitOfCgroup(string(content))
+}
+
+// unitOfCgroup returns the last service or scope in the cgroup paths of a
+// /proc/<pid>/cgroup file.
+func unitOfCgroup(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || (fields[0] != "0" && fields[1] != "name=systemd") {
+			continue
+		}
+		parts := strings.Split(fields[2], "/")
+		for i := len(parts) - 1; i >= 0; i-- {
+			if strings.HasSuffix(parts[i], ".service") || strings.HasSuffix(parts[i], ".scope") {
+				return parts[i]
+			}
+		}
+	}
+	return ""
+}
+
+// FailedUnits returns the names of the units in the failed state.
+func FailedUnits(ctx context.Context) ([]string, error) {
+	result, err := utils.RunSystemCmd(ctx, systemCtlCmd, false, "list-units", "--state=failed", "--plain", "--no-legend")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("systemctl list-units failed: %s", strings.TrimSpace(result.Stderr))
+	}
+	units := []string{}
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "●"))
+		if len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units, nil
+}
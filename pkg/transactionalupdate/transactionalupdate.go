@@ -0,0 +1,332 @@
+package transactionalupdate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/utils"
+	"mcp-server-admintasks/pkg/zypper"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var transactionalUpdateDebug bool
+
+// Created by transactional-update when a new snapshot waits for a reboot
+const rebootNeededFile = "/run/reboot-needed"
+
+// "New default snapshot is #42 (/.snapshots/42/snapshot)."
+var newSnapshotPattern = regexp.MustCompile(`(?i)new default snapshot is #(\d+)`)
+
+var transactionalUpdateCmd utils.SystemCmd = utils.SystemCmd{
+	Executable:        "transactional-update",
+	Description:       "Apply updates to the system in an atomic way, into a new btrfs snapshot which gets active with the next reboot",
+	NeedsRootHandling: true,
+	DefaultParameters: []string{"--non-interactive"},
+	Distributions:     []string{"suse"},
+	SubCommands: map[string]utils.SingleSubCmd{
+		"pkg install": {
+			CmdGroup:       "Package Commands",
+			Summary:        "Install packages into a new snapshot.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"pkg remove": {
+			CmdGroup:       "Package Commands",
+			Summary:        "Remove packages in a new snapshot.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"pkg update": {
+			CmdGroup:       "Package Commands",
+			Summary:        "Update packages in a new snapshot.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"PACKAGE name"},
+		},
+		"up": {
+			CmdGroup:       "Update Commands",
+			Summary:        "Update all installed packages in a new snapshot.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{},
+		},
+		"patch": {
+			CmdGroup:       "Update Commands",
+			Summary:        "Install all needed patches in a new snapshot.",
+			Description:    "",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{},
+		},
+		"dup": {
+			CmdGroup:       "Update Commands",
+			Summary:        "Perform a distribution upgrade in a new snapshot.",
+			Description:    "",
+			IsEnabled:      false,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{},
+		},
+		"rollback": {
+			CmdGroup:       "Snapshot Commands",
+			Summary:        "Set the given snapshot, or the currently booted one, as default for the next boot.",
+			Description:    "SNAPSHOT is a snapshot number or 'last' for the last working snapshot. Without SNAPSHOT the currently booted snapshot becomes the default again, which drops all pending updates.",
+			IsEnabled:      true,
+			IsRootRequired: true,
+			IsMutating:     true,
+			Parameters:     []string{"SNAPSHOT"},
+		},
+	},
+}
+
+// transactionalUpdateBackend queries with zypper, but changes the system
+// with transactional-update only.
+type transactionalUpdateBackend struct {
+	pkgmgr.Backend
+}
+
+func (backend transactionalUpdateBackend) Name() string {
+	return "transactional-update"
+}
+
+func (backend transactionalUpdateBackend) SystemCmd() utils.SystemCmd {
+	return transactionalUpdateCmd
+}
+
+func (backend transactionalUpdateBackend) IsApplicable() bool {
+	return utils.DetectedOSRelease.ReadOnlyRoot
+}
+
+func (backend transactionalUpdateBackend) Install(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return runTransaction(ctx, "install", append([]string{"pkg", "install"}, packages...), packages)
+}
+
+func (backend transactionalUpdateBackend) Remove(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return runTransaction(ctx, "remove", append([]string{"pkg", "remove"}, packages...), packages)
+}
+
+func (backend transactionalUpdateBackend) Update(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	if len(packages) == 0 {
+		return runTransaction(ctx, "update", []string{"--drop-if-no-change", "up"}, packages)
+	}
+	return runTransaction(ctx, "update", append([]string{"pkg", "update"}, packages...), packages)
+}
+
+func isRebootNeeded() bool {
+	_, err := os.Stat(rebootNeededFile)
+	return err == nil
+}
+
+func parseNewSnapshot(output string) int {
+	matches := newSnapshotPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	snapshot, _ := strconv.Atoi(matches[len(matches)-1][1])
+	return snapshot
+}
+
+func runTransaction(ctx context.Context, action string, params []string, packages []string) (pkgmgr.TransactionResult, error) {
+	result, err := utils.RunSystemCmd(ctx, transactionalUpdateCmd, true, "", params...)
+	if err != nil {
+		return pkgmgr.TransactionResult{}, err
+	}
+	// transactional-update logs to stdout and stderr, the snapshot
+	// number may be in either of them
+	output := result.Stdout + result.Stderr
+	transactionResult := pkgmgr.TransactionResult{
+		Backend:      transactionalUpdateCmd.Executable,
+		Action:       action,
+		Packages:     packages,
+		ExitCode:     result.ExitCode,
+		Success:      result.ExitCode == 0,
+		Snapshot:     parseNewSnapshot(output),
+		RebootNeeded: isRebootNeeded(),
+		Output:       output,
+	}
+	if !transactionResult.Success {
+		transactionResult.Error = strings.TrimSpace(result.Stderr)
+	}
+	return transactionResult, nil
+}
+
+func transactionResultToMCP(result pkgmgr.TransactionResult) (*mcp.CallToolResult, error) {
+	jsonData, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return mcp.NewToolResultError(string(jsonData)), nil
+	}
+	return mcp.NewToolResultText(string(jsonData)), nil
+}
+
+func packagesArgument(req mcp.CallToolRequest) ([]string, error) {
+	var packages []string
+	argsSlice, _ := req.GetArguments()["packages"].([]interface{})
+	for _, arg := range argsSlice {
+		if name, ok := arg.(string); ok && name != "" {
+			packages = append(packages, name)
+		}
+	}
+	if len(packages) == 0 {
+		return nil, errors.New("no packages given")
+	}
+	return packages, pkgmgr.ValidatePackageNames(packages)
+}
+
+func addPackageTool(cmdName string, action string) {
+	newCmd := transactionalUpdateCmd.SubCommands[cmdName]
+	toolName := "transactional_update_" + strings.ReplaceAll(cmdName, " ", "_")
+	if !newCmd.IsEnabled || !utils.IsSubCmdAvailable(transactionalUpdateCmd, toolName, newCmd) {
+		return
+	}
+	mcpTool := mcp.NewTool(toolName,
+		mcp.WithDescription(newCmd.Summary+" The changes are active after the next reboot, the result contains the new snapshot number."),
+		mcp.WithArray("packages", mcp.Required(), mcp.Description("Names of the packages"), mcp.Items(map[string]any{"type": "string"})),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		packages, err := packagesArgument(req)
+		if err != nil {
+			return nil, fmt.Errorf("Error in %s: %v", toolName, err)
+		}
+		result, err := runTransaction(ctx, action, append(strings.Fields(cmdName), packages...), packages)
+		if err != nil {
+			return nil, err
+		}
+		return transactionResultToMCP(result)
+	})
+	utils.RecordRegisteredTool(toolName)
+	utils.MarkToolMutating(toolName, transactionalUpdateCmd.Executable)
+}
+
+func addSystemTool(cmdName string, params []string) {
+	newCmd := transactionalUpdateCmd.SubCommands[cmdName]
+	toolName := "transactional_update_" + cmdName
+	if !newCmd.IsEnabled || !utils.IsSubCmdAvailable(transactionalUpdateCmd, toolName, newCmd) {
+		return
+	}
+	mcpTool := mcp.NewTool(toolName,
+		mcp.WithDescription(newCmd.Summary+" The changes are active after the next reboot, the result contains the new snapshot number."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := runTransaction(ctx, cmdName, params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return transactionResultToMCP(result)
+	})
+	utils.RecordRegisteredTool(toolName)
+	utils.MarkToolMutating(toolName, transactionalUpdateCmd.Executable)
+}
+
+func addToolsToMCPServer() {
+	addPackageTool("pkg install", "install")
+	addPackageTool("pkg remove", "remove")
+	addPackageTool("pkg update", "update")
+	addSystemTool("up", []string{"--drop-if-no-change", "up"})
+	addSystemTool("patch", []string{"--drop-if-no-change", "patch"})
+
+	rollbackCmd := transactionalUpdateCmd.SubCommands["rollback"]
+	if rollbackCmd.IsEnabled && utils.IsSubCmdAvailable(transactionalUpdateCmd, "transactional_update_rollback", rollbackCmd) {
+		mcpToolRollback := mcp.NewTool("transactional_update_rollback",
+			mcp.WithDescription(rollbackCmd.Summary+" "+rollbackCmd.Description),
+			mcp.WithString("snapshot", mcp.Description("SNAPSHOT number or 'last', empty for the currently booted snapshot")),
+		)
+		utils.AdminTasksMCPServer.AddTool(mcpToolRollback, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			params := []string{"rollback"}
+			snapshot, _ := req.GetArguments()["snapshot"].(string)
+			if snapshot != "" {
+				if _, err := strconv.Atoi(snapshot); err != nil && snapshot != "last" {
+					return nil, errors.New("Error in transactional_update_rollback: snapshot must be a number or 'last'")
+				}
+				params = append(params, snapshot)
+			}
+			result, err := runTransaction(ctx, "rollback", params, nil)
+			if err != nil {
+				return nil, err
+			}
+			// a rollback always needs a reboot to get active
+			result.RebootNeeded = result.Success || result.RebootNeeded
+			return transactionResultToMCP(result)
+		})
+		utils.RecordRegisteredTool("transactional_update_rollback")
+		utils.MarkToolMutating("transactional_update_rollback", transactionalUpdateCmd.Executable)
+	}
+
+	mcpToolStatus := mcp.NewTool("transactional_update_status",
+		mcp.WithDescription("Show whether a new snapshot created by transactional-update is waiting for a reboot."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolStatus, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		status := map[string]any{
+			"read_only_root": utils.DetectedOSRelease.ReadOnlyRoot,
+			"reboot_needed":  isRebootNeeded(),
+		}
+		jsonData, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(jsonData)), nil
+	})
+	utils.RecordRegisteredTool("transactional_update_status")
+}
+
+func runTests() {
+	// Convert struct to JSON
+	jsonData, err := json.MarshalIndent(transactionalUpdateCmd, "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+		return
+	}
+	// Write JSON to file
+	err = os.WriteFile("transactional-update.json", jsonData, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// INIT registers the backend for pkgmgr, which prefers it over zypper on
+// systems with a read-only root file system.
+func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	pkgmgr.RegisterBackend(transactionalUpdateBackend{Backend: zypper.NewBackend()})
+	switch debugMode {
+	case utils.Production, utils.Debug:
+		transactionalUpdateDebug = debugMode == utils.Debug
+		if !utils.DetectedOSRelease.ReadOnlyRoot {
+			return
+		}
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "mcp-server-transactional-update")
+		if syslogerr != nil {
+			log.Fatalf("Failed to connect to syslog: %v", syslogerr)
+		}
+		defer sysLog.Close()
+		if transactionalUpdateDebug {
+			sysLog.Info("read-only root file system, using transactional-update")
+		}
+		addToolsToMCPServer()
+	case utils.Test:
+		transactionalUpdateDebug = true
+		runTests()
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type ExecutableInfo struct {
+	Executable string `json:"executable"`
+	Path       string `json:"path,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Available  bool   `json:"available"`
+	Error      string `json:"error,omitempty"`
+}
+
+type SkippedTool struct {
+	Tool       string `json:"tool"`
+	Executable string `json:"executable"`
+	Reason     string `json:"reason"`
+}
+
+type ServerInfo struct {
+	Name         string           `json:"name"`
+	Version      string           `json:"version"`
+	Executables  []ExecutableInfo `json:"executables"`
+	Tools        []string         `json:"tools"`
+	SkippedTools []SkippedTool    `json:"skipped_tools"`
+}
+
+var availabilityMutex sync.Mutex
+var probedExecutables = make(map[string]ExecutableInfo)
+var registeredTools []string
+var skippedTools []SkippedTool
+
+// "systemd 255 (255.18+suse.21.gbd2a1ba2d7)" -> "255", "zypper 1.14.68" -> "1.14.68"
+var versionPattern = regexp.MustCompile(`\b(\d+(?:\.\d+)*)\b`)
+
+func parseVersionOutput(output string) string {
+	firstLine := strings.SplitN(strings.TrimSpace(output), "\n", 2)[0]
+	return versionPattern.FindString(firstLine)
+}
+
+// ProbeSystemCmd checks once whether the executable of systemCmd is
+// installed and usable, and detects its version.
+func ProbeSystemCmd(systemCmd SystemCmd) ExecutableInfo {
+	availabilityMutex.Lock()
+	defer availabilityMutex.Unlock()
+	if info, ok := probedExecutables[systemCmd.Executable]; ok {
+		return info
+	}
+	info := ExecutableInfo{Executable: systemCmd.Executable}
+	path, err := ResolveExecutable(systemCmd.Executable)
+	if err != nil {
+		info.Error = err.Error()
+		probedExecutables[systemCmd.Executable] = info
+		return info
+	}
+	info.Path = path
+	info.Available = true
+	versionParameters := systemCmd.VersionParameters
+	if len(versionParameters) == 0 {
+		versionParameters = []string{"--version"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := NewCommandContext(ctx, systemCmd.Executable, versionParameters...)
+	if err == nil {
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		err = cmd.Run()
+		info.Version = parseVersionOutput(out.String())
+	}
+	if err != nil {
+		info.Error = fmt.Sprintf("version detection failed: %v", err)
+	}
+	probedExecutables[systemCmd.Executable] = info
+	return info
+}
+
+// RecordSkippedTool adds toolName to the tools server_info reports as
+// not available, together with the reason.
+func RecordSkippedTool(toolName string, executable string, reason string) {
+	availabilityMutex.Lock()
+	skippedTools = append(skippedTools, SkippedTool{Tool: toolName, Executable: executable, Reason: reason})
+	availabilityMutex.Unlock()
+	sysLog, syslogerr := syslog.New(syslog.LOG_NOTICE, "mcp-server-admintasks")
+	if syslogerr == nil {
+		sysLog.Notice(fmt.Sprintf("skipping tool %s: %s", toolName, reason))
+		sysLog.Close()
+	}
+}
+
+// RecordRegisteredTool adds toolName to the list reported by server_info.
+func RecordRegisteredTool(toolName string) {
+	availabilityMutex.Lock()
+	registeredTools = append(registeredTools, toolName)
+	availabilityMutex.Unlock()
+}
+
+// IsSubCmdAvailable decides whether the tool toolName for newCmd can be
+// offered on this system. Unavailable tools are recorded for server_info.
+func IsSubCmdAvailable(systemCmd SystemCmd, toolName string, newCmd SingleSubCmd) bool {
+	if !MatchesDistribution(systemCmd.Distributions) {
+		RecordSkippedTool(toolName, systemCmd.Executable, "not used on distribution "+DetectedOSRelease.ID)
+		return false
+	}
+	info := ProbeSystemCmd(systemCmd)
+	if !info.Available {
+		RecordSkippedTool(toolName, systemCmd.Executable, info.Error)
+		return false
+	}
+	if newCmd.MinVersion != "" {
+		if info.Version == "" {
+			RecordSkippedTool(toolName, systemCmd.Executable, "requires version "+newCmd.MinVersion+", installed version unknown")
+			return false
+		}
+		if RpmVerCmp(info.Version, newCmd.MinVersion) < 0 {
+			RecordSkippedTool(toolName, systemCmd.Executable, "requires version "+newCmd.MinVersion+", installed is "+info.Version)
+			return false
+		}
+	}
+	return true
+}
+
+func currentServerInfo() ServerInfo {
+	availabilityMutex.Lock()
+	defer availabilityMutex.Unlock()
+	info := ServerInfo{
+		Name:         ServerName,
+		Version:      ServerVersion,
+		Executables:  []ExecutableInfo{},
+		Tools:        append([]string{}, registeredTools...),
+		SkippedTools: append([]SkippedTool{}, skippedTools...),
+	}
+	for _, executable := range probedExecutables {
+		info.Executables = append(info.Executables, executable)
+	}
+	sort.Slice(info.Executables, func(i, j int) bool { return info.Executables[i].Executable < info.Executables[j].Executable })
+	sort.Strings(info.Tools)
+	sort.Slice(info.SkippedTools, func(i, j int) bool { return info.SkippedTools[i].Tool < info.SkippedTools[j].Tool })
+	return info
+}
+
+func addServerInfoTool() {
+	mcpToolServerInfo := mcp.NewTool("server_info",
+		mcp.WithDescription("Show the detected executables and their versions, the available tools, and the tools which are not available on this system including the reason."),
+	)
+	AdminTasksMCPServer.AddTool(mcpToolServerInfo, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		jsonData, err := json.MarshalIndent(currentServerInfo(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(jsonData)), nil
+	})
+	RecordRegisteredTool("server_info")
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Location of the admin controlled configuration of the server
+const ServerConfigPath = "/etc/mcp-server-admintasks/config.json"
+
+// Name of the state directory below $XDG_STATE_HOME
+const stateDirName = "mcp-server-admintasks"
+
+type ServerConfig struct {
+	RedactPatterns []string `json:"redact_patterns"`
+	EnvPassthrough []string `json:"env_passthrough"`
+	StateDir       string   `json:"state_dir"`
+	EtcGitDir      string   `json:"etc_git_dir"`
+	SBOMDir        string   `json:"sbom_dir"`
+	InventoryDir   string   `json:"inventory_dir"`
+	OVALDir        string   `json:"oval_dir"`
+	// repositories zypper_add_repo may add, and the keys it may import
+	RepoAllowedSchemes     []string `json:"repo_allowed_schemes"`
+	RepoAllowedHosts       []string `json:"repo_allowed_hosts"`
+	TrustedGPGFingerprints []string `json:"trusted_gpg_fingerprints"`
+	// registration server and the secret registration codes of SUSEConnect
+	RegistrationURL       string `json:"registration_url"`
+	RegistrationCodesFile string `json:"registration_codes_file"`
+}
+
+var AdminTasksConfig ServerConfig
+
+func readServerConfig(filePath string) (ServerConfig, error) {
+	var newConfig ServerConfig
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No config file is fine, go with the defaults
+			return newConfig, nil
+		}
+		return newConfig, fmt.Errorf("failed to open config: %v", err)
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&newConfig)
+	if err != nil {
+		return newConfig, fmt.Errorf("failed to decode config %s: %v", filePath, err)
+	}
+	return newConfig, nil
+}
+
+// StateDir returns the directory of the files the server keeps between
+// calls. Unlike the directories created through sudo it belongs to the
+// user of the server: state_dir of the config, the StateDirectory= of the
+// systemd unit or $XDG_STATE_HOME/mcp-server-admintasks.
+func StateDir() (string, error) {
+	if AdminTasksConfig.StateDir != "" {
+		return AdminTasksConfig.StateDir, nil
+	}
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		// several directories are separated by ":"
+		return strings.SplitN(dir, ":", 2)[0], nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, stateDirName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no state directory, set state_dir in %s: %v", ServerConfigPath, err)
+	}
+	return filepath.Join(home, ".local", "state", stateDirName), nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestStateDir(t *testing.T) {
+	saved := AdminTasksConfig
+	defer func() { AdminTasksConfig = saved }()
+	t.Setenv("HOME", "/home/mcp")
+	for _, tc := range []struct {
+		config, stateDirectory, xdgStateHome string
+		want                                 string
+	}{
+		{"", "", "", "/home/mcp/.local/state/mcp-server-admintasks"},
+		{"", "", "relative", "/home/mcp/.local/state/mcp-server-admintasks"},
+		{"", "", "/var/state", "/var/state/mcp-server-admintasks"},
+		{"", "/var/lib/mcp:/var/lib/other", "/var/state", "/var/lib/mcp"},
+		{"/srv/mcp", "/var/lib/mcp", "/var/state", "/srv/mcp"},
+	} {
+		AdminTasksConfig.StateDir = tc.config
+		t.Setenv("STATE_DIRECTORY", tc.stateDirectory)
+		t.Setenv("XDG_STATE_HOME", tc.xdgStateHome)
+		if dir, err := StateDir(); err != nil || dir != tc.want {
+			t.Errorf("StateDir() = %q, %v, want %q", dir, err, tc.want)
+		}
+	}
+}
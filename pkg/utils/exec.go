@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Fixed settings for all child processes, independent of the environment
+// the server has been started with.
+const (
+	ChildLocale  = "C.UTF-8"
+	ChildPath    = "/usr/sbin:/usr/bin:/sbin:/bin"
+	ChildWorkDir = "/"
+	ChildUmask   = 0022
+)
+
+// Variables which are passed through to child processes unless the admin
+// configures a different list with "env_passthrough".
+var defaultEnvPassthrough = []string{
+	"http_proxy", "https_proxy", "ftp_proxy", "no_proxy",
+	"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "NO_PROXY",
+}
+
+var resolvedExecutables sync.Map
+
+// checkExecutableOwnership refuses binaries which could have been
+// replaced by someone else than root.
+func checkExecutableOwnership(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of %s", path)
+	}
+	if stat.Uid != 0 {
+		return fmt.Errorf("%s is not owned by root", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+	// the binary could be replaced through any writable directory above it
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if err := checkDirectoryOwnership(dir); err != nil {
+			return err
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+func checkDirectoryOwnership(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of %s", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat.Uid != 0 {
+		return fmt.Errorf("directory %s is not owned by root", dir)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("directory %s is writable by group or others", dir)
+	}
+	return nil
+}
+
+// ResolveExecutable looks up name in ChildPath and returns the absolute
+// path of the binary with all symlinks resolved, so the checked file is
+// the one which runs. The result is cached, so every executable is only
+// resolved and checked once.
+func ResolveExecutable(name string) (string, error) {
+	if path, ok := resolvedExecutables.Load(name); ok {
+		return path.(string), nil
+	}
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = []string{name}
+	} else {
+		for _, dir := range filepath.SplitList(ChildPath) {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	for _, candidate := range candidates {
+		realPath, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		if err := checkExecutableOwnership(realPath); err != nil {
+			return "", fmt.Errorf("refusing to use %s: %v", candidate, err)
+		}
+		resolvedExecutables.Store(name, realPath)
+		return realPath, nil
+	}
+	return "", fmt.Errorf("executable %s not found in %s", name, ChildPath)
+}
+
+// ChildEnvironment returns the complete environment for child processes.
+func ChildEnvironment() []string {
+	env := []string{
+		"LC_ALL=" + ChildLocale,
+		"LANG=" + ChildLocale,
+		"PATH=" + ChildPath,
+	}
+	passthrough := defaultEnvPassthrough
+	if AdminTasksConfig.EnvPassthrough != nil {
+		passthrough = AdminTasksConfig.EnvPassthrough
+	}
+	for _, name := range passthrough {
+		if name == "LC_ALL" || name == "LANG" || name == "PATH" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// NewCommand creates an exec.Cmd for a resolved executable, running in
+// the controlled environment and working directory.
+func NewCommand(executable string, args ...string) (*exec.Cmd, error) {
+	return NewCommandContext(context.Background(), executable, args...)
+}
+
+// NewCommandContext is NewCommand, with the process killed when ctx is done.
+func NewCommandContext(ctx context.Context, executable string, args ...string) (*exec.Cmd, error) {
+	path, err := ResolveExecutable(executable)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	// keep argv[0] of the symlink for tools which look at their name
+	cmd.Args[0] = executable
+	cmd.Env = ChildEnvironment()
+	cmd.Dir = ChildWorkDir
+	return cmd, nil
+}
+
+// NewRootCommand is NewCommand, run through sudo in the background
+// (sudo -b), as ExecuteSystemCall always did.
+func NewRootCommand(executable string, args ...string) (*exec.Cmd, error) {
+	return newSudoCommand(context.Background(), []string{"-b"}, nil, executable, args...)
+}
+
+// NewRootCommandContext is NewCommandContext, run through sudo in the
+// foreground, so the exit code of executable is available.
+func NewRootCommandContext(ctx context.Context, executable string, args ...string) (*exec.Cmd, error) {
+	return newSudoCommand(ctx, []string{"--non-interactive"}, nil, executable, args...)
+}
+
+func newSudoCommand(ctx context.Context, sudoOptions []string, extraEnv []string, executable string, args ...string) (*exec.Cmd, error) {
+	path, err := ResolveExecutable(executable)
+	if err != nil {
+		return nil, err
+	}
+	env := append(ChildEnvironment(), extraEnv...)
+	// sudo resets the environment, so keep LC_ALL and friends explicitly
+	sudoArgs := append(sudoOptions, "--preserve-env="+strings.Join(envNames(env), ","), path)
+	cmd, err := NewCommandContext(ctx, "sudo", append(sudoArgs, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = env
+	return cmd, nil
+}
+
+type CmdResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// NewSystemCmdContext creates the exec.Cmd RunSystemCmd runs, for
+// callers which talk to the process while it is running.
+func NewSystemCmdContext(ctx context.Context, systemCmd SystemCmd, isRootRequired bool, subcmd string, subcmdParams ...string) (*exec.Cmd, error) {
+	strArgs := append([]string{}, systemCmd.DefaultParameters...)
+	if subcmd != "" {
+		strArgs = append(strArgs, subcmd)
+	}
+	strArgs = append(strArgs, subcmdParams...)
+	if isRootRequired {
+		return newSudoCommand(ctx, []string{"--non-interactive"}, systemCmd.Environment, systemCmd.Executable, strArgs...)
+	}
+	cmd, err := NewCommandContext(ctx, systemCmd.Executable, strArgs...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, systemCmd.Environment...)
+	return cmd, nil
+}
+
+// RunSystemCmd runs subcmd of systemCmd with its default parameters and
+// waits for it. A non-zero exit code is not an error, callers have to
+// check CmdResult.ExitCode; err is only set if the command could not run.
+func RunSystemCmd(ctx context.Context, systemCmd SystemCmd, isRootRequired bool, subcmd string, subcmdParams ...string) (CmdResult, error) {
+	var result CmdResult
+	cmd, err := NewSystemCmdContext(ctx, systemCmd, isRootRequired, subcmd, subcmdParams...)
+	if err != nil {
+		return result, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return result, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	if utilsDebug {
+		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, "RunSystemCmd")
+		if syslogerr == nil {
+			sysLog.Info(fmt.Sprintf("%s -> %d", cmd.String(), result.ExitCode))
+			sysLog.Close()
+		}
+	}
+	return result, nil
+}
+
+func envNames(env []string) []string {
+	var names []string
+	for _, entry := range env {
+		names = append(names, strings.SplitN(entry, "=", 2)[0])
+	}
+	return names
+}
+
+// ResolveSystemCmd resolves the executable of systemCmd at startup and
+// logs when it cannot be used.
+func ResolveSystemCmd(systemCmd SystemCmd) error {
+	_, err := ResolveExecutable(systemCmd.Executable)
+	if err != nil {
+		sysLog, syslogerr := syslog.New(syslog.LOG_WARNING, "ResolveSystemCmd")
+		if syslogerr == nil {
+			sysLog.Warning(err.Error())
+			sysLog.Close()
+		}
+	}
+	return err
+}
+
+func setupChildProcessDefaults() {
+	syscall.Umask(ChildUmask)
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// MutatingToolCall describes a call of a tool which changes the system,
+// as handed to the registered MutationHooks.
+type MutatingToolCall struct {
+	Tool       string         `json:"tool"`
+	Executable string         `json:"executable"`
+	Arguments  map[string]any `json:"arguments"`
+	SessionID  string         `json:"session_id"`
+	CallID     string         `json:"call_id"`
+	// hooks keep their data between Before and After here
+	State map[string]any `json:"-"`
+	// set by the tool when it returned without changing anything
+	Unchanged bool `json:"-"`
+}
+
+// MutationHook is run around every call of a mutating tool. Before and
+// After return a short note for the tool result, or "" for none. An
+// error of a hook is reported, but does not stop the tool call. After
+// is run for unchanged calls too, see MarkCallUnchanged.
+type MutationHook interface {
+	Name() string
+	Before(ctx context.Context, call *MutatingToolCall) (string, error)
+	After(ctx context.Context, call *MutatingToolCall, result *mcp.CallToolResult) (string, error)
+}
+
+var mutationHooksMutex sync.RWMutex
+var mutationHooks []MutationHook
+var mutatingTools = make(map[string]string)
+var callCounter atomic.Uint64
+
+// mutatingCallMutex is held from the first Before to the last After of a
+// call, so the snapshots and commits of the hooks only contain its changes.
+var mutatingCallMutex sync.Mutex
+
+type mutatingCallKey struct{}
+
+// RegisterMutationHook adds hook to the hooks run around mutating tools.
+func RegisterMutationHook(hook MutationHook) {
+	mutationHooksMutex.Lock()
+	mutationHooks = append(mutationHooks, hook)
+	mutationHooksMutex.Unlock()
+}
+
+// MarkToolMutating flags toolName as changing the system through
+// executable, so the MutationHooks are run around it.
+func MarkToolMutating(toolName string, executable string) {
+	mutationHooksMutex.Lock()
+	mutatingTools[toolName] = executable
+	mutationHooksMutex.Unlock()
+}
+
+// SessionIDFromContext returns the MCP session of a tool call, "stdio"
+// if the transport has no sessions.
+func SessionIDFromContext(ctx context.Context) string {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil || session.SessionID() == "" {
+		return "stdio"
+	}
+	return session.SessionID()
+}
+
+// SendProgress sends a progress notification for req, if the client asked
+// for them with a progress token. total is 0 if unknown.
+func SendProgress(ctx context.Context, req mcp.CallToolRequest, progress float64, total float64, message string) {
+	mcpServer := server.ServerFromContext(ctx)
+	if mcpServer == nil || req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil {
+		return
+	}
+	params := map[string]any{"progressToken": req.Params.Meta.ProgressToken, "progress": progress, "message": message}
+	if total > 0 {
+		params["total"] = total
+	}
+	mcpServer.SendNotificationToClient(ctx, "notifications/progress", params)
+}
+
+// MutatingCallFromContext returns the call of a mutating tool inside its
+// handler, with the State the hooks left in Before, or nil.
+func MutatingCallFromContext(ctx context.Context) *MutatingToolCall {
+	call, _ := ctx.Value(mutatingCallKey{}).(*MutatingToolCall)
+	return call
+}
+
+// MarkCallUnchanged records that the mutating tool call of ctx changed
+// nothing, e.g. because a check failed, so the hooks can drop what they
+// prepared in Before instead of recording an empty change.
+func MarkCallUnchanged(ctx context.Context) {
+	if call := MutatingCallFromContext(ctx); call != nil {
+		call.Unchanged = true
+	}
+}
+
+func newCallID() string {
+	return fmt.Sprintf("%d-%d-%d", os.Getpid(), time.Now().Unix(), callCounter.Add(1))
+}
+
+func hookNote(hook MutationHook, phase string, note string, err error) string {
+	if err != nil {
+		sysLog, syslogerr := syslog.New(syslog.LOG_WARNING, "MutationHook")
+		if syslogerr == nil {
+			sysLog.Warning(fmt.Sprintf("%s %s: %v", hook.Name(), phase, err))
+			sysLog.Close()
+		}
+		jsonData, _ := json.Marshal(map[string]string{"hook": hook.Name(), "phase": phase, "error": err.Error()})
+		return string(jsonData)
+	}
+	return note
+}
+
+// mutationMiddleware runs the MutationHooks around all tools marked with
+// MarkToolMutating.
+func mutationMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		mutationHooksMutex.RLock()
+		executable, isMutating := mutatingTools[req.Params.Name]
+		hooks := append([]MutationHook{}, mutationHooks...)
+		mutationHooksMutex.RUnlock()
+		if !isMutating || len(hooks) == 0 {
+			return next(ctx, req)
+		}
+		call := &MutatingToolCall{
+			Tool:       req.Params.Name,
+			Executable: executable,
+			Arguments:  req.GetArguments(),
+			SessionID:  SessionIDFromContext(ctx),
+			CallID:     newCallID(),
+			State:      make(map[string]any),
+		}
+		mutatingCallMutex.Lock()
+		defer mutatingCallMutex.Unlock()
+		var notes []string
+		for _, hook := range hooks {
+			note, err := hook.Before(ctx, call)
+			if note = hookNote(hook, "before", note, err); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		result, err := next(context.WithValue(ctx, mutatingCallKey{}, call), req)
+		// the hooks run in reverse order after the call, like defer
+		for i := len(hooks) - 1; i >= 0; i-- {
+			note, hookErr := hooks[i].After(ctx, call, result)
+			if note = hookNote(hooks[i], "after", note, hookErr); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		if result != nil {
+			for _, note := range notes {
+				result.Content = append(result.Content, mcp.NewTextContent(note))
+			}
+		}
+		return result, err
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type recordingHook struct {
+	unchanged *[]bool
+}
+
+func (hook recordingHook) Name() string {
+	return "recording"
+}
+
+func (hook recordingHook) Before(ctx context.Context, call *MutatingToolCall) (string, error) {
+	return "", nil
+}
+
+func (hook recordingHook) After(ctx context.Context, call *MutatingToolCall, result *mcp.CallToolResult) (string, error) {
+	*hook.unchanged = append(*hook.unchanged, call.Unchanged)
+	return "", nil
+}
+
+// activeHook counts the calls between its Before and After.
+type activeHook struct {
+	active  *atomic.Int32
+	overlap *atomic.Bool
+}
+
+func (hook activeHook) Name() string {
+	return "active"
+}
+
+func (hook activeHook) Before(ctx context.Context, call *MutatingToolCall) (string, error) {
+	if hook.active.Add(1) > 1 {
+		hook.overlap.Store(true)
+	}
+	return "", nil
+}
+
+func (hook activeHook) After(ctx context.Context, call *MutatingToolCall, result *mcp.CallToolResult) (string, error) {
+	hook.active.Add(-1)
+	return "", nil
+}
+
+func TestMutatingCallsSerialized(t *testing.T) {
+	mutationHooksMutex.Lock()
+	hooks := mutationHooks
+	mutationHooksMutex.Unlock()
+	defer func() {
+		mutationHooksMutex.Lock()
+		mutationHooks = hooks
+		delete(mutatingTools, "test_tool")
+		mutationHooksMutex.Unlock()
+	}()
+	var active atomic.Int32
+	var overlap atomic.Bool
+	mutationHooks = nil
+	RegisterMutationHook(activeHook{active: &active, overlap: &overlap})
+	MarkToolMutating("test_tool", "zypper")
+
+	handler := mutationMiddleware(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("done"), nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := mcp.CallToolRequest{}
+			req.Params.Name = "test_tool"
+			handler(context.Background(), req)
+		}()
+	}
+	wg.Wait()
+	if overlap.Load() {
+		t.Error("hooks of two mutating calls overlapped")
+	}
+}
+
+func TestMarkCallUnchanged(t *testing.T) {
+	mutationHooksMutex.Lock()
+	hooks := mutationHooks
+	mutationHooksMutex.Unlock()
+	defer func() {
+		mutationHooksMutex.Lock()
+		mutationHooks = hooks
+		delete(mutatingTools, "test_tool")
+		mutationHooksMutex.Unlock()
+	}()
+	var unchanged []bool
+	mutationHooks = nil
+	RegisterMutationHook(recordingHook{unchanged: &unchanged})
+	MarkToolMutating("test_tool", "zypper")
+
+	handler := mutationMiddleware(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if change, _ := req.GetArguments()["change"].(bool); !change {
+			MarkCallUnchanged(ctx)
+		}
+		return mcp.NewToolResultText("done"), nil
+	})
+	for _, change := range []bool{true, false} {
+		req := mcp.CallToolRequest{}
+		req.Params.Name = "test_tool"
+		req.Params.Arguments = map[string]any{"change": change}
+		if _, err := handler(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(unchanged) != 2 || unchanged[0] || !unchanged[1] {
+		t.Errorf("unchanged in After: %v", unchanged)
+	}
+	// outside of a mutating call it does nothing
+	MarkCallUnchanged(context.Background())
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// os-release(5), /usr/lib/os-release is the fallback
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+const OSReleaseResourceURI = "admintasks://system/os-release"
+
+// ST_RDONLY from statfs(2)
+const stRdOnly = 0x0001
+
+// IDs of distributions with a read-only root file system, which are
+// updated with transactional-update only.
+var immutableDistributionIDs = []string{"sle-micro", "sl-micro", "opensuse-microos", "opensuse-leap-micro"}
+
+type OSRelease struct {
+	ID         string   `json:"id"`
+	IDLike     []string `json:"id_like"`
+	Name       string   `json:"name"`
+	PrettyName string   `json:"pretty_name"`
+	Version    string   `json:"version"`
+	VersionID  string   `json:"version_id"`
+	Variant    string   `json:"variant"`
+	VariantID  string   `json:"variant_id"`
+	CPEName    string   `json:"cpe_name"`
+	Immutable  bool     `json:"immutable"`
+	// not part of os-release, but decides how the system is updated
+	ReadOnlyRoot bool `json:"read_only_root"`
+}
+
+var DetectedOSRelease OSRelease
+
+func parseOSRelease(content string) OSRelease {
+	var osRelease OSRelease
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, "'\"")
+		}
+		switch key {
+		case "ID":
+			osRelease.ID = value
+		case "ID_LIKE":
+			osRelease.IDLike = strings.Fields(value)
+		case "NAME":
+			osRelease.Name = value
+		case "PRETTY_NAME":
+			osRelease.PrettyName = value
+		case "VERSION":
+			osRelease.Version = value
+		case "VERSION_ID":
+			osRelease.VersionID = value
+		case "VARIANT":
+			osRelease.Variant = value
+		case "VARIANT_ID":
+			osRelease.VariantID = value
+		case "CPE_NAME":
+			osRelease.CPEName = value
+		}
+	}
+	for _, id := range immutableDistributionIDs {
+		if osRelease.ID == id || osRelease.VariantID == id {
+			osRelease.Immutable = true
+		}
+	}
+	return osRelease
+}
+
+// isRootReadOnly checks the mount flags of /, which is read-only on
+// SLE Micro and openSUSE MicroOS.
+func isRootReadOnly() bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs("/", &stat); err != nil {
+		return false
+	}
+	return stat.Flags&stRdOnly != 0
+}
+
+// ReadOSRelease reads os-release again, e.g. after a distribution upgrade.
+func ReadOSRelease() (OSRelease, error) {
+	for _, path := range osReleasePaths {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			osRelease := parseOSRelease(string(content))
+			osRelease.ReadOnlyRoot = isRootReadOnly()
+			return osRelease, nil
+		}
+	}
+	return OSRelease{}, fmt.Errorf("no os-release file found in %s", strings.Join(osReleasePaths, ", "))
+}
+
+// MatchesDistribution reports whether the detected distribution is one
+// of distributions, compared against ID and ID_LIKE. An empty list
+// matches every distribution.
+func MatchesDistribution(distributions []string) bool {
+	if len(distributions) == 0 {
+		return true
+	}
+	for _, distribution := range distributions {
+		if distribution == DetectedOSRelease.ID {
+			return true
+		}
+		for _, like := range DetectedOSRelease.IDLike {
+			if distribution == like {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func addOSReleaseToolAndResource() {
+	mcpToolOSRelease := mcp.NewTool("os_release",
+		mcp.WithDescription("Show the detected Linux distribution (ID, version, variant) and whether it is an immutable system like SLE Micro or openSUSE MicroOS."),
+	)
+	AdminTasksMCPServer.AddTool(mcpToolOSRelease, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		jsonData, err := json.MarshalIndent(DetectedOSRelease, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(jsonData)), nil
+	})
+	RecordRegisteredTool("os_release")
+
+	osReleaseResource := mcp.NewResource(OSReleaseResourceURI, "os-release",
+		mcp.WithResourceDescription("Detected Linux distribution of the managed host"),
+		mcp.WithMIMEType("application/json"),
+	)
+	AddResource(osReleaseResource, func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		jsonData, err := json.MarshalIndent(DetectedOSRelease, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{URI: OSReleaseResourceURI, MIMEType: "application/json", Text: string(jsonData)},
+		}, nil
+	})
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isASCIIAlnum(c byte) bool {
+	return isASCIIDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// RpmVerCmp compares two version or release strings like rpmvercmp() of
+// librpm and returns -1, 0 or 1. "~" sorts before everything, even the
+// end of the string (1.0~rc1 < 1.0), "^" sorts after the end of the
+// string, but before everything else (1.0 < 1.0^git1 < 1.0.1).
+func RpmVerCmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+	one, two := a, b
+	for len(one) > 0 || len(two) > 0 {
+		for len(one) > 0 && !isASCIIAlnum(one[0]) && one[0] != '~' && one[0] != '^' {
+			one = one[1:]
+		}
+		for len(two) > 0 && !isASCIIAlnum(two[0]) && two[0] != '~' && two[0] != '^' {
+			two = two[1:]
+		}
+
+		if (len(one) > 0 && one[0] == '~') || (len(two) > 0 && two[0] == '~') {
+			if len(one) == 0 || one[0] != '~' {
+				return 1
+			}
+			if len(two) == 0 || two[0] != '~' {
+				return -1
+			}
+			one, two = one[1:], two[1:]
+			continue
+		}
+
+		if (len(one) > 0 && one[0] == '^') || (len(two) > 0 && two[0] == '^') {
+			if len(one) == 0 {
+				return -1
+			}
+			if len(two) == 0 {
+				return 1
+			}
+			if one[0] != '^' {
+				return 1
+			}
+			if two[0] != '^' {
+				return -1
+			}
+			one, two = one[1:], two[1:]
+			continue
+		}
+
+		if len(one) == 0 || len(two) == 0 {
+			break
+		}
+
+		// compare the next segment of digits or of letters
+		isNum := isASCIIDigit(one[0])
+		segmentEnd := func(s string) int {
+			i := 0
+			for i < len(s) && ((isNum && isASCIIDigit(s[i])) || (!isNum && isASCIIAlnum(s[i]) && !isASCIIDigit(s[i]))) {
+				i++
+			}
+			return i
+		}
+		endOne, endTwo := segmentEnd(one), segmentEnd(two)
+		segmentOne, segmentTwo := one[:endOne], two[:endTwo]
+		one, two = one[endOne:], two[endTwo:]
+
+		// segments of different types, numbers are newer
+		if len(segmentTwo) == 0 {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if isNum {
+			segmentOne = strings.TrimLeft(segmentOne, "0")
+			segmentTwo = strings.TrimLeft(segmentTwo, "0")
+			if len(segmentOne) > len(segmentTwo) {
+				return 1
+			}
+			if len(segmentTwo) > len(segmentOne) {
+				return -1
+			}
+		}
+		if result := strings.Compare(segmentOne, segmentTwo); result != 0 {
+			return result
+		}
+	}
+	if len(one) == 0 && len(two) == 0 {
+		return 0
+	}
+	if len(one) == 0 {
+		return -1
+	}
+	return 1
+}
+
+// EVR is an [epoch:]version[-release] string split into its parts.
+type EVR struct {
+	Epoch   int    `json:"epoch"`
+	Version string `json:"version"`
+	Release string `json:"release,omitempty"`
+}
+
+// ParseEVR splits [epoch:]version[-release], the release is everything
+// after the last "-". A missing epoch is 0.
+func ParseEVR(evr string) EVR {
+	var parsed EVR
+	evr = strings.TrimSpace(evr)
+	if epoch, rest, found := strings.Cut(evr, ":"); found {
+		if number, err := strconv.Atoi(epoch); err == nil {
+			parsed.Epoch = number
+			evr = rest
+		}
+	}
+	if index := strings.LastIndex(evr, "-"); index >= 0 {
+		parsed.Version = evr[:index]
+		parsed.Release = evr[index+1:]
+	} else {
+		parsed.Version = evr
+	}
+	return parsed
+}
+
+// CompareEVR compares two [epoch:]version[-release] strings like rpm
+// and returns -1, 0 or 1. The release is only compared if both have one,
+// so 1.0 matches every release of 1.0.
+func CompareEVR(a string, b string) int {
+	evrA, evrB := ParseEVR(a), ParseEVR(b)
+	if evrA.Epoch != evrB.Epoch {
+		if evrA.Epoch < evrB.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if result := RpmVerCmp(evrA.Version, evrB.Version); result != 0 {
+		return result
+	}
+	if evrA.Release == "" || evrB.Release == "" {
+		return 0
+	}
+	return RpmVerCmp(evrA.Release, evrB.Release)
+}
+
+func addVersionCompareTool() {
+	mcpToolVersionCompare := mcp.NewTool("rpm_version_compare",
+		mcp.WithDescription("Compare two package versions with the rules of rpm, including epoch, \"~\" (pre-releases, 1.0~rc1 < 1.0) and \"^\" (snapshots, 1.0 < 1.0^git1 < 1.0.1). Use it to check whether an installed version is at least the fixed version of an advisory."),
+		mcp.WithString("version_a", mcp.Required(), mcp.Description("First version as [epoch:]version[-release], e.g. 3.0.8-150500.5.8.1")),
+		mcp.WithString("version_b", mcp.Required(), mcp.Description("Second version as [epoch:]version[-release]")),
+	)
+	AdminTasksMCPServer.AddTool(mcpToolVersionCompare, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		versionA, _ := req.GetArguments()["version_a"].(string)
+		versionB, _ := req.GetArguments()["version_b"].(string)
+		if versionA == "" || versionB == "" {
+			return mcp.NewToolResultError("version_a and version_b are required"), nil
+		}
+		result := CompareEVR(versionA, versionB)
+		relation := map[int]string{-1: "older", 0: "equal", 1: "newer"}[result]
+		jsonData, err := json.MarshalIndent(map[string]any{
+			"version_a": ParseEVR(versionA),
+			"version_b": ParseEVR(versionB),
+			"result":    result,
+			// version_a is older, equal or newer than version_b
+			"relation": relation,
+		}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(jsonData)), nil
+	})
+	RecordRegisteredTool("rpm_version_compare")
+}
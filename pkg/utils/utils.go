@@ -15,6 +15,9 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 )
 
+const ServerName = "mcp_server_admintasks"
+const ServerVersion = "0.0.2"
+
 var utilsDebug = false
 var AdminTasksMCPServer *server.MCPServer
 
@@ -40,7 +43,9 @@ type SingleSubCmd struct {
 	Description    string   `json:"description"`
 	IsEnabled      bool     `json:"is_enabled"`
 	IsRootRequired bool     `json:"is_root_required"`
+	IsMutating     bool     `json:"is_mutating,omitempty"`
 	Parameters     []string `json:"parameters"`
+	MinVersion     string   `json:"min_version,omitempty"`
 }
 
 type SystemCmd struct {
@@ -48,6 +53,9 @@ type SystemCmd struct {
 	Description       string                  `json:"description"`
 	NeedsRootHandling bool                    `json:"needs_root_handling"`
 	DefaultParameters []string                `json:"default_parameters"`
+	VersionParameters []string                `json:"version_parameters,omitempty"`
+	Distributions     []string                `json:"distributions,omitempty"`
+	Environment       []string                `json:"environment,omitempty"`
 	SubCommands       map[string]SingleSubCmd `json:"subcommands"`
 }
 
@@ -87,10 +95,15 @@ func readSystemCmdJSONIntoStruct(directoryPath string) (map[string]SystemCmd, er
 
 func startMCPServer() {
 	AdminTasksMCPServer = server.NewMCPServer(
-		"mcp_server_admintasks",
-		"0.0.2",
+		ServerName,
+		ServerVersion,
 		server.WithToolCapabilities(false),
+		server.WithToolHandlerMiddleware(redactionMiddleware),
+		server.WithToolHandlerMiddleware(mutationMiddleware),
 	)
+	addServerInfoTool()
+	addOSReleaseToolAndResource()
+	addVersionCompareTool()
 }
 
 func ExecuteSystemCall(systemCmd SystemCmd, fullHelpText string, isRootRequired bool, subcmd string, subcmd_params ...string) string {
@@ -122,12 +135,17 @@ func ExecuteSystemCall(systemCmd SystemCmd, fullHelpText string, isRootRequired
 			strArgs = append(strArgs, fmt.Sprint(arg))
 		}
 		var cmd *exec.Cmd
+		var cmderr error
 		if isRootRequired {
-			sudoArgsA := append([]string{systemCmd.Executable}, strArgs...)
-			sudoArgsB := append([]string{"-b"}, sudoArgsA...)
-			cmd = exec.Command("sudo", sudoArgsB...)
+			cmd, cmderr = newSudoCommand(context.Background(), []string{"-b"}, systemCmd.Environment, systemCmd.Executable, strArgs...)
 		} else {
-			cmd = exec.Command(systemCmd.Executable, strArgs...)
+			cmd, cmderr = NewCommand(systemCmd.Executable, strArgs...)
+			if cmderr == nil {
+				cmd.Env = append(cmd.Env, systemCmd.Environment...)
+			}
+		}
+		if cmderr != nil {
+			return fmt.Sprintf("{\"error\": %q}", cmderr.Error())
 		}
 		// Buffer to capture the output
 		var out bytes.Buffer
@@ -151,11 +169,27 @@ func ExecuteSystemCall(systemCmd SystemCmd, fullHelpText string, isRootRequired
 	}
 }
 
+// JSONToolResult returns value as indented JSON text. "<", ">" and "&"
+// are kept readable, json.Marshal would escape them.
+func JSONToolResult(value any) (*mcp.CallToolResult, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(buffer.String()), nil
+}
+
 func AddToolToMCPServer(systemCmd SystemCmd, fullHelpText string, cmdName string, newCmd SingleSubCmd) {
 
 	if newCmd.IsEnabled {
 
 		newCmdName := systemCmd.Executable + "_" + cmdName
+		if !IsSubCmdAvailable(systemCmd, newCmdName, newCmd) {
+			return
+		}
 
 		sysLog, syslogerr := syslog.New(syslog.LOG_INFO, newCmdName)
 		defer sysLog.Close()
@@ -188,6 +222,10 @@ func AddToolToMCPServer(systemCmd SystemCmd, fullHelpText string, cmdName string
 			}
 			return mcp.NewToolResultText(fmt.Sprintf("%s", ExecuteSystemCall(systemCmd, fullHelpText, newCmd.IsRootRequired, cmdName, strList...))), nil
 		})
+		RecordRegisteredTool(newCmdName)
+		if newCmd.IsMutating {
+			MarkToolMutating(newCmdName, systemCmd.Executable)
+		}
 
 	}
 
@@ -197,17 +235,33 @@ func RUN() {
 	// readSystemCmdJSONIntoStruct("/usr/share/mcp-server-admintasks/")
 	readSystemCmdJSONIntoStruct("/tmp/json/")
 	if err := server.ServeStdio(AdminTasksMCPServer); err != nil {
-		fmt.Printf("Server error: %v\n")
+		fmt.Printf("Server error: %v\n", err)
 	}
 }
 
 func INIT(mode RunningMode) {
+	newConfig, err := readServerConfig(ServerConfigPath)
+	if err != nil {
+		log.Fatalf("Failed to read server config: %v", err)
+	}
+	AdminTasksConfig = newConfig
+	setupChildProcessDefaults()
+	DetectedOSRelease, err = ReadOSRelease()
+	if err != nil {
+		log.Printf("Failed to detect distribution: %v", err)
+	}
+	adminRedactionDetectors, err = compileRedactionPatterns(AdminTasksConfig.RedactPatterns)
+	if err != nil {
+		log.Fatalf("Failed to read server config: %v", err)
+	}
 	switch mode {
 	case Production:
 		utilsDebug = false
+		ResolveSystemCmd(SystemCmd{Executable: "sudo"})
 		startMCPServer()
 	case Debug:
 		utilsDebug = true
+		ResolveSystemCmd(SystemCmd{Executable: "sudo"})
 		startMCPServer()
 	case Test:
 		utilsDebug = true
@@ -0,0 +1,294 @@
+package zypper
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/utils"
+)
+
+// zypper exit codes, see zypper(8)
+const (
+	zypperExitOK             = 0
+	zypperExitInfUpdateNeed  = 100
+	zypperExitInfSecUpdate   = 101
+	zypperExitInfRebootNeed  = 102
+	zypperExitInfRestartNeed = 103
+	zypperExitInfCapNotFound = 104
+	zypperExitInfReposSkip   = 106
+)
+
+type zypperSolvable struct {
+	Status     string `xml:"status,attr"`
+	Name       string `xml:"name,attr"`
+	Summary    string `xml:"summary,attr"`
+	Kind       string `xml:"kind,attr"`
+	Edition    string `xml:"edition,attr"`
+	EditionOld string `xml:"edition-old,attr"`
+	Arch       string `xml:"arch,attr"`
+	Repository string `xml:"repository,attr"`
+}
+
+type zypperSearchResult struct {
+	Solvables []zypperSolvable `xml:"search-result>solvable-list>solvable"`
+}
+
+type zypperUpdate struct {
+	zypperSolvable
+	Source struct {
+		URL   string `xml:"url,attr"`
+		Alias string `xml:"alias,attr"`
+	} `xml:"source"`
+}
+
+type zypperUpdateStatus struct {
+	Updates []zypperUpdate `xml:"update-status>update-list>update"`
+}
+
+type zypperRepo struct {
+	Alias       string `xml:"alias,attr"`
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	Priority    int    `xml:"priority,attr"`
+	Enabled     string `xml:"enabled,attr"`
+	Autorefresh string `xml:"autorefresh,attr"`
+	GPGCheck    string `xml:"gpgcheck,attr"`
+	// repo_gpgcheck and pkg_gpgcheck are empty with older zypper
+	RepoGPGCheck string   `xml:"repo_gpgcheck,attr"`
+	PkgGPGCheck  string   `xml:"pkg_gpgcheck,attr"`
+	URL          string   `xml:"url"`
+	GPGKeys      []string `xml:"gpgkey"`
+}
+
+type zypperRepoList struct {
+	Repos []zypperRepo `xml:"repo-list>repo"`
+}
+
+type zypperLock struct {
+	Number     int    `xml:"number,attr"`
+	Name       string `xml:"name"`
+	Kind       string `xml:"kind"`
+	Type       string `xml:"type"`
+	Repository string `xml:"repo"`
+}
+
+type zypperLocks struct {
+	Locks []zypperLock `xml:"locks>lock"`
+}
+
+type zypperMessage struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type zypperMessages struct {
+	Messages []zypperMessage `xml:"message"`
+}
+
+// zypperBackend implements pkgmgr.Backend with the --xmlout output of
+// zypperCmd.
+type zypperBackend struct{}
+
+func (backend zypperBackend) Name() string {
+	return "zypper"
+}
+
+// NewBackend returns the zypper backend, for backends which use zypper
+// for querying, like transactional-update.
+func NewBackend() pkgmgr.Backend {
+	return zypperBackend{}
+}
+
+func (backend zypperBackend) SystemCmd() utils.SystemCmd {
+	return zypperCmd
+}
+
+// IsApplicable is false with a read-only root, zypper cannot install
+// packages there, transactional-update has to be used instead.
+func (backend zypperBackend) IsApplicable() bool {
+	return !utils.DetectedOSRelease.ReadOnlyRoot
+}
+
+// decodeZypperXML decodes the <stream> element of zypper --xmlout.
+func decodeZypperXML(output string, result any) error {
+	start := strings.Index(output, "<stream>")
+	if start < 0 {
+		return fmt.Errorf("no XML output from zypper: %s", strings.TrimSpace(output))
+	}
+	return xml.Unmarshal([]byte(output[start:]), result)
+}
+
+// zypperMessagesText returns all messages of zypper --xmlout as text,
+// commands like info do not have a structured XML output.
+func zypperMessagesText(output string, types ...string) string {
+	var messages zypperMessages
+	if decodeZypperXML(output, &messages) != nil {
+		return output
+	}
+	var texts []string
+	for _, message := range messages.Messages {
+		if len(types) == 0 {
+			texts = append(texts, message.Text)
+			continue
+		}
+		for _, messageType := range types {
+			if message.Type == messageType {
+				texts = append(texts, message.Text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func zypperBool(value string) bool {
+	return value == "1" || value == "true" || value == "yes"
+}
+
+func (backend zypperBackend) run(ctx context.Context, isRootRequired bool, subcmd string, subcmdParams ...string) (utils.CmdResult, error) {
+	return utils.RunSystemCmd(ctx, zypperCmd, isRootRequired, subcmd, subcmdParams...)
+}
+
+func (backend zypperBackend) Search(ctx context.Context, pattern string) ([]pkgmgr.Package, error) {
+	result, err := backend.run(ctx, false, "search", "--details", "--type", "package", pattern)
+	if err != nil {
+		return nil, err
+	}
+	var packages []pkgmgr.Package
+	if result.ExitCode == zypperExitInfCapNotFound {
+		return packages, nil
+	}
+	var searchResult zypperSearchResult
+	if err := decodeZypperXML(result.Stdout, &searchResult); err != nil {
+		return nil, err
+	}
+	for _, solvable := range searchResult.Solvables {
+		packages = append(packages, pkgmgr.Package{
+			Name:       solvable.Name,
+			Version:    solvable.Edition,
+			Arch:       solvable.Arch,
+			Repository: solvable.Repository,
+			Summary:    solvable.Summary,
+			Installed:  strings.HasPrefix(solvable.Status, "installed") || solvable.Status == "other-version",
+		})
+	}
+	return packages, nil
+}
+
+func (backend zypperBackend) Info(ctx context.Context, name string) (pkgmgr.PackageInfo, error) {
+	result, err := backend.run(ctx, false, "info", name)
+	if err != nil {
+		return pkgmgr.PackageInfo{}, err
+	}
+	details := pkgmgr.ParseKeyValueLines(zypperMessagesText(result.Stdout, "info"))
+	if len(details) == 0 || details["name"] == "" {
+		return pkgmgr.PackageInfo{}, fmt.Errorf("package %s not found", name)
+	}
+	return pkgmgr.NewPackageInfo(details), nil
+}
+
+func (backend zypperBackend) transaction(ctx context.Context, action string, subcmd string, packages []string) (pkgmgr.TransactionResult, error) {
+	result, err := backend.run(ctx, true, subcmd, packages...)
+	if err != nil {
+		return pkgmgr.TransactionResult{}, err
+	}
+	transactionResult := pkgmgr.TransactionResult{
+		Backend:      backend.Name(),
+		Action:       action,
+		Packages:     packages,
+		ExitCode:     result.ExitCode,
+		RebootNeeded: result.ExitCode == zypperExitInfRebootNeed,
+		Output:       zypperMessagesText(result.Stdout),
+	}
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfRebootNeed, zypperExitInfRestartNeed, zypperExitInfReposSkip:
+		transactionResult.Success = true
+	default:
+		transactionResult.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+	}
+	return transactionResult, nil
+}
+
+func (backend zypperBackend) Install(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "install", "install", packages)
+}
+
+func (backend zypperBackend) Remove(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "remove", "remove", packages)
+}
+
+func (backend zypperBackend) Update(ctx context.Context, packages []string) (pkgmgr.TransactionResult, error) {
+	return backend.transaction(ctx, "update", "update", packages)
+}
+
+func (backend zypperBackend) ListUpdates(ctx context.Context) ([]pkgmgr.Update, error) {
+	result, err := backend.run(ctx, false, "list-updates")
+	if err != nil {
+		return nil, err
+	}
+	var updateStatus zypperUpdateStatus
+	if err := decodeZypperXML(result.Stdout, &updateStatus); err != nil {
+		return nil, err
+	}
+	var updates []pkgmgr.Update
+	for _, update := range updateStatus.Updates {
+		updates = append(updates, pkgmgr.Update{
+			Name:       update.Name,
+			Arch:       update.Arch,
+			OldVersion: update.EditionOld,
+			NewVersion: update.Edition,
+			Repository: update.Source.Alias,
+		})
+	}
+	return updates, nil
+}
+
+func (backend zypperBackend) ListRepos(ctx context.Context) ([]pkgmgr.Repository, error) {
+	result, err := backend.run(ctx, false, "repos")
+	if err != nil {
+		return nil, err
+	}
+	var repoList zypperRepoList
+	if err := decodeZypperXML(result.Stdout, &repoList); err != nil {
+		return nil, err
+	}
+	var repos []pkgmgr.Repository
+	for _, repo := range repoList.Repos {
+		repos = append(repos, pkgmgr.Repository{
+			Alias:       repo.Alias,
+			Name:        repo.Name,
+			URL:         strings.TrimSpace(repo.URL),
+			Enabled:     zypperBool(repo.Enabled),
+			Autorefresh: zypperBool(repo.Autorefresh),
+			GPGCheck:    zypperBool(repo.GPGCheck),
+			Priority:    repo.Priority,
+		})
+	}
+	return repos, nil
+}
+
+func (backend zypperBackend) ListLocks(ctx context.Context) ([]pkgmgr.Lock, error) {
+	result, err := backend.run(ctx, false, "locks")
+	if err != nil {
+		return nil, err
+	}
+	var zypperLockList zypperLocks
+	if err := decodeZypperXML(result.Stdout, &zypperLockList); err != nil {
+		return nil, err
+	}
+	var locks []pkgmgr.Lock
+	for _, lock := range zypperLockList.Locks {
+		lockType := lock.Kind
+		if lockType == "" {
+			lockType = lock.Type
+		}
+		locks = append(locks, pkgmgr.Lock{
+			Name:       strings.TrimSpace(lock.Name),
+			Type:       lockType,
+			Repository: lock.Repository,
+		})
+	}
+	return locks, nil
+}
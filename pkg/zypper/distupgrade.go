@@ -0,0 +1,462 @@
+package zypper
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/snapper"
+	"mcp-server-admintasks/pkg/systemctl"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Results of the pre-flight checks, errors block the upgrade
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkError   = "error"
+)
+
+// free space needed besides the installed size delta of the plan
+const (
+	rootSpaceMargin  = 1 << 30
+	bootSpaceMinimum = 100 << 20
+)
+
+// number of progress messages kept for the report
+const progressTailLength = 20
+
+// Hosts of the distribution repositories, other hosts and the OBS
+// projects under /repositories are third-party repositories.
+var distributionHosts = []string{"*.opensuse.org", "opensuse.org", "*.suse.com", "suse.com"}
+
+// 15.6, 15-SP6, SLE_15_SP6 and openSUSE_Leap_15.6 in repository URLs
+var releaseVersionPattern = regexp.MustCompile(`(?i)(?:^|[^0-9.])(1[0-9])(?:\.|[-_]SP)([0-9])(?:[^0-9]|$)`)
+
+var progressNamePattern = regexp.MustCompile(`<progress [^>]*name="([^"]*)"`)
+var progressStepPattern = regexp.MustCompile(`^\((\d+)/(\d+)\) (.+)$`)
+
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type UpgradeVerification struct {
+	OSRelease      string   `json:"os_release"`
+	FailedUnits    []string `json:"failed_units"`
+	RebootRequired bool     `json:"reboot_required"`
+	RebootReasons  []string `json:"reboot_reasons,omitempty"`
+	RestartUnits   []string `json:"restart_units,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// DistUpgradeReport has the pre-flight checks and the dry-run plan of a
+// distribution upgrade, and after the upgrade its outcome.
+type DistUpgradeReport struct {
+	TargetVersion  string               `json:"target_version,omitempty"`
+	CurrentRelease string               `json:"current_release"`
+	Checks         []PreflightCheck     `json:"checks"`
+	Plan           TransactionPlan      `json:"plan"`
+	PlanID         string               `json:"plan_id"`
+	Ready          bool                 `json:"ready"`
+	Executed       bool                 `json:"executed"`
+	Snapshot       int                  `json:"snapshot,omitempty"`
+	ProgressSteps  int                  `json:"progress_steps,omitempty"`
+	Progress       []string             `json:"progress,omitempty"`
+	Success        bool                 `json:"success"`
+	ExitCode       int                  `json:"exit_code,omitempty"`
+	Error          string               `json:"error,omitempty"`
+	Verification   *UpgradeVerification `json:"verification,omitempty"`
+	Summary        string               `json:"summary"`
+}
+
+func (report *DistUpgradeReport) check(name string, status string, format string, args ...any) {
+	report.Checks = append(report.Checks, PreflightCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// releaseVersion returns a version like 15-SP6 as 15.6, or "".
+func releaseVersion(text string) string {
+	match := releaseVersionPattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return match[1] + "." + match[2]
+}
+
+// isDistributionRepository is true for repositories of SUSE and openSUSE
+// and for SUSE products mirrored by RMT or SMT.
+func isDistributionRepository(repo RepositoryInfo) bool {
+	repoURL, err := url.Parse(repo.URL)
+	if err != nil || repoURL.Host == "" {
+		return false
+	}
+	if strings.Contains(repoURL.Path, "/SUSE/Products/") || strings.Contains(repoURL.Path, "/SUSE/Updates/") {
+		return true
+	}
+	if strings.HasPrefix(repoURL.Path, "/repositories/") {
+		return false
+	}
+	host := strings.ToLower(repoURL.Hostname())
+	for _, pattern := range distributionHosts {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (report *DistUpgradeReport) checkRepositories(ctx context.Context) {
+	repos, err := ListRepositories(ctx)
+	if err != nil {
+		report.check("repositories", checkError, "cannot list the repositories: %v", err)
+		return
+	}
+	target := releaseVersion(report.TargetVersion)
+	var enabled, mismatched, matching, thirdParty []string
+	for _, repo := range repos {
+		if !repo.Enabled {
+			continue
+		}
+		enabled = append(enabled, repo.Alias)
+		if target != "" {
+			// $releasever follows the release zypper is told to upgrade to
+			switch version := releaseVersion(repo.URL); {
+			case strings.Contains(repo.URL, "releasever"):
+				matching = append(matching, repo.Alias)
+			case version == "":
+			case version == target:
+				matching = append(matching, repo.Alias)
+			default:
+				mismatched = append(mismatched, fmt.Sprintf("%s (%s)", repo.Alias, version))
+			}
+		}
+		if !isDistributionRepository(repo) {
+			thirdParty = append(thirdParty, repo.Alias)
+		}
+	}
+	switch {
+	case len(enabled) == 0:
+		report.check("repositories", checkError, "no repository is enabled")
+	case target == "" && report.TargetVersion != "":
+		report.check("repositories", checkWarning, "the versions of the repositories cannot be compared with target %s", report.TargetVersion)
+	case target == "":
+		report.check("repositories", checkOK, "%d repositories enabled, no target version given", len(enabled))
+	case len(mismatched) > 0:
+		report.check("repositories", checkError, "repositories of another version are enabled: %s; switch them to %s first", strings.Join(mismatched, ", "), target)
+	case len(matching) == 0:
+		report.check("repositories", checkError, "no enabled repository is for %s", target)
+	default:
+		report.check("repositories", checkOK, "%d repositories for %s enabled", len(matching), target)
+	}
+	if len(thirdParty) > 0 {
+		report.check("third_party_repositories", checkWarning, "third-party repositories are enabled: %s; disable them unless they have packages for the target", strings.Join(thirdParty, ", "))
+	} else {
+		report.check("third_party_repositories", checkOK, "only distribution repositories are enabled")
+	}
+}
+
+func (report *DistUpgradeReport) checkPatches(ctx context.Context) {
+	patches, err := listPatches(ctx, PatchFilter{}, false)
+	if err != nil {
+		report.check("pending_patches", checkWarning, "cannot list the patches: %v", err)
+		return
+	}
+	var packageManager []string
+	for _, patch := range patches {
+		if patch.PackageManager {
+			packageManager = append(packageManager, patch.Name)
+		}
+	}
+	switch {
+	case len(packageManager) > 0:
+		report.check("pending_patches", checkError, "patches for the package manager are pending: %s; install them with zypper_install_patches first", strings.Join(packageManager, ", "))
+	case len(patches) > 0:
+		report.check("pending_patches", checkWarning, "%d patches are pending for the current release, installing them first is recommended", len(patches))
+	default:
+		report.check("pending_patches", checkOK, "no patches pending")
+	}
+}
+
+func (report *DistUpgradeReport) checkDiskSpace() {
+	needed := report.Plan.InstalledSizeDelta + report.Plan.DownloadSize
+	if needed < 0 {
+		needed = 0
+	}
+	if space, err := diskSpace("/"); err != nil {
+		report.check("disk_space_root", checkError, "%v", err)
+	} else if space.Free < needed+rootSpaceMargin {
+		report.check("disk_space_root", checkError, "%s free on /, the upgrade needs about %s", space.FreeFormatted, formatBytes(needed+rootSpaceMargin))
+	} else {
+		report.check("disk_space_root", checkOK, "%s free on /, the upgrade needs about %s", space.FreeFormatted, formatBytes(needed+rootSpaceMargin))
+	}
+	kernel := false
+	for _, name := range report.Plan.RebootPackages {
+		kernel = kernel || strings.HasPrefix(name, "kernel-")
+	}
+	if space, err := diskSpace("/boot"); err != nil {
+		report.check("disk_space_boot", checkError, "%v", err)
+	} else if kernel && space.Free < bootSpaceMinimum {
+		report.check("disk_space_boot", checkError, "%s free on /boot, a new kernel needs at least %s; remove old kernels with zypper_purge_kernels", space.FreeFormatted, formatBytes(bootSpaceMinimum))
+	} else {
+		report.check("disk_space_boot", checkOK, "%s free on /boot", space.FreeFormatted)
+	}
+}
+
+func (report *DistUpgradeReport) checkPlan() {
+	switch {
+	case len(report.Plan.Problems) > 0:
+		report.check("solver", checkError, "the solver reports %d problems, choose solutions with zypper_resolve_problems or fix the repositories", len(report.Plan.Problems))
+	case !report.Plan.Feasible:
+		report.check("solver", checkError, "the dry-run failed: %s", report.Plan.Error)
+	default:
+		report.check("solver", checkOK, "%s", report.Plan.Summary)
+	}
+	if len(report.Plan.Remove) > 0 || len(report.Plan.ChangeVendor) > 0 {
+		report.check("plan_changes", checkWarning, "%d packages are removed and %d change their vendor, check them in the plan", len(report.Plan.Remove), len(report.Plan.ChangeVendor))
+	}
+}
+
+// planID identifies the changes of a plan, the upgrade only runs if the
+// confirmed plan is still the one of the solver.
+func planID(plan TransactionPlan) string {
+	jsonData, _ := json.Marshal([][]PlanPackage{plan.Install, plan.Upgrade, plan.Downgrade, plan.Reinstall, plan.Remove, plan.ChangeArch, plan.ChangeVendor})
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PreflightDistUpgrade checks whether the system is ready for a
+// distribution upgrade and returns the plan of the solver.
+func PreflightDistUpgrade(ctx context.Context, targetVersion string) (DistUpgradeReport, error) {
+	report := DistUpgradeReport{TargetVersion: targetVersion, CurrentRelease: utils.DetectedOSRelease.PrettyName, Checks: []PreflightCheck{}}
+	if applicable, reason := snapper.IsApplicable(); applicable {
+		report.check("snapshot", checkOK, "a snapper snapshot is taken before the upgrade")
+	} else {
+		report.check("snapshot", checkError, "no snapshot can be taken before the upgrade: %s", reason)
+	}
+	report.checkRepositories(ctx)
+	report.checkPatches(ctx)
+	plan, err := PlanTransaction(ctx, "dist-upgrade", nil)
+	if err != nil {
+		return report, err
+	}
+	report.Plan = plan
+	report.PlanID = planID(plan)
+	report.checkPlan()
+	report.checkDiskSpace()
+	report.Ready = true
+	for _, check := range report.Checks {
+		report.Ready = report.Ready && check.Status != checkError
+	}
+	report.summarize()
+	return report, nil
+}
+
+func (report *DistUpgradeReport) summarize() {
+	counts := map[string]int{}
+	for _, check := range report.Checks {
+		counts[check.Status]++
+	}
+	summary := []string{fmt.Sprintf("pre-flight: %d ok, %d warnings, %d errors", counts[checkOK], counts[checkWarning], counts[checkError])}
+	summary = append(summary, "plan: "+report.Plan.Summary)
+	switch {
+	case report.Executed && report.Success:
+		summary = append(summary, "the distribution upgrade succeeded")
+	case report.Executed:
+		summary = append(summary, "the distribution upgrade failed: "+report.Error)
+	case report.Ready:
+		summary = append(summary, "ready, run zypper_dist_upgrade with plan_id "+report.PlanID)
+	default:
+		summary = append(summary, "not ready, fix the errors first")
+	}
+	if report.Verification != nil {
+		if len(report.Verification.FailedUnits) > 0 {
+			summary = append(summary, "failed units: "+strings.Join(report.Verification.FailedUnits, ", "))
+		}
+		if report.Verification.RebootRequired {
+			summary = append(summary, "a reboot is required")
+		}
+	}
+	report.Summary = strings.Join(summary, "; ")
+}
+
+// runWithProgress runs cmd and calls progress with every step of the
+// --xmlout output like "(12/345) Installing: ...".
+func runWithProgress(cmd *exec.Cmd, progress func(step int, total int, message string)) (utils.CmdResult, error) {
+	var result utils.CmdResult
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	var output strings.Builder
+	lastMessage := ""
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		output.WriteString(line + "\n")
+		match := progressNamePattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		message := html.UnescapeString(match[1])
+		step := progressStepPattern.FindStringSubmatch(message)
+		if step == nil || message == lastMessage {
+			continue
+		}
+		lastMessage = message
+		current, _ := strconv.Atoi(step[1])
+		total, _ := strconv.Atoi(step[2])
+		progress(current, total, message)
+	}
+	err = cmd.Wait()
+	result.Stdout = output.String()
+	result.Stderr = stderr.String()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return result, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, nil
+}
+
+func verifyUpgrade(ctx context.Context, exitCode int) *UpgradeVerification {
+	verification := &UpgradeVerification{FailedUnits: []string{}}
+	var errors []string
+	if osRelease, err := utils.ReadOSRelease(); err == nil {
+		verification.OSRelease = osRelease.PrettyName
+	} else {
+		errors = append(errors, err.Error())
+	}
+	if failed, err := systemctl.FailedUnits(ctx); err == nil {
+		verification.FailedUnits = failed
+	} else {
+		errors = append(errors, err.Error())
+	}
+	if status, err := ServicesNeedingRestart(ctx); err == nil {
+		verification.RebootReasons = status.RebootReasons
+		verification.RestartUnits = status.Units
+	} else {
+		errors = append(errors, err.Error())
+	}
+	if exitCode == zypperExitInfRebootNeed {
+		verification.RebootReasons = append(verification.RebootReasons, "zypper reports that a reboot is needed")
+	}
+	verification.RebootRequired = len(verification.RebootReasons) > 0
+	verification.Error = strings.Join(errors, "; ")
+	return verification
+}
+
+// DistUpgrade runs the pre-flight checks again and carries out the
+// upgrade, if they pass and the plan is still the confirmed one.
+func DistUpgrade(ctx context.Context, targetVersion string, confirmedPlanID string, progress func(step int, total int, message string)) (DistUpgradeReport, error) {
+	report, err := PreflightDistUpgrade(ctx, targetVersion)
+	if err != nil {
+		return report, err
+	}
+	if !report.Ready {
+		return report, nil
+	}
+	if confirmedPlanID != report.PlanID {
+		report.Error = fmt.Sprintf("the plan is not the confirmed one (plan_id %s, now %s), check the new plan and confirm it", confirmedPlanID, report.PlanID)
+		report.Summary += "; " + report.Error
+		return report, nil
+	}
+	snapshot, ok := snapper.PreSnapshot(ctx)
+	if !ok {
+		return report, fmt.Errorf("no snapshot was taken before the upgrade, not upgrading")
+	}
+	report.Snapshot = snapshot
+	subcmd, params, err := transactionParams("dist-upgrade", nil)
+	if err != nil {
+		return report, err
+	}
+	cmd, err := utils.NewSystemCmdContext(ctx, zypperCmd, true, subcmd, params...)
+	if err != nil {
+		return report, err
+	}
+	report.Executed = true
+	result, err := runWithProgress(cmd, func(step int, total int, message string) {
+		report.ProgressSteps++
+		report.Progress = append(report.Progress, message)
+		if len(report.Progress) > progressTailLength {
+			report.Progress = report.Progress[1:]
+		}
+		progress(step, total, message)
+	})
+	if err != nil {
+		return report, err
+	}
+	report.ExitCode = result.ExitCode
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfRebootNeed, zypperExitInfRestartNeed, zypperExitInfReposSkip:
+		report.Success = true
+	default:
+		report.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+	}
+	report.Verification = verifyUpgrade(ctx, result.ExitCode)
+	report.summarize()
+	return report, nil
+}
+
+func addDistUpgradeToolsToMCPServer() {
+	mcpToolPreflight := mcp.NewTool("zypper_dist_upgrade_preflight",
+		mcp.WithDescription("Check whether the system is ready for a distribution upgrade (zypper dist-upgrade): free space on / and /boot, repositories of the target version, pending patches, snapshots, third-party repositories. Returns the dry-run plan of the solver and its plan_id for zypper_dist_upgrade. Changes nothing."),
+		mcp.WithString("target_version", mcp.Description("Version the repositories must be for, e.g. 15.6 or 15-SP6; empty for rolling releases")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolPreflight, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetVersion, _ := req.GetArguments()["target_version"].(string)
+		report, err := PreflightDistUpgrade(ctx, strings.TrimSpace(targetVersion))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(report)
+	})
+	utils.RecordRegisteredTool("zypper_dist_upgrade_preflight")
+
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("zypper_dist_upgrade", zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		return
+	}
+	mcpToolUpgrade := mcp.NewTool("zypper_dist_upgrade",
+		mcp.WithDescription("Carry out a distribution upgrade planned with zypper_dist_upgrade_preflight. Show the plan to the user and pass its plan_id as confirmation; the checks and the dry-run are repeated, and the upgrade only runs without errors and with the same plan. Returns the progress and a verification with failed units and whether a reboot is required."),
+		mcp.WithString("target_version", mcp.Description("The same target version as for zypper_dist_upgrade_preflight")),
+		mcp.WithString("plan_id", mcp.Required(), mcp.Description("plan_id of the confirmed plan of zypper_dist_upgrade_preflight")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolUpgrade, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetVersion, _ := req.GetArguments()["target_version"].(string)
+		confirmedPlanID, _ := req.GetArguments()["plan_id"].(string)
+		report, err := DistUpgrade(ctx, strings.TrimSpace(targetVersion), strings.TrimSpace(confirmedPlanID), func(step int, total int, message string) {
+			utils.SendProgress(ctx, req, float64(step), float64(total), message)
+		})
+		if !report.Executed {
+			// not ready or not the confirmed plan, no snapshot pair for it
+			utils.MarkCallUnchanged(ctx)
+		}
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(report)
+	})
+	utils.RecordRegisteredTool("zypper_dist_upgrade")
+	utils.MarkToolMutating("zypper_dist_upgrade", zypperCmd.Executable)
+}
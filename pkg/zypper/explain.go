@@ -0,0 +1,374 @@
+package zypper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Names of the packages zypp installed as dependency, one per line
+const autoInstalledFile = "/var/lib/zypp/AutoInstalled"
+
+// maximal number of chains and their length in an explanation
+const (
+	maxChains     = 20
+	maxChainDepth = 12
+)
+
+// DependencyLink is an installed package which needs another one.
+type DependencyLink struct {
+	Package    string `json:"package"`
+	Kind       string `json:"kind"`
+	Dependency string `json:"dependency,omitempty"`
+}
+
+// DependencyChain leads from a package installed on purpose, a pattern or
+// a product to the explained package. Every link needs the next one, the
+// last link is the explained package itself.
+type DependencyChain struct {
+	Root     string           `json:"root"`
+	RootKind string           `json:"root_kind"`
+	Links    []DependencyLink `json:"links"`
+}
+
+type PackageExplanation struct {
+	Name          string            `json:"name"`
+	Installed     []string          `json:"installed"`
+	Kind          string            `json:"kind"`
+	AutoInstalled *bool             `json:"auto_installed"`
+	NeededBy      []DependencyLink  `json:"needed_by"`
+	Chains        []DependencyChain `json:"chains"`
+	Unneeded      bool              `json:"unneeded"`
+	RemovalPlan   *TransactionPlan  `json:"removal_plan,omitempty"`
+	SolverError   string            `json:"solver_error,omitempty"`
+	Summary       string            `json:"summary"`
+}
+
+type dependencyEdge struct {
+	from       int
+	kind       string
+	dependency string
+}
+
+// dependencyGraph has for every installed package the packages which
+// require or recommend it, or which it supplements. Capabilities are
+// matched by name, the versions are not compared.
+type dependencyGraph struct {
+	packages []rpmdb.PackageDependencies
+	neededBy map[int][]dependencyEdge
+}
+
+// readAutoInstalled returns nil if zypp does not keep the file.
+func readAutoInstalled() (map[string]bool, error) {
+	file, err := os.Open(autoInstalledFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names[line] = true
+		}
+	}
+	return names, scanner.Err()
+}
+
+func newDependencyGraph(packages []rpmdb.PackageDependencies) *dependencyGraph {
+	graph := &dependencyGraph{packages: packages, neededBy: make(map[int][]dependencyEdge)}
+	providers := make(map[string][]int)
+	for i, pkg := range packages {
+		providers[pkg.Package.Name] = append(providers[pkg.Package.Name], i)
+		for _, provide := range pkg.Provides {
+			if provide.Name != pkg.Package.Name {
+				providers[provide.Name] = append(providers[provide.Name], i)
+			}
+		}
+		for _, file := range pkg.Files {
+			providers[file] = append(providers[file], i)
+		}
+	}
+	seen := make(map[[2]int]bool)
+	addEdge := func(to int, from int, kind string, dependency rpmdb.Dependency) {
+		if to == from || seen[[2]int{to, from}] {
+			return
+		}
+		seen[[2]int{to, from}] = true
+		graph.neededBy[to] = append(graph.neededBy[to], dependencyEdge{from: from, kind: kind, dependency: dependency.String()})
+	}
+	for i, pkg := range packages {
+		for _, require := range pkg.Requires {
+			for _, capability := range require.Capabilities() {
+				for _, provider := range providers[capability] {
+					addEdge(provider, i, "requires", require)
+				}
+			}
+		}
+		for _, recommend := range pkg.Recommends {
+			for _, capability := range recommend.Capabilities() {
+				for _, provider := range providers[capability] {
+					addEdge(provider, i, "recommends", recommend)
+				}
+			}
+		}
+		// the supplemented package is the reason this one is installed
+		for _, supplement := range pkg.Supplements {
+			for _, capability := range supplement.Capabilities() {
+				for _, supplemented := range providers[capability] {
+					addEdge(i, supplemented, "supplemented by", supplement)
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// kind is pattern or product for the packages zypp creates for them,
+// otherwise package.
+func (graph *dependencyGraph) kind(index int) string {
+	for _, provide := range graph.packages[index].Provides {
+		switch provide.Name {
+		case "pattern()":
+			return "pattern"
+		case "product()":
+			return "product"
+		}
+	}
+	return "package"
+}
+
+func (graph *dependencyGraph) link(edge dependencyEdge) DependencyLink {
+	return DependencyLink{Package: graph.packages[edge.from].Package.NEVRA(), Kind: edge.kind, Dependency: edge.dependency}
+}
+
+// chains searches breadth first from the targets to the packages which
+// were not installed as dependency, and to patterns and products.
+func (graph *dependencyGraph) chains(targets []int, autoInstalled map[string]bool) []DependencyChain {
+	type step struct {
+		index  int
+		parent int
+		edge   dependencyEdge
+		depth  int
+		root   bool
+	}
+	steps := []step{}
+	visited := make(map[int]bool)
+	for _, target := range targets {
+		steps = append(steps, step{index: target, parent: -1})
+		visited[target] = true
+	}
+	chains := []DependencyChain{}
+	for current := 0; current < len(steps) && len(chains) < maxChains; current++ {
+		if steps[current].root {
+			continue
+		}
+		for _, edge := range graph.neededBy[steps[current].index] {
+			if visited[edge.from] || steps[current].depth >= maxChainDepth {
+				continue
+			}
+			visited[edge.from] = true
+			steps = append(steps, step{index: edge.from, parent: current, edge: edge, depth: steps[current].depth + 1})
+			rootKind := graph.kind(edge.from)
+			if rootKind == "package" {
+				if autoInstalled == nil || autoInstalled[graph.packages[edge.from].Package.Name] {
+					continue
+				}
+				rootKind = "installed on purpose"
+			}
+			steps[len(steps)-1].root = true
+			chain := DependencyChain{Root: graph.packages[edge.from].Package.NEVRA(), RootKind: rootKind}
+			for s := len(steps) - 1; steps[s].parent >= 0; s = steps[s].parent {
+				chain.Links = append(chain.Links, graph.link(steps[s].edge))
+			}
+			chain.Links = append(chain.Links, DependencyLink{Package: graph.packages[steps[0].index].Package.NEVRA(), Kind: "explained"})
+			chains = append(chains, chain)
+			if len(chains) >= maxChains {
+				break
+			}
+		}
+	}
+	return chains
+}
+
+// ExplainPackage reports why the installed package name is installed: the
+// packages requiring or recommending it, the packages it supplements, and
+// the chains of these back to packages installed on purpose, patterns and
+// products. withSolver adds what zypper would remove together with it.
+func ExplainPackage(ctx context.Context, name string, withSolver bool) (PackageExplanation, error) {
+	explanation := PackageExplanation{Name: name, Installed: []string{}, NeededBy: []DependencyLink{}}
+	db, err := rpmdb.Open()
+	if err != nil {
+		return explanation, err
+	}
+	packages, err := db.Dependencies()
+	if err != nil {
+		return explanation, err
+	}
+	autoInstalled, err := readAutoInstalled()
+	if err != nil {
+		return explanation, err
+	}
+	graph := newDependencyGraph(packages)
+	var targets []int
+	for i, pkg := range packages {
+		if pkg.Package.Name == name {
+			targets = append(targets, i)
+			explanation.Installed = append(explanation.Installed, pkg.Package.NEVRA())
+		}
+	}
+	if len(targets) == 0 {
+		return explanation, fmt.Errorf("package %s is not installed", name)
+	}
+	explanation.Kind = graph.kind(targets[0])
+	if autoInstalled != nil {
+		auto := autoInstalled[name]
+		explanation.AutoInstalled = &auto
+	}
+	for _, target := range targets {
+		for _, edge := range graph.neededBy[target] {
+			explanation.NeededBy = append(explanation.NeededBy, graph.link(edge))
+		}
+	}
+	explanation.Chains = graph.chains(targets, autoInstalled)
+	explanation.Unneeded = len(explanation.NeededBy) == 0
+
+	var summary []string
+	switch {
+	case explanation.AutoInstalled == nil:
+		summary = append(summary, fmt.Sprintf("%s is installed, zypp keeps no record of automatically installed packages", name))
+	case *explanation.AutoInstalled:
+		summary = append(summary, fmt.Sprintf("%s was installed automatically as a dependency", name))
+	default:
+		summary = append(summary, fmt.Sprintf("%s was installed on purpose", name))
+	}
+	if explanation.Unneeded {
+		summary = append(summary, "no installed package requires or recommends it")
+	} else {
+		summary = append(summary, fmt.Sprintf("%d installed packages need it, %d chains lead back to packages installed on purpose, patterns or products", len(explanation.NeededBy), len(explanation.Chains)))
+	}
+
+	if withSolver {
+		plan, err := PlanTransaction(ctx, "remove", []string{name})
+		if err != nil {
+			explanation.SolverError = err.Error()
+		} else {
+			explanation.RemovalPlan = &plan
+			if plan.Feasible {
+				summary = append(summary, fmt.Sprintf("removing it would remove %d packages", len(plan.Remove)))
+			}
+		}
+	}
+	explanation.Summary = strings.Join(summary, "; ")
+	return explanation, nil
+}
+
+// CapabilityProviders are the packages providing a capability, installed
+// ones from the rpm database and all others from the repositories.
+type CapabilityProviders struct {
+	Capability string        `json:"capability"`
+	Installed  []string      `json:"installed"`
+	Available  []PlanPackage `json:"available"`
+}
+
+// WhatProvides is what-provides of zypper, which is search --provides
+// --match-exact today.
+func WhatProvides(ctx context.Context, capability string) (CapabilityProviders, error) {
+	providers := CapabilityProviders{Capability: capability, Installed: []string{}, Available: []PlanPackage{}}
+	if capability == "" || strings.HasPrefix(capability, "-") || strings.ContainsAny(capability, "\n\x00") {
+		return providers, fmt.Errorf("invalid capability %q", capability)
+	}
+	if db, err := rpmdb.Open(); err == nil {
+		packages, err := db.Dependencies()
+		if err != nil {
+			return providers, err
+		}
+		for _, pkg := range installedProviders(packages, capability) {
+			providers.Installed = append(providers.Installed, pkg.Package.NEVRA())
+		}
+	}
+	result, err := zypperBackend{}.run(ctx, false, "search", "--provides", "--match-exact", "--details", capability)
+	if err != nil {
+		return providers, err
+	}
+	if result.ExitCode == zypperExitInfCapNotFound {
+		return providers, nil
+	}
+	var searchResult zypperSearchResult
+	if err := decodeZypperXML(result.Stdout, &searchResult); err != nil {
+		return providers, err
+	}
+	for _, solvable := range searchResult.Solvables {
+		if strings.HasPrefix(solvable.Status, "installed") {
+			continue
+		}
+		providers.Available = append(providers.Available, PlanPackage{
+			Name:       solvable.Name,
+			Kind:       solvable.Kind,
+			Version:    solvable.Edition,
+			Arch:       solvable.Arch,
+			Repository: solvable.Repository,
+		})
+	}
+	return providers, nil
+}
+
+func installedProviders(packages []rpmdb.PackageDependencies, capability string) []rpmdb.PackageDependencies {
+	var matching []rpmdb.PackageDependencies
+	for _, pkg := range packages {
+		found := pkg.Package.Name == capability
+		for _, provide := range pkg.Provides {
+			found = found || provide.Name == capability
+		}
+		for _, file := range pkg.Files {
+			found = found || file == capability
+		}
+		if found {
+			matching = append(matching, pkg)
+		}
+	}
+	return matching
+}
+
+func addExplainToolsToMCPServer() {
+	mcpToolExplain := mcp.NewTool("zypper_explain_package",
+		mcp.WithDescription("Explain why an installed package is installed: which installed packages require or recommend it, which it supplements, the chains back to packages installed on purpose, patterns or products, and whether zypp installed it automatically as a dependency. Use it before removing a package."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Exact name of the installed package")),
+		mcp.WithBoolean("with_solver", mcp.Description("Also ask the solver with a dry-run what removing the package would remove")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolExplain, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, _ := req.GetArguments()["name"].(string)
+		withSolver, _ := req.GetArguments()["with_solver"].(bool)
+		explanation, err := ExplainPackage(ctx, strings.TrimSpace(name), withSolver)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(explanation)
+	})
+	utils.RecordRegisteredTool("zypper_explain_package")
+
+	mcpToolProvides := mcp.NewTool("zypper_what_provides",
+		mcp.WithDescription("List the installed packages and the packages in the repositories which provide a capability, e.g. a package name, a library like 'libssl.so.3()(64bit)' or a file like /usr/bin/python3."),
+		mcp.WithString("capability", mcp.Required(), mcp.Description("The capability, without version")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolProvides, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		capability, _ := req.GetArguments()["capability"].(string)
+		providers, err := WhatProvides(ctx, strings.TrimSpace(capability))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(providers)
+	})
+	utils.RecordRegisteredTool("zypper_what_provides")
+}
@@ -0,0 +1,490 @@
+package zypper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// zypp history log of libzypp, see /usr/share/doc/packages/libzypp
+const zyppHistoryPath = "/var/log/zypp/history"
+
+const zyppHistoryTimeLayout = "2006-01-02 15:04:05"
+
+// Actions of the history log, libzypp pads them with spaces to seven
+// characters.
+const (
+	historyCommand = "command"
+	historyInstall = "install"
+	historyRemove  = "remove"
+	historyRepoAdd = "radd"
+	historyRepoDel = "rremove"
+	historyRepoAls = "ralias"
+	historyRepoURL = "rurl"
+	historyPatch   = "patch"
+)
+
+// Default of multiversion in zypp.conf is provides:multiversion(kernel),
+// which is not in the history log. These packages are installed next to
+// the older versions.
+var multiversionPackages = []string{"kernel-default", "kernel-azure", "kernel-rt", "kernel-64kb", "kernel-kvmsmall", "kernel-source", "kernel-devel", "kernel-syms", "kernel-docs"}
+
+const defaultHistoryLimit = 200
+
+type HistoryEvent struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Package     string    `json:"package,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Arch        string    `json:"arch,omitempty"`
+	OldVersion  string    `json:"old_version,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	OldAlias    string    `json:"old_alias,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	RequestedBy string    `json:"requested_by,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Severity    string    `json:"severity,omitempty"`
+	Status      string    `json:"status,omitempty"`
+}
+
+type HistoryFilter struct {
+	Since   time.Time
+	Until   time.Time
+	Package string
+	User    string
+	Command string
+	Actions []string
+}
+
+type RollbackStep struct {
+	Package        string `json:"package"`
+	Arch           string `json:"arch,omitempty"`
+	Action         string `json:"action"`
+	CurrentVersion string `json:"current_version,omitempty"`
+	TargetVersion  string `json:"target_version,omitempty"`
+	Available      bool   `json:"available"`
+	Repository     string `json:"repository,omitempty"`
+	Command        string `json:"command,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type RollbackPlan struct {
+	Since        time.Time      `json:"since"`
+	Steps        []RollbackStep `json:"steps"`
+	RepoChanges  []HistoryEvent `json:"repository_changes,omitempty"`
+	Incomplete   bool           `json:"incomplete"`
+	Instructions string         `json:"instructions"`
+}
+
+func isMultiversion(name string) bool {
+	for _, multiversion := range multiversionPackages {
+		if name == multiversion {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteCommand turns 'zypper' 'in' 'vim' into zypper in vim.
+func unquoteCommand(command string) string {
+	var words []string
+	for _, word := range strings.Split(command, "' '") {
+		words = append(words, strings.Trim(word, "'"))
+	}
+	return strings.TrimSpace(strings.Join(words, " "))
+}
+
+func historyField(fields []string, index int) string {
+	if index < len(fields) {
+		return strings.TrimSpace(fields[index])
+	}
+	return ""
+}
+
+// parseZyppHistory parses the lines of the history log. Every event gets
+// the user and command line of the command entry before it, and updates
+// get the version installed before.
+func parseZyppHistory(reader io.Reader) ([]HistoryEvent, error) {
+	var events []HistoryEvent
+	var user, command string
+	installed := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 3 {
+			continue
+		}
+		timestamp, err := time.ParseInLocation(zyppHistoryTimeLayout, fields[0], time.Local)
+		if err != nil {
+			continue
+		}
+		event := HistoryEvent{Time: timestamp, Action: strings.TrimSpace(fields[1])}
+		switch event.Action {
+		case historyCommand:
+			user = historyField(fields, 2)
+			command = unquoteCommand(historyField(fields, 3))
+		case historyInstall:
+			event.Package = historyField(fields, 2)
+			event.Version = historyField(fields, 3)
+			event.Arch = historyField(fields, 4)
+			event.RequestedBy = historyField(fields, 5)
+			event.Repository = historyField(fields, 6)
+			key := event.Package + "." + event.Arch
+			if !isMultiversion(event.Package) {
+				event.OldVersion = installed[key]
+			}
+			installed[key] = event.Version
+		case historyRemove:
+			event.Package = historyField(fields, 2)
+			event.Version = historyField(fields, 3)
+			event.Arch = historyField(fields, 4)
+			event.RequestedBy = historyField(fields, 5)
+			key := event.Package + "." + event.Arch
+			if installed[key] == event.Version {
+				delete(installed, key)
+			}
+		case historyRepoAdd, historyRepoURL:
+			event.Repository = historyField(fields, 2)
+			event.URL = historyField(fields, 3)
+		case historyRepoDel:
+			event.Repository = historyField(fields, 2)
+		case historyRepoAls:
+			event.Repository = historyField(fields, 3)
+			event.OldAlias = historyField(fields, 2)
+		case historyPatch:
+			event.Package = historyField(fields, 2)
+			event.Version = historyField(fields, 3)
+			event.Arch = historyField(fields, 4)
+			event.Repository = historyField(fields, 5)
+			event.Category = historyField(fields, 6)
+			event.Severity = historyField(fields, 7)
+			event.Status = historyField(fields, 8)
+		default:
+			continue
+		}
+		event.User = user
+		event.Command = command
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// ReadZyppHistory returns all events of the zypp history log.
+func ReadZyppHistory() ([]HistoryEvent, error) {
+	file, err := os.Open(zyppHistoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseZyppHistory(file)
+}
+
+func (filter HistoryFilter) matches(event HistoryEvent) bool {
+	if !filter.Since.IsZero() && event.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && event.Time.After(filter.Until) {
+		return false
+	}
+	if filter.Package != "" && event.Package != filter.Package {
+		return false
+	}
+	if filter.User != "" && !strings.Contains(event.User, filter.User) && !strings.Contains(event.RequestedBy, filter.User) {
+		return false
+	}
+	if filter.Command != "" && !strings.Contains(event.Command, filter.Command) {
+		return false
+	}
+	if len(filter.Actions) > 0 {
+		for _, action := range filter.Actions {
+			if event.Action == action {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// FilterHistory returns the events matching filter.
+func FilterHistory(events []HistoryEvent, filter HistoryFilter) []HistoryEvent {
+	var matching []HistoryEvent
+	for _, event := range events {
+		if filter.matches(event) {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+// parseHistoryTime accepts RFC 3339, the format of the history log and a
+// plain date, the latter two in local time.
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	for _, layout := range []string{zyppHistoryTimeLayout, "2006-01-02T15:04:05", "2006-01-02"} {
+		if timestamp, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return timestamp, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or YYYY-MM-DD", value)
+}
+
+type packageState struct {
+	name    string
+	arch    string
+	before  string
+	current string
+	touched bool
+}
+
+// packageStates returns the version of every package touched after since
+// as it was before since and as it is now, "" for not installed.
+func packageStates(events []HistoryEvent, since time.Time) []*packageState {
+	states := make(map[string]*packageState)
+	var order []string
+	for _, event := range events {
+		if event.Action != historyInstall && event.Action != historyRemove {
+			continue
+		}
+		key := event.Package + "." + event.Arch
+		if isMultiversion(event.Package) {
+			// every version is a package of its own
+			key = event.Package + "-" + event.Version + "." + event.Arch
+		}
+		state, ok := states[key]
+		if !ok {
+			state = &packageState{name: event.Package, arch: event.Arch}
+			states[key] = state
+			order = append(order, key)
+		}
+		if event.Time.Before(since) {
+			if event.Action == historyInstall {
+				state.before = event.Version
+			} else if state.before == event.Version {
+				state.before = ""
+			}
+			state.current = state.before
+			continue
+		}
+		state.touched = true
+		if event.Action == historyInstall {
+			state.current = event.Version
+		} else if state.current == event.Version {
+			state.current = ""
+		}
+	}
+	var result []*packageState
+	for _, key := range order {
+		if states[key].touched && states[key].before != states[key].current {
+			result = append(result, states[key])
+		}
+	}
+	return result
+}
+
+// availableVersions returns the repositories of all versions of names in
+// the enabled repositories, keyed by name and then by version and arch.
+// All names are looked up with a single search.
+func availableVersions(ctx context.Context, names []string) (map[string]map[string]string, error) {
+	versions := make(map[string]map[string]string)
+	if len(names) == 0 {
+		return versions, nil
+	}
+	result, err := zypperBackend{}.run(ctx, false, "search", append([]string{"--details", "--match-exact", "--type", "package"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode == zypperExitInfCapNotFound {
+		return versions, nil
+	}
+	var searchResult zypperSearchResult
+	if err := decodeZypperXML(result.Stdout, &searchResult); err != nil {
+		return nil, err
+	}
+	for _, solvable := range searchResult.Solvables {
+		if solvable.Repository == "" || solvable.Repository == "(System Packages)" || solvable.Repository == "@System" {
+			continue
+		}
+		if versions[solvable.Name] == nil {
+			versions[solvable.Name] = make(map[string]string)
+		}
+		versions[solvable.Name][solvable.Edition+"."+solvable.Arch] = solvable.Repository
+	}
+	return versions, nil
+}
+
+// BuildRollbackPlan proposes the zypper commands restoring the packages as
+// they were before since. Nothing is executed.
+func BuildRollbackPlan(ctx context.Context, events []HistoryEvent, since time.Time, packageName string) (RollbackPlan, error) {
+	var states []*packageState
+	var names []string
+	seen := make(map[string]bool)
+	for _, state := range packageStates(events, since) {
+		if packageName != "" && state.name != packageName {
+			continue
+		}
+		states = append(states, state)
+		if state.before != "" && !seen[state.name] {
+			seen[state.name] = true
+			names = append(names, state.name)
+		}
+	}
+	versions, err := availableVersions(ctx, names)
+	if err != nil {
+		return RollbackPlan{Since: since, Steps: []RollbackStep{}}, err
+	}
+	return rollbackPlan(events, since, states, versions), nil
+}
+
+// rollbackPlan builds the steps for states, versions as returned by
+// availableVersions.
+func rollbackPlan(events []HistoryEvent, since time.Time, states []*packageState, versions map[string]map[string]string) RollbackPlan {
+	plan := RollbackPlan{
+		Since:        since,
+		Steps:        []RollbackStep{},
+		Instructions: "Review the steps and run the commands of the available steps in one transaction. Nothing has been changed yet.",
+	}
+	for _, state := range states {
+		name := state.name
+		step := RollbackStep{
+			Package:        name,
+			Arch:           state.arch,
+			CurrentVersion: state.current,
+			TargetVersion:  state.before,
+		}
+		if state.before == "" {
+			step.Action = "remove"
+			step.Available = true
+			step.Command = fmt.Sprintf("zypper remove %s.%s=%s", name, state.arch, state.current)
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+		if state.current == "" {
+			step.Action = "reinstall"
+		} else {
+			step.Action = "install_old_version"
+		}
+		step.Repository, step.Available = versions[name][state.before+"."+state.arch]
+		if step.Available {
+			step.Command = fmt.Sprintf("zypper install --oldpackage %s.%s=%s", name, state.arch, state.before)
+		} else {
+			step.Reason = "version " + state.before + " is not available in the enabled repositories"
+			plan.Incomplete = true
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	for _, event := range events {
+		if event.Time.Before(since) {
+			continue
+		}
+		switch event.Action {
+		case historyRepoAdd, historyRepoDel, historyRepoAls, historyRepoURL:
+			plan.RepoChanges = append(plan.RepoChanges, event)
+		}
+	}
+	sort.SliceStable(plan.Steps, func(i, j int) bool { return plan.Steps[i].Package < plan.Steps[j].Package })
+	return plan
+}
+
+func addHistoryToolsToMCPServer() {
+	mcpToolHistory := mcp.NewTool("zypper_history",
+		mcp.WithDescription("Show the package and repository changes from the zypp history log (/var/log/zypp/history) as JSON, the most recent last. Updates include the version installed before. Answers questions like what changed since a date or when a package was last upgraded and from which version."),
+		mcp.WithString("since", mcp.Description("Only changes at or after this time, RFC 3339 or YYYY-MM-DD in local time")),
+		mcp.WithString("until", mcp.Description("Only changes at or before this time, RFC 3339 or YYYY-MM-DD in local time")),
+		mcp.WithString("package", mcp.Description("Only changes of this package name")),
+		mcp.WithString("user", mcp.Description("Only changes requested by this user, e.g. root")),
+		mcp.WithString("command", mcp.Description("Only changes made by a command line containing this text, e.g. dup")),
+		mcp.WithArray("actions", mcp.Description("Only these actions: command, install, remove, radd, rremove, ralias, rurl, patch"), mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithNumber("limit", mcp.Description(fmt.Sprintf("Maximum number of the most recent events, default %d", defaultHistoryLimit))),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolHistory, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		arguments := req.GetArguments()
+		var filter HistoryFilter
+		var err error
+		since, _ := arguments["since"].(string)
+		if filter.Since, err = parseHistoryTime(since); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		until, _ := arguments["until"].(string)
+		if filter.Until, err = parseHistoryTime(until); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if len(until) == len("2006-01-02") {
+			// the whole day
+			filter.Until = filter.Until.Add(24*time.Hour - time.Second)
+		}
+		filter.Package, _ = arguments["package"].(string)
+		filter.User, _ = arguments["user"].(string)
+		filter.Command, _ = arguments["command"].(string)
+		if actions, ok := arguments["actions"].([]any); ok {
+			for _, action := range actions {
+				if actionString, ok := action.(string); ok {
+					filter.Actions = append(filter.Actions, actionString)
+				}
+			}
+		}
+		limit := defaultHistoryLimit
+		if number, ok := arguments["limit"].(float64); ok && number > 0 {
+			limit = int(number)
+		}
+		events, err := ReadZyppHistory()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		matching := FilterHistory(events, filter)
+		total := len(matching)
+		if total > limit {
+			matching = matching[total-limit:]
+		}
+		return utils.JSONToolResult(map[string]any{"events": matching, "total": total, "truncated": total > limit})
+	})
+	utils.RecordRegisteredTool("zypper_history")
+
+	mcpToolRollback := mcp.NewTool("zypper_history_rollback_plan",
+		mcp.WithDescription("Propose the zypper commands to bring the packages changed since a time back to the versions installed before: older versions are reinstalled if still available in the enabled repositories, newly installed packages are removed. Repository changes are listed. Nothing is executed."),
+		mcp.WithString("since", mcp.Required(), mcp.Description("Roll back the changes at or after this time, RFC 3339 or YYYY-MM-DD in local time")),
+		mcp.WithString("package", mcp.Description("Only the plan for this package name")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolRollback, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sinceArgument, _ := req.GetArguments()["since"].(string)
+		if sinceArgument == "" {
+			return mcp.NewToolResultError("since is required"), nil
+		}
+		since, err := parseHistoryTime(sinceArgument)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		packageName, _ := req.GetArguments()["package"].(string)
+		events, err := ReadZyppHistory()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		plan, err := BuildRollbackPlan(ctx, events, since, packageName)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(plan)
+	})
+	utils.RecordRegisteredTool("zypper_history_rollback_plan")
+}
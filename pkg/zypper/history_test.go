@@ -0,0 +1,130 @@
+package zypper
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readHistoryFixture(t *testing.T) []HistoryEvent {
+	t.Helper()
+	file, err := os.Open("testdata/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := parseZyppHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func historyTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	timestamp, err := parseHistoryTime(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return timestamp
+}
+
+func TestParseZyppHistory(t *testing.T) {
+	events := readHistoryFixture(t)
+	if len(events) != 16 {
+		t.Fatalf("%d events, want 16", len(events))
+	}
+	for _, tc := range []struct {
+		index int
+		want  HistoryEvent
+	}{
+		{1, HistoryEvent{Time: historyTime(t, "2024-05-01 09:00:01"), Action: historyInstall, Package: "vim", Version: "9.0.2103-1.1", Arch: "x86_64",
+			Repository: "repo-oss", RequestedBy: "root@host", User: "root@host", Command: "zypper in vim curl nano"}},
+		// an update carries the version before
+		{6, HistoryEvent{Time: historyTime(t, "2024-05-12 08:30:10"), Action: historyInstall, Package: "vim", Version: "9.1.0330-1.1", Arch: "x86_64",
+			OldVersion: "9.0.2103-1.1", Repository: "repo-update", RequestedBy: "root@host", User: "root@host", Command: "zypper up"}},
+		// kernels are installed next to each other
+		{7, HistoryEvent{Time: historyTime(t, "2024-05-12 08:30:20"), Action: historyInstall, Package: "kernel-default", Version: "6.4.0-150600.23.14.2", Arch: "x86_64",
+			Repository: "repo-sle-update", User: "root@host", Command: "zypper up"}},
+		{8, HistoryEvent{Time: historyTime(t, "2024-05-12 08:30:30"), Action: historyPatch, Package: "openSUSE-SLE-15.6-2024-1234", Version: "1", Arch: "noarch",
+			Repository: "repo-update", Category: "security", Severity: "important", Status: "applied", User: "root@host", Command: "zypper up"}},
+		{10, HistoryEvent{Time: historyTime(t, "2024-05-13 14:00:01"), Action: historyRemove, Package: "nano", Version: "7.2-1.1", Arch: "x86_64",
+			RequestedBy: "admin@host", User: "admin@host", Command: "zypper rm nano"}},
+		{14, HistoryEvent{Time: historyTime(t, "2024-05-14 10:00:00"), Action: historyRepoAdd, Repository: "repo-extra", URL: "http://download.example.com/extra/",
+			User: "admin@host", Command: "zypper rm nano"}},
+		{15, HistoryEvent{Time: historyTime(t, "2024-05-14 10:01:00"), Action: historyRepoAls, Repository: "repo-more", OldAlias: "repo-extra",
+			User: "admin@host", Command: "zypper rm nano"}},
+	} {
+		if !reflect.DeepEqual(events[tc.index], tc.want) {
+			t.Errorf("event %d\n= %+v\nwant %+v", tc.index, events[tc.index], tc.want)
+		}
+	}
+
+	matching := FilterHistory(events, HistoryFilter{Since: historyTime(t, "2024-05-12"), User: "admin", Actions: []string{historyInstall}})
+	if len(matching) != 2 || matching[0].Package != "htop" || matching[1].Package != "strace" {
+		t.Errorf("filtered %+v", matching)
+	}
+}
+
+func TestPackageStates(t *testing.T) {
+	events := readHistoryFixture(t)
+	var got []packageState
+	for _, state := range packageStates(events, historyTime(t, "2024-05-10")) {
+		got = append(got, *state)
+	}
+	want := []packageState{
+		{name: "vim", arch: "x86_64", before: "9.0.2103-1.1", current: "9.1.0330-1.1", touched: true},
+		{name: "nano", arch: "x86_64", before: "7.2-1.1", current: "", touched: true},
+		{name: "kernel-default", arch: "x86_64", before: "", current: "6.4.0-150600.23.14.2", touched: true},
+		{name: "htop", arch: "x86_64", before: "", current: "3.3.0-1.1", touched: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("states\n= %+v\nwant %+v", got, want)
+	}
+	if states := packageStates(events, historyTime(t, "2024-06-01")); len(states) != 0 {
+		t.Errorf("states after the last change: %+v", states)
+	}
+}
+
+func TestRollbackPlan(t *testing.T) {
+	events := readHistoryFixture(t)
+	since := historyTime(t, "2024-05-10")
+	versions := map[string]map[string]string{
+		"vim":  {"9.0.2103-1.1.x86_64": "repo-oss", "9.1.0330-1.1.x86_64": "repo-update"},
+		"nano": {"7.3-1.1.x86_64": "repo-oss"},
+	}
+	plan := rollbackPlan(events, since, packageStates(events, since), versions)
+	want := []RollbackStep{
+		{Package: "htop", Arch: "x86_64", Action: "remove", CurrentVersion: "3.3.0-1.1", Available: true,
+			Command: "zypper remove htop.x86_64=3.3.0-1.1"},
+		{Package: "kernel-default", Arch: "x86_64", Action: "remove", CurrentVersion: "6.4.0-150600.23.14.2", Available: true,
+			Command: "zypper remove kernel-default.x86_64=6.4.0-150600.23.14.2"},
+		{Package: "nano", Arch: "x86_64", Action: "reinstall", TargetVersion: "7.2-1.1",
+			Reason: "version 7.2-1.1 is not available in the enabled repositories"},
+		{Package: "vim", Arch: "x86_64", Action: "install_old_version", CurrentVersion: "9.1.0330-1.1", TargetVersion: "9.0.2103-1.1", Available: true,
+			Repository: "repo-oss", Command: "zypper install --oldpackage vim.x86_64=9.0.2103-1.1"},
+	}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("steps\n= %+v\nwant %+v", plan.Steps, want)
+	}
+	if !plan.Incomplete {
+		t.Error("plan with an unavailable version is not incomplete")
+	}
+	if len(plan.RepoChanges) != 2 || plan.RepoChanges[0].Action != historyRepoAdd || plan.RepoChanges[1].Action != historyRepoAls {
+		t.Errorf("repository changes %+v", plan.RepoChanges)
+	}
+}
+
+func TestBuildRollbackPlanRemoveOnly(t *testing.T) {
+	// only removals, so zypper is not searched
+	events := readHistoryFixture(t)
+	plan, err := BuildRollbackPlan(context.Background(), events, historyTime(t, "2024-05-10"), "htop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Command != "zypper remove htop.x86_64=3.3.0-1.1" || plan.Incomplete {
+		t.Errorf("plan %+v", plan)
+	}
+}
@@ -0,0 +1,359 @@
+package zypper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"mcp-server-admintasks/pkg/rpmdb"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// zypp.conf with the multiversion.kernels policy, /usr/etc on newer
+// releases without a copy in /etc
+var zyppConfPaths = []string{"/etc/zypp/zypp.conf", "/usr/etc/zypp/zypp.conf"}
+
+const grubEnvFile = "/boot/grub2/grubenv"
+
+// DiskSpace is the space of the file system of Path.
+type DiskSpace struct {
+	Path           string `json:"path"`
+	Total          int64  `json:"total"`
+	Free           int64  `json:"free"`
+	FreeFormatted  string `json:"free_formatted"`
+	PercentageUsed int    `json:"percentage_used"`
+}
+
+// InstalledKernel is a kernel release with the packages of it, like
+// kernel-default and kernel-default-extra.
+type InstalledKernel struct {
+	Release  string   `json:"release"`
+	Flavor   string   `json:"flavor,omitempty"`
+	Packages []string `json:"packages"`
+	Running  bool     `json:"running"`
+	Default  bool     `json:"boot_default"`
+	Removed  bool     `json:"removed_by_purge"`
+	// kernel-<flavor> and kernel-<flavor>-base, without them it cannot boot
+	images []string
+}
+
+type KernelPurge struct {
+	Policy         string            `json:"policy"`
+	PolicySource   string            `json:"policy_source,omitempty"`
+	RunningKernel  string            `json:"running_kernel"`
+	DefaultKernel  string            `json:"boot_default,omitempty"`
+	DefaultSource  string            `json:"boot_default_source,omitempty"`
+	Kernels        []InstalledKernel `json:"kernels"`
+	Plan           TransactionPlan   `json:"plan"`
+	Safe           bool              `json:"safe"`
+	Unsafe         string            `json:"unsafe_reason,omitempty"`
+	BootSpace      *DiskSpace        `json:"boot_space,omitempty"`
+	BootSpaceAfter *DiskSpace        `json:"boot_space_after,omitempty"`
+	Purged         bool              `json:"purged"`
+	Summary        string            `json:"summary"`
+}
+
+func diskSpace(path string) (*DiskSpace, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	space := &DiskSpace{
+		Path:  path,
+		Total: int64(stat.Blocks) * int64(stat.Bsize),
+		Free:  int64(stat.Bavail) * int64(stat.Bsize),
+	}
+	space.FreeFormatted = formatBytes(space.Free)
+	// like df, the blocks reserved for root do not count as available
+	used := stat.Blocks - stat.Bfree
+	if used+stat.Bavail > 0 {
+		space.PercentageUsed = int((used*100 + used + stat.Bavail - 1) / (used + stat.Bavail))
+	}
+	return space, nil
+}
+
+// multiversionKernels returns the multiversion.kernels setting of
+// zypp.conf and the file it is from.
+func multiversionKernels() (string, string) {
+	for _, path := range zyppConfPaths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		value := ""
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, setting, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if found && strings.TrimSpace(key) == "multiversion.kernels" {
+				value = strings.TrimSpace(setting)
+			}
+		}
+		file.Close()
+		return value, path
+	}
+	return "", ""
+}
+
+// bootDefault returns the release of the kernel GRUB boots by default:
+// the saved entry if it names a kernel, otherwise the target of
+// /boot/vmlinuz, which the default entry uses.
+func bootDefault(releases []string) (string, string) {
+	if content, err := os.ReadFile(grubEnvFile); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			entry, found := strings.CutPrefix(line, "saved_entry=")
+			if !found || entry == "" {
+				continue
+			}
+			for _, release := range releases {
+				if strings.Contains(entry, release) {
+					return release, grubEnvFile
+				}
+			}
+		}
+	}
+	for _, image := range []string{"/boot/vmlinuz", "/boot/Image", "/boot/image"} {
+		target, err := os.Readlink(image)
+		if err != nil {
+			continue
+		}
+		base := filepath.Base(target)
+		if _, release, found := strings.Cut(base, "-"); found {
+			return release, image
+		}
+	}
+	return "", ""
+}
+
+// installedKernels groups the installed kernel packages by the release of
+// the module directory they own.
+func installedKernels() ([]InstalledKernel, error) {
+	db, err := rpmdb.Open()
+	if err != nil {
+		return nil, err
+	}
+	files, err := db.FilesOfPackages(func(name string) bool {
+		return strings.HasPrefix(name, "kernel-") && rebootRequiredPackage(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	kernels := make(map[string]*InstalledKernel)
+	for name, versions := range files {
+		for nevra, packageFiles := range versions {
+			for _, file := range packageFiles {
+				release := ""
+				for _, dir := range kernelModuleDirs {
+					if rest, found := strings.CutPrefix(file.Path, dir+"/"); found {
+						release, _, _ = strings.Cut(rest, "/")
+					}
+				}
+				if release == "" {
+					continue
+				}
+				kernel, ok := kernels[release]
+				if !ok {
+					kernel = &InstalledKernel{Release: release}
+					_, kernel.Flavor = kernelRelease(release)
+					kernels[release] = kernel
+				}
+				kernel.Packages = append(kernel.Packages, nevra)
+				if name == "kernel-"+kernel.Flavor || name == "kernel-"+kernel.Flavor+"-base" {
+					kernel.images = append(kernel.images, nevra)
+				}
+				break
+			}
+		}
+	}
+	result := []InstalledKernel{}
+	for _, kernel := range kernels {
+		sort.Strings(kernel.Packages)
+		result = append(result, *kernel)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, _ := kernelRelease(result[i].Release)
+		b, _ := kernelRelease(result[j].Release)
+		return utils.RpmVerCmp(a, b) < 0
+	})
+	return result, nil
+}
+
+// PreviewKernelPurge lists the installed kernels and what zypper
+// purge-kernels would remove. Safe is only true if the running kernel and
+// at least one other kernel are kept.
+func PreviewKernelPurge(ctx context.Context) (KernelPurge, error) {
+	purge := KernelPurge{Kernels: []InstalledKernel{}}
+	purge.Policy, purge.PolicySource = multiversionKernels()
+	if purge.Policy == "" {
+		purge.Policy = "not set, the default of libzypp applies"
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		purge.RunningKernel = strings.TrimSpace(string(release))
+	}
+	kernels, err := installedKernels()
+	if err != nil {
+		return purge, err
+	}
+	var releases []string
+	for _, kernel := range kernels {
+		releases = append(releases, kernel.Release)
+	}
+	purge.DefaultKernel, purge.DefaultSource = bootDefault(releases)
+	purge.BootSpace, _ = diskSpace("/boot")
+
+	purge.Plan = TransactionPlan{Action: "purge-kernels"}
+	result, err := zypperBackend{}.run(ctx, true, "purge-kernels", "--dry-run")
+	if err != nil {
+		return purge, err
+	}
+	if err := parseTransactionPlan(&purge.Plan, result); err != nil {
+		return purge, err
+	}
+	purge.Kernels = kernels
+	purge.markRemoved()
+	kept := 0
+	runningInstalled, runningKept := false, false
+	for i := range purge.Kernels {
+		kernel := &purge.Kernels[i]
+		kernel.Running = kernel.Release == purge.RunningKernel
+		kernel.Default = kernel.Release == purge.DefaultKernel
+		runningInstalled = runningInstalled || kernel.Running
+		if !kernel.Removed {
+			kept++
+			runningKept = runningKept || kernel.Running
+		}
+	}
+
+	switch {
+	case !purge.Plan.Feasible:
+		purge.Unsafe = "the solver cannot purge the kernels: " + purge.Plan.Error
+	case !runningInstalled:
+		purge.Unsafe = "the running kernel " + purge.RunningKernel + " is not installed anymore, reboot first"
+	case !runningKept:
+		purge.Unsafe = "the running kernel " + purge.RunningKernel + " would not be kept"
+	case kept < 2 && len(purge.Plan.Remove) > 0:
+		purge.Unsafe = "no fallback kernel besides the running one would be kept"
+	default:
+		purge.Safe = true
+	}
+	purge.summarize()
+	return purge, nil
+}
+
+// markRemoved marks the kernels whose images Plan removes.
+func (purge *KernelPurge) markRemoved() {
+	removed := make(map[string]bool)
+	for _, pkg := range purge.Plan.Remove {
+		removed[pkg.Name+"-"+pkg.Version+"."+pkg.Arch] = true
+	}
+	for i := range purge.Kernels {
+		kernel := &purge.Kernels[i]
+		images := kernel.images
+		if len(images) == 0 {
+			images = kernel.Packages
+		}
+		kernel.Removed = false
+		for _, nevra := range images {
+			kernel.Removed = kernel.Removed || removed[nevra]
+		}
+	}
+}
+
+func (purge *KernelPurge) summarize() {
+	removed := 0
+	for _, kernel := range purge.Kernels {
+		if kernel.Removed {
+			removed++
+		}
+	}
+	summary := []string{fmt.Sprintf("%d kernels installed, %s running", len(purge.Kernels), purge.RunningKernel)}
+	if purge.Purged {
+		summary = append(summary, fmt.Sprintf("%d kernels purged, %d packages removed", removed, len(purge.Plan.Remove)))
+	} else {
+		summary = append(summary, fmt.Sprintf("purge-kernels would remove %d kernels, %d packages", removed, len(purge.Plan.Remove)))
+	}
+	if purge.Unsafe != "" {
+		summary = append(summary, "not purged: "+purge.Unsafe)
+	} else if purge.Plan.Error != "" {
+		summary = append(summary, "purge-kernels failed: "+purge.Plan.Error)
+	}
+	if purge.BootSpace != nil {
+		summary = append(summary, "/boot free "+purge.BootSpace.FreeFormatted)
+	}
+	if purge.BootSpaceAfter != nil {
+		summary = append(summary, "free afterwards "+purge.BootSpaceAfter.FreeFormatted)
+	}
+	purge.Summary = strings.Join(summary, "; ")
+}
+
+// PurgeKernels removes the packages of the purge-kernels preview, if the
+// preview is safe. Exactly the previewed packages are removed, not those
+// of another purge-kernels run.
+func PurgeKernels(ctx context.Context) (KernelPurge, error) {
+	purge, err := PreviewKernelPurge(ctx)
+	if err != nil || !purge.Safe || len(purge.Plan.Remove) == 0 {
+		utils.MarkCallUnchanged(ctx)
+		return purge, err
+	}
+	var nevras []string
+	for _, pkg := range purge.Plan.Remove {
+		nevras = append(nevras, pkg.Name+"-"+pkg.Version+"."+pkg.Arch)
+	}
+	result, err := zypperBackend{}.run(ctx, true, "remove", nevras...)
+	if err != nil {
+		return purge, err
+	}
+	// the packages actually removed
+	plan := TransactionPlan{Action: "purge-kernels"}
+	if err := parseTransactionPlan(&plan, result); err != nil {
+		return purge, err
+	}
+	purge.Plan = plan
+	purge.markRemoved()
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfRebootNeed, zypperExitInfRestartNeed, zypperExitInfReposSkip:
+		purge.Purged = true
+	default:
+		purge.Plan.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+	}
+	purge.BootSpaceAfter, _ = diskSpace("/boot")
+	purge.summarize()
+	return purge, nil
+}
+
+func addKernelToolsToMCPServer() {
+	mcpToolList := mcp.NewTool("zypper_list_kernels",
+		mcp.WithDescription("List the installed kernels with the running one and the boot default marked, the multiversion.kernels policy, the free space of /boot, and which kernels zypper purge-kernels would remove (dry-run)."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		purge, err := PreviewKernelPurge(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(purge)
+	})
+	utils.RecordRegisteredTool("zypper_list_kernels")
+
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("zypper_purge_kernels", zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		return
+	}
+	mcpToolPurge := mcp.NewTool("zypper_purge_kernels",
+		mcp.WithDescription("Remove old kernels according to the multiversion.kernels policy: exactly the packages of the zypper purge-kernels dry-run are removed, and only if the running kernel and at least one fallback kernel are kept. Reports the free space of /boot before and after."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolPurge, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		purge, err := PurgeKernels(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(purge)
+	})
+	utils.RecordRegisteredTool("zypper_purge_kernels")
+	utils.MarkToolMutating("zypper_purge_kernels", zypperCmd.Executable)
+}
@@ -0,0 +1,32 @@
+package zypper
+
+import "testing"
+
+func TestMarkRemoved(t *testing.T) {
+	purge := KernelPurge{
+		Kernels: []InstalledKernel{
+			{Release: "6.4.0-150600.23.7-default", Packages: []string{"kernel-default-6.4.0-150600.23.7.1.x86_64", "kernel-default-extra-6.4.0-150600.23.7.1.x86_64"}, images: []string{"kernel-default-6.4.0-150600.23.7.1.x86_64"}},
+			{Release: "6.4.0-150600.23.14-default", Packages: []string{"kernel-default-6.4.0-150600.23.14.2.x86_64"}, images: []string{"kernel-default-6.4.0-150600.23.14.2.x86_64"}},
+			// without images, any package counts
+			{Release: "6.4.0-150600.23.7-rt", Packages: []string{"kernel-rt-devel-6.4.0-150600.23.7.1.x86_64"}},
+		},
+		Plan: TransactionPlan{Remove: []PlanPackage{
+			{Name: "kernel-default-extra", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"},
+			{Name: "kernel-rt-devel", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"},
+		}},
+	}
+	purge.markRemoved()
+	for i, want := range []bool{false, false, true} {
+		if purge.Kernels[i].Removed != want {
+			t.Errorf("%s removed %v, want %v", purge.Kernels[i].Release, purge.Kernels[i].Removed, want)
+		}
+	}
+	// a later plan replaces the marks
+	purge.Plan.Remove = []PlanPackage{{Name: "kernel-default", Version: "6.4.0-150600.23.7.1", Arch: "x86_64"}}
+	purge.markRemoved()
+	for i, want := range []bool{true, false, false} {
+		if purge.Kernels[i].Removed != want {
+			t.Errorf("%s removed %v, want %v", purge.Kernels[i].Release, purge.Kernels[i].Removed, want)
+		}
+	}
+}
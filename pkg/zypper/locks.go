@@ -0,0 +1,437 @@
+package zypper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Locks added through zypper_add_lock with the reason given for them, in
+// the state directory
+const serverLocksFile = "zypper-locks.json"
+
+var lockTypes = []string{"package", "patch", "pattern", "product", "srcpackage"}
+
+// names only of digits are taken as lock numbers by removelock
+var lockNamePattern = regexp.MustCompile(`^[^-\s][^\n]*$`)
+var lockNumberPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// serverLocksMutex is held while the records are compared with or
+// changed together with the locks of zypp.
+var serverLocksMutex sync.Mutex
+
+type zypperLockMatches struct {
+	zypperLock
+	Matches struct {
+		Count     *int             `xml:"count,attr"`
+		Solvables []zypperSolvable `xml:"solvable"`
+	} `xml:"matches"`
+}
+
+type zypperLocksWithMatches struct {
+	Locks []zypperLockMatches `xml:"locks>lock"`
+}
+
+// ServerLock is the record of a lock added by the server.
+type ServerLock struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Repository string    `json:"repository,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Session    string    `json:"session"`
+	Created    time.Time `json:"created"`
+}
+
+type LockInfo struct {
+	Number        int        `json:"number"`
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Repository    string     `json:"repository,omitempty"`
+	Matches       int        `json:"matches"`
+	Solvables     []string   `json:"solvables,omitempty"`
+	AddedByServer bool       `json:"added_by_server"`
+	Reason        string     `json:"reason,omitempty"`
+	Session       string     `json:"session,omitempty"`
+	Created       *time.Time `json:"created,omitempty"`
+}
+
+func (lock ServerLock) key() string {
+	return lock.Type + "\x00" + lock.Name + "\x00" + lock.Repository
+}
+
+func serverLocksPath() (string, error) {
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, serverLocksFile), nil
+}
+
+func readServerLocks() ([]ServerLock, error) {
+	filePath, err := serverLocksPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var locks []ServerLock
+	if err := json.Unmarshal(content, &locks); err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+	return locks, nil
+}
+
+func writeServerLocks(locks []ServerLock) error {
+	filePath, err := serverLocksPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(locks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, jsonData, 0600)
+}
+
+// dropServerLock removes the record of lock, serverLocksMutex has to be
+// held.
+func dropServerLock(lock ServerLock) error {
+	serverLocks, err := readServerLocks()
+	if err != nil {
+		return err
+	}
+	var kept []ServerLock
+	for _, serverLock := range serverLocks {
+		if serverLock.key() != lock.key() {
+			kept = append(kept, serverLock)
+		}
+	}
+	if len(kept) == len(serverLocks) {
+		return nil
+	}
+	return writeServerLocks(kept)
+}
+
+func validateLock(name string, lockType string, repository string) error {
+	if !lockNamePattern.MatchString(name) || lockNumberPattern.MatchString(name) {
+		return fmt.Errorf("invalid lock name %q", name)
+	}
+	valid := false
+	for _, known := range lockTypes {
+		valid = valid || lockType == known
+	}
+	if !valid {
+		return fmt.Errorf("invalid lock type %q, use one of %s", lockType, strings.Join(lockTypes, ", "))
+	}
+	if repository != "" {
+		return validateAlias(repository)
+	}
+	return nil
+}
+
+func lockParams(name string, lockType string, repository string) []string {
+	params := []string{"--type", lockType}
+	if repository != "" {
+		params = append(params, "--repo", repository)
+	}
+	return append(params, name)
+}
+
+// ListLocks returns the locks of zypp with the number of packages they
+// match, together with the records of the locks the server added. Records
+// of locks which were removed otherwise are dropped.
+func ListLocks(ctx context.Context) ([]LockInfo, error) {
+	// the records of locks being added are only pruned after them
+	serverLocksMutex.Lock()
+	defer serverLocksMutex.Unlock()
+	result, err := zypperBackend{}.run(ctx, false, "locks", "--solvables")
+	if err != nil {
+		return nil, err
+	}
+	var zypperLockList zypperLocksWithMatches
+	if err := decodeZypperXML(result.Stdout, &zypperLockList); err != nil {
+		return nil, err
+	}
+	serverLocks, err := readServerLocks()
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]ServerLock)
+	for _, serverLock := range serverLocks {
+		recorded[serverLock.key()] = serverLock
+	}
+	locks := []LockInfo{}
+	var stillLocked []ServerLock
+	for _, lock := range zypperLockList.Locks {
+		lockType := lock.Kind
+		if lockType == "" {
+			lockType = lock.Type
+		}
+		if lockType == "" {
+			lockType = "package"
+		}
+		info := LockInfo{
+			Number:     lock.Number,
+			Name:       strings.TrimSpace(lock.Name),
+			Type:       lockType,
+			Repository: lock.Repository,
+			Matches:    len(lock.Matches.Solvables),
+		}
+		if lock.Matches.Count != nil {
+			info.Matches = *lock.Matches.Count
+		}
+		for _, solvable := range lock.Matches.Solvables {
+			nevra := solvable.Name
+			if solvable.Edition != "" {
+				nevra += "-" + solvable.Edition
+			}
+			if solvable.Arch != "" {
+				nevra += "." + solvable.Arch
+			}
+			info.Solvables = append(info.Solvables, nevra)
+		}
+		key := ServerLock{Name: info.Name, Type: info.Type, Repository: info.Repository}.key()
+		if serverLock, ok := recorded[key]; ok {
+			info.AddedByServer = true
+			info.Reason = serverLock.Reason
+			info.Session = serverLock.Session
+			info.Created = &serverLock.Created
+			stillLocked = append(stillLocked, serverLock)
+			delete(recorded, key)
+		}
+		locks = append(locks, info)
+	}
+	if len(stillLocked) != len(serverLocks) {
+		// a failed write is pruned again by the next call
+		writeServerLocks(stillLocked)
+	}
+	return locks, nil
+}
+
+func findLock(locks []LockInfo, name string, lockType string, repository string) *LockInfo {
+	for _, lock := range locks {
+		if lock.Name == name && lock.Type == lockType && lock.Repository == repository {
+			return &lock
+		}
+	}
+	return nil
+}
+
+// addRecordedLock writes the record of a lock and adds the lock.
+func addRecordedLock(ctx context.Context, record ServerLock) error {
+	serverLocksMutex.Lock()
+	defer serverLocksMutex.Unlock()
+	serverLocks, err := readServerLocks()
+	if err == nil {
+		err = writeServerLocks(append(serverLocks, record))
+	}
+	if err != nil {
+		return fmt.Errorf("the lock can not be recorded, so it was not added: %v", err)
+	}
+	result, err := zypperBackend{}.run(ctx, true, "addlock", lockParams(record.Name, record.Type, record.Repository)...)
+	if err == nil && result.ExitCode != zypperExitOK {
+		err = fmt.Errorf("zypper addlock failed: %s", strings.TrimSpace(zypperMessagesText(result.Stdout, "error")+"\n"+result.Stderr))
+	}
+	if err != nil {
+		// a record left over is pruned by ListLocks
+		dropServerLock(record)
+	}
+	return err
+}
+
+// AddLock adds a lock for a package name, glob or capability and records
+// it together with reason and the MCP session. The record is written
+// first, so there is no lock of the server without one. The returned lock
+// is nil if no lock was added.
+func AddLock(ctx context.Context, name string, lockType string, repository string, reason string) (*LockInfo, error) {
+	if err := validateLock(name, lockType, repository); err != nil {
+		return nil, err
+	}
+	locks, err := ListLocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if findLock(locks, name, lockType, repository) != nil {
+		return nil, fmt.Errorf("%s is locked already", name)
+	}
+	record := ServerLock{
+		Name:       name,
+		Type:       lockType,
+		Repository: repository,
+		Reason:     reason,
+		Session:    utils.SessionIDFromContext(ctx),
+		Created:    time.Now().UTC(),
+	}
+	if err := addRecordedLock(ctx, record); err != nil {
+		return nil, err
+	}
+	added := &LockInfo{Name: name, Type: lockType, Repository: repository, AddedByServer: true, Reason: reason, Session: record.Session, Created: &record.Created}
+	if locks, err := ListLocks(ctx); err == nil {
+		if lock := findLock(locks, name, lockType, repository); lock != nil {
+			added = lock
+		}
+	}
+	return added, nil
+}
+
+// RemoveLock removes an existing lock and the record of the server.
+func RemoveLock(ctx context.Context, name string, lockType string, repository string) (*LockInfo, error) {
+	if err := validateLock(name, lockType, repository); err != nil {
+		return nil, err
+	}
+	locks, err := ListLocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lock := findLock(locks, name, lockType, repository)
+	if lock == nil {
+		return nil, fmt.Errorf("no %s lock %s", lockType, name)
+	}
+	serverLocksMutex.Lock()
+	defer serverLocksMutex.Unlock()
+	result, err := zypperBackend{}.run(ctx, true, "removelock", lockParams(name, lockType, repository)...)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != zypperExitOK {
+		return nil, fmt.Errorf("zypper removelock failed: %s", strings.TrimSpace(zypperMessagesText(result.Stdout, "error")+"\n"+result.Stderr))
+	}
+	if lock.AddedByServer {
+		// a record left over is pruned by ListLocks
+		dropServerLock(ServerLock{Name: name, Type: lockType, Repository: repository})
+	}
+	return lock, nil
+}
+
+func lockArguments(req mcp.CallToolRequest) (string, string, string) {
+	name, _ := req.GetArguments()["name"].(string)
+	lockType, _ := req.GetArguments()["type"].(string)
+	repository, _ := req.GetArguments()["repository"].(string)
+	if lockType == "" {
+		lockType = "package"
+	}
+	return strings.TrimSpace(name), lockType, repository
+}
+
+func addLockToolsToMCPServer() {
+	mcpToolList := mcp.NewTool("zypper_list_locks",
+		mcp.WithDescription("List the package locks with the number of packages each matches. Locks added with zypper_add_lock carry their reason and MCP session."),
+		mcp.WithBoolean("own_only", mcp.Description("Only the locks added by this server")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ownOnly, _ := req.GetArguments()["own_only"].(bool)
+		locks, err := ListLocks(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if ownOnly {
+			var own []LockInfo
+			for _, lock := range locks {
+				if lock.AddedByServer {
+					own = append(own, lock)
+				}
+			}
+			locks = own
+		}
+		return utils.JSONToolResult(map[string]any{"locks": locks})
+	})
+	utils.RecordRegisteredTool("zypper_list_locks")
+
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		for _, tool := range []string{"zypper_add_lock", "zypper_remove_lock", "zypper_cleanup_locks"} {
+			utils.RecordSkippedTool(tool, zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		}
+		return
+	}
+
+	typeDescription := "Kind of the lock: " + strings.Join(lockTypes, ", ") + "; default package"
+	mcpToolAdd := mcp.NewTool("zypper_add_lock",
+		mcp.WithDescription("Lock packages against installation, update and removal, by name, glob (e.g. kernel-*) or capability. The reason is recorded by the server."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Package name, glob or capability")),
+		mcp.WithString("type", mcp.Description(typeDescription)),
+		mcp.WithString("repository", mcp.Description("Only lock packages of this repository alias")),
+		mcp.WithString("reason", mcp.Description("Why the lock is needed, shown by zypper_list_locks")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolAdd, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, lockType, repository := lockArguments(req)
+		reason, _ := req.GetArguments()["reason"].(string)
+		lock, err := AddLock(ctx, name, lockType, repository, reason)
+		if lock == nil {
+			utils.MarkCallUnchanged(ctx)
+		}
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(map[string]any{"added": lock})
+	})
+	utils.RecordRegisteredTool("zypper_add_lock")
+	utils.MarkToolMutating("zypper_add_lock", zypperCmd.Executable)
+
+	mcpToolRemove := mcp.NewTool("zypper_remove_lock",
+		mcp.WithDescription("Remove a package lock, given exactly as listed by zypper_list_locks."),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Name, glob or capability of the lock")),
+		mcp.WithString("type", mcp.Description(typeDescription)),
+		mcp.WithString("repository", mcp.Description("Repository alias of the lock")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolRemove, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, lockType, repository := lockArguments(req)
+		lock, err := RemoveLock(ctx, name, lockType, repository)
+		if err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(map[string]any{"removed": lock})
+	})
+	utils.RecordRegisteredTool("zypper_remove_lock")
+	utils.MarkToolMutating("zypper_remove_lock", zypperCmd.Executable)
+
+	mcpToolCleanup := mcp.NewTool("zypper_cleanup_locks",
+		mcp.WithDescription("Remove the locks added by this server, all of them or only those of the current MCP session. Locks added otherwise are kept."),
+		mcp.WithBoolean("session_only", mcp.Description("Only the locks added in the current MCP session")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolCleanup, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sessionOnly, _ := req.GetArguments()["session_only"].(bool)
+		session := utils.SessionIDFromContext(ctx)
+		locks, err := ListLocks(ctx)
+		if err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		removed := []LockInfo{}
+		var failed []string
+		for _, lock := range locks {
+			if !lock.AddedByServer || (sessionOnly && lock.Session != session) {
+				continue
+			}
+			if _, err := RemoveLock(ctx, lock.Name, lock.Type, lock.Repository); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", lock.Name, err))
+				continue
+			}
+			removed = append(removed, lock)
+		}
+		if len(removed) == 0 {
+			utils.MarkCallUnchanged(ctx)
+		}
+		return utils.JSONToolResult(map[string]any{"removed": removed, "errors": failed})
+	})
+	utils.RecordRegisteredTool("zypper_cleanup_locks")
+	utils.MarkToolMutating("zypper_cleanup_locks", zypperCmd.Executable)
+}
@@ -0,0 +1,49 @@
+package zypper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+)
+
+func TestServerLockRecords(t *testing.T) {
+	saved := utils.AdminTasksConfig
+	defer func() { utils.AdminTasksConfig = saved }()
+	utils.AdminTasksConfig.StateDir = filepath.Join(t.TempDir(), "state")
+
+	if locks, err := readServerLocks(); err != nil || locks != nil {
+		t.Fatalf("without state file: %v, %v", locks, err)
+	}
+	created := time.Date(2024, 10, 18, 12, 0, 0, 0, time.UTC)
+	records := []ServerLock{
+		{Name: "kernel-default", Type: "package", Reason: "keep the kernel until the maintenance window", Session: "stdio", Created: created},
+		{Name: "openSUSE-SLE-15.6-2024-1234", Type: "patch", Session: "stdio", Created: created},
+		{Name: "kernel-default", Type: "package", Repository: "repo-backports", Session: "stdio", Created: created},
+	}
+	if err := writeServerLocks(records); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(utils.AdminTasksConfig.StateDir, serverLocksFile))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("state file %v, %v", info, err)
+	}
+
+	// only the record with the same name, type and repository is dropped
+	if err := dropServerLock(ServerLock{Name: "kernel-default", Type: "package"}); err != nil {
+		t.Fatal(err)
+	}
+	locks, err := readServerLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(locks, records[1:]) {
+		t.Errorf("records %+v, want %+v", locks, records[1:])
+	}
+	if err := dropServerLock(ServerLock{Name: "vim", Type: "package"}); err != nil {
+		t.Errorf("dropping an unknown record: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package zypper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Values zypper accepts for --category and --severity
+var patchCategories = []string{"security", "recommended", "optional", "feature", "document", "yast"}
+var patchSeverities = []string{"critical", "important", "moderate", "low", "unspecified"}
+
+var cvePattern = regexp.MustCompile(`^CVE-[0-9]{4}-[0-9]{4,}$`)
+var bugzillaPattern = regexp.MustCompile(`^[0-9]+$`)
+
+type zypperIssue struct {
+	Type  string `xml:"type,attr"`
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title"`
+}
+
+type zypperPatch struct {
+	Kind        string `xml:"kind,attr"`
+	Name        string `xml:"name,attr"`
+	Edition     string `xml:"edition,attr"`
+	Arch        string `xml:"arch,attr"`
+	Status      string `xml:"status,attr"`
+	Category    string `xml:"category,attr"`
+	Severity    string `xml:"severity,attr"`
+	PkgManager  string `xml:"pkgmanager,attr"`
+	Restart     string `xml:"restart,attr"`
+	Interactive string `xml:"interactive,attr"`
+	Summary     string `xml:"summary"`
+	Source      struct {
+		Alias string `xml:"alias,attr"`
+	} `xml:"source"`
+	IssueDate struct {
+		Time int64 `xml:"time,attr"`
+	} `xml:"issue-date"`
+	Issues []zypperIssue `xml:"issue-list>issue"`
+}
+
+type zypperPatchStatus struct {
+	Patches []zypperPatch `xml:"update-status>update-list>update"`
+	Blocked []zypperPatch `xml:"update-status>blocked-update-list>update"`
+}
+
+type Patch struct {
+	Name            string    `json:"name"`
+	Edition         string    `json:"edition,omitempty"`
+	Status          string    `json:"status"`
+	Category        string    `json:"category"`
+	Severity        string    `json:"severity"`
+	Summary         string    `json:"summary,omitempty"`
+	Repository      string    `json:"repository,omitempty"`
+	IssueDate       time.Time `json:"issue_date,omitempty"`
+	CVEs            []string  `json:"cves,omitempty"`
+	Bugzillas       []string  `json:"bugzillas,omitempty"`
+	RebootSuggested bool      `json:"reboot_suggested,omitempty"`
+	// the patch updates zypper itself, the patch run has to be repeated
+	PackageManager bool `json:"package_manager,omitempty"`
+	Interactive    bool `json:"interactive,omitempty"`
+	Blocked        bool `json:"blocked,omitempty"`
+}
+
+// PatchFilter selects patches like the options of zypper list-patches
+// and zypper patch.
+type PatchFilter struct {
+	Categories []string `json:"categories,omitempty"`
+	Severities []string `json:"severities,omitempty"`
+	CVEs       []string `json:"cves,omitempty"`
+	Bugzillas  []string `json:"bugzillas,omitempty"`
+}
+
+type PatchInstallResult struct {
+	Filter  PatchFilter `json:"filter"`
+	DryRun  bool        `json:"dry_run"`
+	Success bool        `json:"success"`
+	Patches []Patch     `json:"patches"`
+	// zypper patch does not install blocked patches
+	Blocked        []Patch  `json:"blocked,omitempty"`
+	FixedCVEs      []string `json:"fixed_cves"`
+	RemainingCVEs  []string `json:"remaining_cves,omitempty"`
+	RebootNeeded   bool     `json:"reboot_needed"`
+	RestartNeeded  bool     `json:"restart_needed"`
+	RestartMessage string   `json:"restart_message,omitempty"`
+	ExitCode       int      `json:"exit_code"`
+	Output         string   `json:"output,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+func validateFilterValues(name string, values []string, allowed []string, pattern *regexp.Regexp) error {
+	for _, value := range values {
+		if pattern != nil {
+			if !pattern.MatchString(value) {
+				return fmt.Errorf("invalid %s %q", name, value)
+			}
+			continue
+		}
+		valid := false
+		for _, allowedValue := range allowed {
+			if value == allowedValue {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid %s %q, use one of %s", name, value, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// patchFilterFromArguments reads and validates the filter arguments of
+// the patch tools. Bugzilla IDs like bsc#1234567 are accepted as well.
+func patchFilterFromArguments(arguments map[string]any) (PatchFilter, error) {
+	list := func(name string) []string {
+		var values []string
+		items, _ := arguments[name].([]any)
+		for _, item := range items {
+			if value, ok := item.(string); ok && strings.TrimSpace(value) != "" {
+				values = append(values, strings.TrimSpace(value))
+			}
+		}
+		return values
+	}
+	filter := PatchFilter{
+		Categories: list("categories"),
+		Severities: list("severities"),
+		CVEs:       list("cves"),
+	}
+	for i := range filter.Categories {
+		filter.Categories[i] = strings.ToLower(filter.Categories[i])
+	}
+	for i := range filter.Severities {
+		filter.Severities[i] = strings.ToLower(filter.Severities[i])
+	}
+	for i := range filter.CVEs {
+		filter.CVEs[i] = strings.ToUpper(filter.CVEs[i])
+	}
+	for _, bugzilla := range list("bugzillas") {
+		if _, id, found := strings.Cut(bugzilla, "#"); found {
+			bugzilla = id
+		}
+		filter.Bugzillas = append(filter.Bugzillas, bugzilla)
+	}
+	if err := validateFilterValues("category", filter.Categories, patchCategories, nil); err != nil {
+		return filter, err
+	}
+	if err := validateFilterValues("severity", filter.Severities, patchSeverities, nil); err != nil {
+		return filter, err
+	}
+	if err := validateFilterValues("CVE", filter.CVEs, nil, cvePattern); err != nil {
+		return filter, err
+	}
+	if err := validateFilterValues("bugzilla ID", filter.Bugzillas, nil, bugzillaPattern); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parameters returns the filter as options of list-patches and patch.
+func (filter PatchFilter) parameters() []string {
+	var params []string
+	for _, category := range filter.Categories {
+		params = append(params, "--category", category)
+	}
+	for _, severity := range filter.Severities {
+		params = append(params, "--severity", severity)
+	}
+	for _, cve := range filter.CVEs {
+		params = append(params, "--cve="+cve)
+	}
+	for _, bugzilla := range filter.Bugzillas {
+		params = append(params, "--bugzilla="+bugzilla)
+	}
+	return params
+}
+
+func patchFromXML(zypperPatch zypperPatch, blocked bool) Patch {
+	patch := Patch{
+		Name:            zypperPatch.Name,
+		Edition:         zypperPatch.Edition,
+		Status:          zypperPatch.Status,
+		Category:        zypperPatch.Category,
+		Severity:        zypperPatch.Severity,
+		Summary:         strings.TrimSpace(zypperPatch.Summary),
+		Repository:      zypperPatch.Source.Alias,
+		RebootSuggested: zypperBool(zypperPatch.Restart),
+		PackageManager:  zypperBool(zypperPatch.PkgManager),
+		Interactive:     zypperBool(zypperPatch.Interactive),
+		Blocked:         blocked,
+	}
+	if zypperPatch.IssueDate.Time > 0 {
+		patch.IssueDate = time.Unix(zypperPatch.IssueDate.Time, 0).UTC()
+	}
+	for _, issue := range zypperPatch.Issues {
+		switch issue.Type {
+		case "cve":
+			patch.CVEs = append(patch.CVEs, issue.ID)
+		case "bugzilla":
+			patch.Bugzillas = append(patch.Bugzillas, issue.ID)
+		}
+	}
+	return patch
+}
+
+// listPatches returns the patches matching filter, only the needed ones
+// unless all is set.
+func listPatches(ctx context.Context, filter PatchFilter, all bool) ([]Patch, error) {
+	params := filter.parameters()
+	if all {
+		params = append(params, "--all")
+	}
+	result, err := zypperBackend{}.run(ctx, false, "list-patches", params...)
+	if err != nil {
+		return nil, err
+	}
+	patches := []Patch{}
+	if result.ExitCode != zypperExitOK && result.ExitCode != zypperExitInfUpdateNeed && result.ExitCode != zypperExitInfSecUpdate {
+		return nil, fmt.Errorf("zypper list-patches failed: %s", strings.TrimSpace(zypperMessagesText(result.Stdout, "error")+"\n"+result.Stderr))
+	}
+	var status zypperPatchStatus
+	if err := decodeZypperXML(result.Stdout, &status); err != nil {
+		return nil, err
+	}
+	for _, zypperPatch := range status.Patches {
+		if zypperPatch.Kind != "" && zypperPatch.Kind != "patch" {
+			continue
+		}
+		if !all && zypperPatch.Status != "" && zypperPatch.Status != "needed" {
+			continue
+		}
+		patches = append(patches, patchFromXML(zypperPatch, false))
+	}
+	for _, zypperPatch := range status.Blocked {
+		patches = append(patches, patchFromXML(zypperPatch, true))
+	}
+	return patches, nil
+}
+
+func patchCVEs(patches []Patch) []string {
+	seen := make(map[string]bool)
+	cves := []string{}
+	for _, patch := range patches {
+		for _, cve := range patch.CVEs {
+			if !seen[cve] {
+				seen[cve] = true
+				cves = append(cves, cve)
+			}
+		}
+	}
+	sort.Strings(cves)
+	return cves
+}
+
+// InstallPatches installs exactly the needed patches matching filter and
+// reports the CVEs which are fixed afterwards.
+func InstallPatches(ctx context.Context, filter PatchFilter, dryRun bool) (PatchInstallResult, error) {
+	installResult := PatchInstallResult{Filter: filter, DryRun: dryRun, Patches: []Patch{}, FixedCVEs: []string{}}
+	needed, err := listPatches(ctx, filter, false)
+	if err != nil {
+		return installResult, err
+	}
+	var before []Patch
+	for _, patch := range needed {
+		if patch.Blocked {
+			installResult.Blocked = append(installResult.Blocked, patch)
+		} else {
+			before = append(before, patch)
+		}
+	}
+	installResult.Patches = append(installResult.Patches, before...)
+	if len(before) == 0 {
+		installResult.Success = true
+		return installResult, nil
+	}
+	params := append(filter.parameters(), "--skip-interactive", "--auto-agree-with-licenses")
+	if dryRun {
+		params = append(params, "--dry-run")
+	}
+	result, err := zypperBackend{}.run(ctx, true, "patch", params...)
+	if err != nil {
+		return installResult, err
+	}
+	installResult.ExitCode = result.ExitCode
+	installResult.Output = zypperMessagesText(result.Stdout)
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfUpdateNeed, zypperExitInfSecUpdate, zypperExitInfRebootNeed, zypperExitInfReposSkip:
+		installResult.Success = true
+	case zypperExitInfRestartNeed:
+		installResult.Success = true
+		installResult.RestartNeeded = true
+		installResult.RestartMessage = "the package manager was updated, run zypper_install_patches again for the remaining patches"
+	default:
+		installResult.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+	}
+	installResult.RebootNeeded = result.ExitCode == zypperExitInfRebootNeed
+	if dryRun || !installResult.Success {
+		return installResult, nil
+	}
+	for _, patch := range before {
+		if patch.RebootSuggested {
+			installResult.RebootNeeded = true
+		}
+	}
+	after, err := listPatches(ctx, filter, false)
+	if err != nil {
+		return installResult, err
+	}
+	remaining := make(map[string]bool)
+	for _, cve := range patchCVEs(after) {
+		remaining[cve] = true
+	}
+	// CVEs of blocked patches remain as well
+	for _, cve := range patchCVEs(needed) {
+		if remaining[cve] {
+			installResult.RemainingCVEs = append(installResult.RemainingCVEs, cve)
+		} else {
+			installResult.FixedCVEs = append(installResult.FixedCVEs, cve)
+		}
+	}
+	return installResult, nil
+}
+
+func patchFilterOptions() []mcp.ToolOption {
+	stringItems := mcp.Items(map[string]any{"type": "string"})
+	return []mcp.ToolOption{
+		mcp.WithArray("categories", mcp.Description("Only patches of these categories: "+strings.Join(patchCategories, ", ")), stringItems),
+		mcp.WithArray("severities", mcp.Description("Only patches of these severities: "+strings.Join(patchSeverities, ", ")), stringItems),
+		mcp.WithArray("cves", mcp.Description("Only patches fixing these CVEs, e.g. CVE-2024-1234"), stringItems),
+		mcp.WithArray("bugzillas", mcp.Description("Only patches fixing these bugzilla IDs, e.g. 1234567 or bsc#1234567"), stringItems),
+	}
+}
+
+func addPatchToolsToMCPServer() {
+	listOptions := append([]mcp.ToolOption{
+		mcp.WithDescription("List the needed patches with category, severity, CVEs and bugzilla IDs, filtered like zypper list-patches. Filters of different kinds are combined, e.g. categories [security] and severities [critical] for the critical security patches."),
+		mcp.WithBoolean("all", mcp.Description("Also list patches which are already installed or not applicable")),
+	}, patchFilterOptions()...)
+	utils.AdminTasksMCPServer.AddTool(mcp.NewTool("zypper_list_needed_patches", listOptions...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filter, err := patchFilterFromArguments(req.GetArguments())
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		all, _ := req.GetArguments()["all"].(bool)
+		patches, err := listPatches(ctx, filter, all)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		bySeverity := make(map[string]int)
+		for _, patch := range patches {
+			bySeverity[patch.Severity]++
+		}
+		return utils.JSONToolResult(map[string]any{
+			"filter":      filter,
+			"patches":     patches,
+			"count":       len(patches),
+			"by_severity": bySeverity,
+			"cves":        patchCVEs(patches),
+		})
+	})
+	utils.RecordRegisteredTool("zypper_list_needed_patches")
+
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("zypper_install_patches", zypperCmd.Executable, "read-only root file system, use transactional_update_patch")
+		return
+	}
+	installOptions := append([]mcp.ToolOption{
+		mcp.WithDescription("Install exactly the needed patches matching the filter, e.g. categories [security] and severities [critical], and report the fixed CVEs and whether a reboot or another run is needed. Interactive patches are skipped. At least one filter is required."),
+		mcp.WithBoolean("dry_run", mcp.Description("Only show what would be installed")),
+	}, patchFilterOptions()...)
+	utils.AdminTasksMCPServer.AddTool(mcp.NewTool("zypper_install_patches", installOptions...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filter, err := patchFilterFromArguments(req.GetArguments())
+		if err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if len(filter.parameters()) == 0 {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError("at least one of categories, severities, cves or bugzillas is required"), nil
+		}
+		dryRun, _ := req.GetArguments()["dry_run"].(bool)
+		result, err := InstallPatches(ctx, filter, dryRun)
+		if dryRun || len(result.Patches) == 0 {
+			// zypper patch did not run, or only with --dry-run
+			utils.MarkCallUnchanged(ctx)
+		}
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(result)
+	})
+	utils.RecordRegisteredTool("zypper_install_patches")
+	utils.MarkToolMutating("zypper_install_patches", zypperCmd.Executable)
+}
@@ -0,0 +1,337 @@
+package zypper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mcp-server-admintasks/pkg/pkgmgr"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Actions of zypper_transaction_plan and their zypper subcommands
+var planActions = map[string]string{
+	"install":      "install",
+	"remove":       "remove",
+	"update":       "update",
+	"patch":        "patch",
+	"dist-upgrade": "dist-upgrade",
+}
+
+type summarySolvable struct {
+	Type       string `xml:"type,attr"`
+	Name       string `xml:"name,attr"`
+	Edition    string `xml:"edition,attr"`
+	EditionOld string `xml:"edition-old,attr"`
+	Arch       string `xml:"arch,attr"`
+	ArchOld    string `xml:"arch-old,attr"`
+	Vendor     string `xml:"vendor,attr"`
+	VendorOld  string `xml:"vendor-old,attr"`
+	Repository string `xml:"repository,attr"`
+}
+
+type zypperInstallSummary struct {
+	DownloadSize   int64             `xml:"download-size,attr"`
+	SpaceUsageDiff int64             `xml:"space-usage-diff,attr"`
+	Install        []summarySolvable `xml:"to-install>solvable"`
+	Reinstall      []summarySolvable `xml:"to-reinstall>solvable"`
+	Upgrade        []summarySolvable `xml:"to-upgrade>solvable"`
+	Downgrade      []summarySolvable `xml:"to-downgrade>solvable"`
+	ChangeArch     []summarySolvable `xml:"to-change-arch>solvable"`
+	ChangeVendor   []summarySolvable `xml:"to-change-vendor>solvable"`
+	Remove         []summarySolvable `xml:"to-remove>solvable"`
+	NeedReboot     []summarySolvable `xml:"need-reboot>solvable"`
+}
+
+type zypperSolution struct {
+	Description string `xml:"description"`
+	Details     string `xml:"details"`
+}
+
+type zypperSolverProblem struct {
+	Description string           `xml:"description"`
+	Details     string           `xml:"details"`
+	Solutions   []zypperSolution `xml:"solutions>solution"`
+}
+
+type zypperTransactionOutput struct {
+	Summary  *zypperInstallSummary `xml:"install-summary"`
+	Problems []zypperSolverProblem `xml:"solver-problems>solver-problem"`
+}
+
+type PlanPackage struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind,omitempty"`
+	Version    string `json:"version,omitempty"`
+	OldVersion string `json:"old_version,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	OldArch    string `json:"old_arch,omitempty"`
+	Vendor     string `json:"vendor,omitempty"`
+	OldVendor  string `json:"old_vendor,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+type SolverSolution struct {
+	Number      int    `json:"number"`
+	Description string `json:"description"`
+	Details     string `json:"details,omitempty"`
+}
+
+type SolverProblem struct {
+	Number      int              `json:"number"`
+	Description string           `json:"description"`
+	Details     string           `json:"details,omitempty"`
+	Solutions   []SolverSolution `json:"solutions"`
+}
+
+// TransactionPlan is what the solver would do, as reported by a dry-run.
+type TransactionPlan struct {
+	Action             string          `json:"action"`
+	Packages           []string        `json:"packages,omitempty"`
+	Feasible           bool            `json:"feasible"`
+	Install            []PlanPackage   `json:"install"`
+	Upgrade            []PlanPackage   `json:"upgrade"`
+	Downgrade          []PlanPackage   `json:"downgrade"`
+	Reinstall          []PlanPackage   `json:"reinstall"`
+	Remove             []PlanPackage   `json:"remove"`
+	ChangeArch         []PlanPackage   `json:"change_arch"`
+	ChangeVendor       []PlanPackage   `json:"change_vendor"`
+	DownloadSize       int64           `json:"download_size"`
+	InstalledSizeDelta int64           `json:"installed_size_delta"`
+	RebootRequired     bool            `json:"reboot_required"`
+	RebootPackages     []string        `json:"reboot_packages,omitempty"`
+	Problems           []SolverProblem `json:"problems,omitempty"`
+	Summary            string          `json:"summary"`
+	ExitCode           int             `json:"exit_code"`
+	Error              string          `json:"error,omitempty"`
+}
+
+// rebootRequiredPackage is true for packages which only take effect
+// after a reboot: kernels, microcode, glibc and systemd.
+func rebootRequiredPackage(name string) bool {
+	switch name {
+	case "glibc", "systemd", "ucode-intel", "ucode-amd":
+		return true
+	}
+	if !strings.HasPrefix(name, "kernel-") || strings.HasPrefix(name, "kernel-firmware") {
+		return false
+	}
+	for _, suffix := range []string{"-devel", "-source", "-macros", "-docs", "-syms"} {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func planPackages(solvables []summarySolvable) []PlanPackage {
+	packages := []PlanPackage{}
+	for _, solvable := range solvables {
+		packages = append(packages, PlanPackage{
+			Name:       solvable.Name,
+			Kind:       solvable.Type,
+			Version:    solvable.Edition,
+			OldVersion: solvable.EditionOld,
+			Arch:       solvable.Arch,
+			OldArch:    solvable.ArchOld,
+			Vendor:     solvable.Vendor,
+			OldVendor:  solvable.VendorOld,
+			Repository: solvable.Repository,
+		})
+	}
+	return packages
+}
+
+func solverProblems(problems []zypperSolverProblem) []SolverProblem {
+	var solverProblems []SolverProblem
+	for i, problem := range problems {
+		solverProblem := SolverProblem{
+			Number:      i + 1,
+			Description: strings.TrimSpace(problem.Description),
+			Details:     strings.TrimSpace(problem.Details),
+			Solutions:   []SolverSolution{},
+		}
+		for j, solution := range problem.Solutions {
+			solverProblem.Solutions = append(solverProblem.Solutions, SolverSolution{
+				Number:      j + 1,
+				Description: strings.TrimSpace(solution.Description),
+				Details:     strings.TrimSpace(solution.Details),
+			})
+		}
+		solverProblems = append(solverProblems, solverProblem)
+	}
+	return solverProblems
+}
+
+func (plan *TransactionPlan) summarize() {
+	if len(plan.Problems) > 0 {
+		plan.Summary = fmt.Sprintf("%d solver problem(s), nothing would be changed until they are resolved", len(plan.Problems))
+		return
+	}
+	if !plan.Feasible {
+		plan.Summary = "the transaction is not possible: " + plan.Error
+		return
+	}
+	var parts []string
+	for _, part := range []struct {
+		count int
+		text  string
+	}{
+		{len(plan.Install), "new"},
+		{len(plan.Upgrade), "upgraded"},
+		{len(plan.Downgrade), "downgraded"},
+		{len(plan.Reinstall), "reinstalled"},
+		{len(plan.Remove), "removed"},
+		{len(plan.ChangeVendor), "with vendor change"},
+		{len(plan.ChangeArch), "with architecture change"},
+	} {
+		if part.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", part.count, part.text))
+		}
+	}
+	if len(parts) == 0 {
+		plan.Summary = "nothing to do"
+		return
+	}
+	plan.Summary = fmt.Sprintf("%s; %s to download, installed size changes by %s",
+		strings.Join(parts, ", "), formatBytes(plan.DownloadSize), formatBytes(plan.InstalledSizeDelta))
+	if plan.RebootRequired {
+		plan.Summary += "; reboot required"
+	}
+}
+
+func formatBytes(size int64) string {
+	sign := ""
+	if size < 0 {
+		sign = "-"
+		size = -size
+	}
+	value := float64(size)
+	for _, unit := range []string{"B", "KiB", "MiB", "GiB"} {
+		if value < 1024 || unit == "GiB" {
+			if unit == "B" {
+				return fmt.Sprintf("%s%d B", sign, size)
+			}
+			return fmt.Sprintf("%s%.1f %s", sign, value, unit)
+		}
+		value /= 1024
+	}
+	return ""
+}
+
+// parseTransactionPlan fills plan from the --xmlout output of a dry-run.
+func parseTransactionPlan(plan *TransactionPlan, result utils.CmdResult) error {
+	plan.ExitCode = result.ExitCode
+	var output zypperTransactionOutput
+	if err := decodeZypperXML(result.Stdout, &output); err != nil {
+		return err
+	}
+	plan.Problems = solverProblems(output.Problems)
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfUpdateNeed, zypperExitInfSecUpdate, zypperExitInfRebootNeed, zypperExitInfRestartNeed, zypperExitInfReposSkip:
+		plan.Feasible = len(plan.Problems) == 0
+	default:
+		if len(plan.Problems) == 0 {
+			plan.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+		}
+	}
+	summary := output.Summary
+	if summary == nil {
+		summary = &zypperInstallSummary{}
+	}
+	plan.Install = planPackages(summary.Install)
+	plan.Upgrade = planPackages(summary.Upgrade)
+	plan.Downgrade = planPackages(summary.Downgrade)
+	plan.Reinstall = planPackages(summary.Reinstall)
+	plan.Remove = planPackages(summary.Remove)
+	plan.ChangeArch = planPackages(summary.ChangeArch)
+	plan.ChangeVendor = planPackages(summary.ChangeVendor)
+	plan.DownloadSize = summary.DownloadSize
+	plan.InstalledSizeDelta = summary.SpaceUsageDiff
+	rebootPackages := make(map[string]bool)
+	for _, solvable := range summary.NeedReboot {
+		rebootPackages[solvable.Name] = true
+	}
+	for _, changed := range [][]summarySolvable{summary.Install, summary.Upgrade, summary.Downgrade, summary.Reinstall} {
+		for _, solvable := range changed {
+			if rebootRequiredPackage(solvable.Name) {
+				rebootPackages[solvable.Name] = true
+			}
+		}
+	}
+	for name := range rebootPackages {
+		plan.RebootPackages = append(plan.RebootPackages, name)
+	}
+	sort.Strings(plan.RebootPackages)
+	plan.RebootRequired = len(plan.RebootPackages) > 0 || result.ExitCode == zypperExitInfRebootNeed
+	plan.summarize()
+	return nil
+}
+
+// transactionParams returns the subcommand and parameters of action,
+// after checking the package names.
+func transactionParams(action string, packages []string, extraParams ...string) (string, []string, error) {
+	subcmd, ok := planActions[action]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown action %q", action)
+	}
+	if err := pkgmgr.ValidatePackageNames(packages); err != nil {
+		return "", nil, err
+	}
+	params := append([]string{}, extraParams...)
+	if action == "install" || action == "dist-upgrade" || action == "patch" {
+		params = append(params, "--auto-agree-with-licenses")
+	}
+	return subcmd, append(params, packages...), nil
+}
+
+// PlanTransaction runs the solver for action with --dry-run and returns
+// what would be installed, upgraded, downgraded and removed.
+func PlanTransaction(ctx context.Context, action string, packages []string, extraParams ...string) (TransactionPlan, error) {
+	plan := TransactionPlan{Action: action, Packages: packages}
+	subcmd, params, err := transactionParams(action, packages, append([]string{"--dry-run"}, extraParams...)...)
+	if err != nil {
+		return plan, err
+	}
+	result, err := zypperBackend{}.run(ctx, true, subcmd, params...)
+	if err != nil {
+		return plan, err
+	}
+	if err := parseTransactionPlan(&plan, result); err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+func addPlanToolToMCPServer() {
+	mcpToolPlan := mcp.NewTool("zypper_transaction_plan",
+		mcp.WithDescription("Run the solver for an install, remove, update, patch or dist-upgrade with --dry-run and return the plan: packages to install, upgrade, downgrade and remove, vendor and architecture changes, download size, installed size delta, whether a reboot is required, and solver problems with their proposed solutions. Nothing is changed. Use it before every change of more than a single package."),
+		mcp.WithString("action", mcp.Required(), mcp.Description("One of install, remove, update, patch, dist-upgrade")),
+		mcp.WithArray("packages", mcp.Description("Packages or capabilities for install and remove, optional for update"), mcp.Items(map[string]any{"type": "string"})),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolPlan, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		action, _ := req.GetArguments()["action"].(string)
+		var packages []string
+		items, _ := req.GetArguments()["packages"].([]any)
+		for _, item := range items {
+			if name, ok := item.(string); ok && name != "" {
+				packages = append(packages, name)
+			}
+		}
+		if (action == "install" || action == "remove") && len(packages) == 0 {
+			return mcp.NewToolResultError("packages are required for " + action), nil
+		}
+		if (action == "patch" || action == "dist-upgrade") && len(packages) > 0 {
+			return mcp.NewToolResultError("packages can not be given for " + action), nil
+		}
+		plan, err := PlanTransaction(ctx, action, packages)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(plan)
+	})
+	utils.RecordRegisteredTool("zypper_transaction_plan")
+}
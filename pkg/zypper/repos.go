@@ -0,0 +1,404 @@
+package zypper
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// URL schemes zypper_add_repo accepts without "repo_allowed_schemes"
+var defaultRepoSchemes = []string{"https"}
+
+var repoAliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+var keyFingerprintPattern = regexp.MustCompile(`Key Fingerprint:\s*([0-9A-Fa-f ]{32,})`)
+var keyNamePattern = regexp.MustCompile(`Key Name:\s*([^\n<]+)`)
+
+type RepositoryInfo struct {
+	Alias        string   `json:"alias"`
+	Name         string   `json:"name,omitempty"`
+	Type         string   `json:"type,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Enabled      bool     `json:"enabled"`
+	Autorefresh  bool     `json:"autorefresh"`
+	Priority     int      `json:"priority"`
+	GPGCheck     bool     `json:"gpgcheck"`
+	RepoGPGCheck string   `json:"repo_gpgcheck,omitempty"`
+	PkgGPGCheck  string   `json:"pkg_gpgcheck,omitempty"`
+	GPGKeys      []string `json:"gpgkeys,omitempty"`
+}
+
+type RepositoryKey struct {
+	Fingerprint string `json:"fingerprint"`
+	Name        string `json:"name,omitempty"`
+	Trusted     bool   `json:"trusted"`
+	Imported    bool   `json:"imported"`
+}
+
+type AddRepoResult struct {
+	Repository *RepositoryInfo `json:"repository,omitempty"`
+	Added      bool            `json:"added"`
+	Keys       []RepositoryKey `json:"keys"`
+	Prompts    []PromptAnswer  `json:"prompts,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func gpgCheckState(value string) string {
+	switch value {
+	case "":
+		return ""
+	case "1", "yes", "true":
+		return "on"
+	case "0", "no", "false":
+		return "off"
+	}
+	return value
+}
+
+// ListRepositories returns the configured repositories with their GPG
+// check settings.
+func ListRepositories(ctx context.Context) ([]RepositoryInfo, error) {
+	result, err := zypperBackend{}.run(ctx, false, "repos")
+	if err != nil {
+		return nil, err
+	}
+	var repoList zypperRepoList
+	if err := decodeZypperXML(result.Stdout, &repoList); err != nil {
+		return nil, err
+	}
+	repos := []RepositoryInfo{}
+	for _, repo := range repoList.Repos {
+		info := RepositoryInfo{
+			Alias:        repo.Alias,
+			Name:         repo.Name,
+			Type:         repo.Type,
+			URL:          strings.TrimSpace(repo.URL),
+			Enabled:      zypperBool(repo.Enabled),
+			Autorefresh:  zypperBool(repo.Autorefresh),
+			Priority:     repo.Priority,
+			GPGCheck:     zypperBool(repo.GPGCheck),
+			RepoGPGCheck: gpgCheckState(repo.RepoGPGCheck),
+			PkgGPGCheck:  gpgCheckState(repo.PkgGPGCheck),
+		}
+		for _, key := range repo.GPGKeys {
+			if key = strings.TrimSpace(key); key != "" {
+				info.GPGKeys = append(info.GPGKeys, key)
+			}
+		}
+		repos = append(repos, info)
+	}
+	return repos, nil
+}
+
+func findRepository(ctx context.Context, alias string) (*RepositoryInfo, error) {
+	repos, err := ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		if repo.Alias == alias {
+			return &repo, nil
+		}
+	}
+	return nil, nil
+}
+
+func validateAlias(alias string) error {
+	if !repoAliasPattern.MatchString(alias) {
+		return fmt.Errorf("invalid repository alias %q", alias)
+	}
+	return nil
+}
+
+// validateRepoURL checks rawURL against the allowed schemes and hosts of
+// the configuration. Without "repo_allowed_hosts" no host is allowed.
+// Query strings are rejected, zypp reads options like ssl_verify, proxy
+// or credentials from them.
+func validateRepoURL(rawURL string) error {
+	repoURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if repoURL.User != nil {
+		return fmt.Errorf("credentials in the repository URL are not accepted")
+	}
+	if repoURL.RawQuery != "" || repoURL.ForceQuery || repoURL.Fragment != "" {
+		return fmt.Errorf("query strings and fragments in the repository URL are not accepted")
+	}
+	schemes := defaultRepoSchemes
+	if utils.AdminTasksConfig.RepoAllowedSchemes != nil {
+		schemes = utils.AdminTasksConfig.RepoAllowedSchemes
+	}
+	schemeAllowed := false
+	for _, scheme := range schemes {
+		schemeAllowed = schemeAllowed || strings.EqualFold(scheme, repoURL.Scheme)
+	}
+	if !schemeAllowed {
+		return fmt.Errorf("URL scheme %q is not allowed, allowed are: %s", repoURL.Scheme, strings.Join(schemes, ", "))
+	}
+	if len(utils.AdminTasksConfig.RepoAllowedHosts) == 0 {
+		return fmt.Errorf("no repository hosts are allowed, configure repo_allowed_hosts in %s", utils.ServerConfigPath)
+	}
+	host := strings.ToLower(repoURL.Hostname())
+	for _, pattern := range utils.AdminTasksConfig.RepoAllowedHosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not in repo_allowed_hosts", host)
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimPrefix(strings.TrimSpace(fingerprint), "0x")
+	return strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+}
+
+func trustedFingerprint(fingerprint string) bool {
+	for _, trusted := range utils.AdminTasksConfig.TrustedGPGFingerprints {
+		if normalizeFingerprint(trusted) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPromptAnswer imports keys offered by zypper only if their
+// fingerprint is configured as trusted, everything else is rejected.
+func keyPromptAnswer(addResult *AddRepoResult) func(prompt zypperPrompt, output string) string {
+	return func(prompt zypperPrompt, output string) string {
+		reply := PromptAnswer{Prompt: strings.TrimSpace(prompt.Text)}
+		defer func() { addResult.Prompts = append(addResult.Prompts, reply) }()
+		match := keyFingerprintPattern.FindStringSubmatch(output)
+		if !strings.Contains(strings.ToLower(prompt.Text), "trust") || match == nil {
+			reply.Answer = cancelOption(prompt)
+			reply.Comment = "unexpected prompt, cancelled"
+			return reply.Answer
+		}
+		key := RepositoryKey{Fingerprint: normalizeFingerprint(match[1])}
+		if name := keyNamePattern.FindStringSubmatch(output); name != nil {
+			key.Name = html.UnescapeString(strings.TrimSpace(name[1]))
+		}
+		key.Trusted = trustedFingerprint(key.Fingerprint)
+		key.Imported = key.Trusted
+		addResult.Keys = append(addResult.Keys, key)
+		if key.Trusted {
+			reply.Answer = "a"
+			reply.Comment = "fingerprint " + key.Fingerprint + " is trusted, key imported"
+		} else {
+			reply.Answer = "r"
+			reply.Comment = "fingerprint " + key.Fingerprint + " is not in trusted_gpg_fingerprints, key rejected"
+		}
+		return reply.Answer
+	}
+}
+
+// AddRepository adds a repository with strict GPG checking and refreshes
+// it. Its signing key is only imported if the fingerprint is trusted,
+// otherwise the repository is removed again.
+func AddRepository(ctx context.Context, rawURL string, alias string, name string, priority int, autorefresh bool) (AddRepoResult, error) {
+	addResult := AddRepoResult{Keys: []RepositoryKey{}}
+	if err := validateAlias(alias); err != nil {
+		return addResult, err
+	}
+	if err := validateRepoURL(rawURL); err != nil {
+		return addResult, err
+	}
+	existing, err := findRepository(ctx, alias)
+	if err != nil {
+		return addResult, err
+	}
+	if existing != nil {
+		return addResult, fmt.Errorf("a repository %s exists already", alias)
+	}
+	params := []string{"--no-check", "--gpgcheck-strict"}
+	if name != "" {
+		params = append(params, "--name", name)
+	}
+	if priority > 0 {
+		params = append(params, "--priority", strconv.Itoa(priority))
+	}
+	if autorefresh {
+		params = append(params, "--refresh")
+	}
+	params = append(params, rawURL, alias)
+	result, err := zypperBackend{}.run(ctx, true, "addrepo", params...)
+	if err != nil {
+		return addResult, err
+	}
+	if result.ExitCode != zypperExitOK {
+		addResult.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+		return addResult, nil
+	}
+	addResult.Added = true
+	cmd, err := utils.NewSystemCmdContext(ctx, interactiveZypperCmd(), true, "refresh", alias)
+	if err != nil {
+		return addResult, err
+	}
+	result, err = runInteractive(cmd, keyPromptAnswer(&addResult))
+	if err != nil {
+		return addResult, err
+	}
+	if result.ExitCode != zypperExitOK {
+		addResult.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+		for _, key := range addResult.Keys {
+			if !key.Trusted {
+				addResult.Error = fmt.Sprintf("the signing key %s (%s) is not trusted; add its fingerprint to trusted_gpg_fingerprints in %s after verifying it", key.Fingerprint, key.Name, utils.ServerConfigPath)
+			}
+		}
+		result, err = zypperBackend{}.run(ctx, true, "removerepo", alias)
+		if err == nil && result.ExitCode == zypperExitOK {
+			addResult.Added = false
+		}
+		return addResult, nil
+	}
+	addResult.Repository, err = findRepository(ctx, alias)
+	return addResult, err
+}
+
+func addRepoToolsToMCPServer() {
+	mcpToolList := mcp.NewTool("zypper_list_repos",
+		mcp.WithDescription("List the configured repositories with URL, priority, enabled and autorefresh state and GPG check settings."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repos, err := ListRepositories(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(map[string]any{"repositories": repos})
+	})
+	utils.RecordRegisteredTool("zypper_list_repos")
+
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		for _, tool := range []string{"zypper_add_repo", "zypper_modify_repo", "zypper_remove_repo"} {
+			utils.RecordSkippedTool(tool, zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		}
+		return
+	}
+
+	mcpToolAdd := mcp.NewTool("zypper_add_repo",
+		mcp.WithDescription("Add a repository with strict GPG checking and refresh it. The URL has to match the allowed schemes and hosts of the server configuration. The fingerprint of a new signing key is shown, and the key is only imported if the fingerprint is configured as trusted; otherwise the repository is removed again."),
+		mcp.WithString("url", mcp.Required(), mcp.Description("URL of the repository")),
+		mcp.WithString("alias", mcp.Required(), mcp.Description("Unique alias of the repository")),
+		mcp.WithString("name", mcp.Description("Descriptive name")),
+		mcp.WithNumber("priority", mcp.Description("Priority, 1 is the highest, default 99")),
+		mcp.WithBoolean("autorefresh", mcp.Description("Refresh the repository automatically")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolAdd, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		rawURL, _ := req.GetArguments()["url"].(string)
+		alias, _ := req.GetArguments()["alias"].(string)
+		name, _ := req.GetArguments()["name"].(string)
+		priority, _ := req.GetArguments()["priority"].(float64)
+		autorefresh, _ := req.GetArguments()["autorefresh"].(bool)
+		addResult, err := AddRepository(ctx, rawURL, alias, name, int(priority), autorefresh)
+		if !addResult.Added {
+			// rejected, or removed again for an untrusted key
+			utils.MarkCallUnchanged(ctx)
+		}
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(addResult)
+	})
+	utils.RecordRegisteredTool("zypper_add_repo")
+	utils.MarkToolMutating("zypper_add_repo", zypperCmd.Executable)
+
+	mcpToolModify := mcp.NewTool("zypper_modify_repo",
+		mcp.WithDescription("Change the priority of a repository, enable or disable it, or switch autorefresh on or off."),
+		mcp.WithString("alias", mcp.Required(), mcp.Description("Alias of the repository")),
+		mcp.WithNumber("priority", mcp.Description("New priority, 1 is the highest")),
+		mcp.WithBoolean("enabled", mcp.Description("Enable or disable the repository")),
+		mcp.WithBoolean("autorefresh", mcp.Description("Switch autorefresh on or off")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolModify, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alias, _ := req.GetArguments()["alias"].(string)
+		if err := validateAlias(alias); err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		var params []string
+		if priority, ok := req.GetArguments()["priority"].(float64); ok {
+			if priority < 1 || priority > 200 {
+				utils.MarkCallUnchanged(ctx)
+				return mcp.NewToolResultError("priority has to be between 1 and 200"), nil
+			}
+			params = append(params, "--priority", strconv.Itoa(int(priority)))
+		}
+		if enabled, ok := req.GetArguments()["enabled"].(bool); ok && enabled {
+			params = append(params, "--enable")
+		} else if ok {
+			params = append(params, "--disable")
+		}
+		if autorefresh, ok := req.GetArguments()["autorefresh"].(bool); ok && autorefresh {
+			params = append(params, "--refresh")
+		} else if ok {
+			params = append(params, "--no-refresh")
+		}
+		if len(params) == 0 {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError("nothing to change, give priority, enabled or autorefresh"), nil
+		}
+		existing, err := findRepository(ctx, alias)
+		if err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if existing == nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError("no repository " + alias), nil
+		}
+		result, err := zypperBackend{}.run(ctx, true, "modifyrepo", append(params, alias)...)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if result.ExitCode != zypperExitOK {
+			return mcp.NewToolResultError(strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)), nil
+		}
+		repo, err := findRepository(ctx, alias)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(map[string]any{"before": existing, "after": repo})
+	})
+	utils.RecordRegisteredTool("zypper_modify_repo")
+	utils.MarkToolMutating("zypper_modify_repo", zypperCmd.Executable)
+
+	mcpToolRemove := mcp.NewTool("zypper_remove_repo",
+		mcp.WithDescription("Remove a repository by its alias."),
+		mcp.WithString("alias", mcp.Required(), mcp.Description("Alias of the repository")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolRemove, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		alias, _ := req.GetArguments()["alias"].(string)
+		if err := validateAlias(alias); err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		// removerepo takes numbers and URLs as well, so only known aliases
+		existing, err := findRepository(ctx, alias)
+		if err != nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if existing == nil {
+			utils.MarkCallUnchanged(ctx)
+			return mcp.NewToolResultError("no repository " + alias), nil
+		}
+		result, err := zypperBackend{}.run(ctx, true, "removerepo", alias)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if result.ExitCode != zypperExitOK {
+			return mcp.NewToolResultError(strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)), nil
+		}
+		return utils.JSONToolResult(map[string]any{"removed": existing})
+	})
+	utils.RecordRegisteredTool("zypper_remove_repo")
+	utils.MarkToolMutating("zypper_remove_repo", zypperCmd.Executable)
+}
@@ -0,0 +1,309 @@
+package zypper
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// IDs of the zypper prompts answered by problemResolver, see PromptId in
+// zypper's src/output/prompt.h. All other prompts are cancelled.
+const (
+	zypperPromptCommitContinue = "1"
+	zypperPromptDepResolve     = "5"
+)
+
+type zypperPromptOption struct {
+	Value   string `xml:"value,attr"`
+	Desc    string `xml:"desc,attr"`
+	Default string `xml:"default,attr"`
+}
+
+type zypperPrompt struct {
+	ID      string               `xml:"id,attr"`
+	Text    string               `xml:"text"`
+	Options []zypperPromptOption `xml:"option"`
+}
+
+// PromptAnswer is a prompt of the interactive zypper run and the answer
+// the server gave.
+type PromptAnswer struct {
+	Prompt  string `json:"prompt"`
+	Answer  string `json:"answer"`
+	Problem int    `json:"problem,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type ResolutionResult struct {
+	Action      string          `json:"action"`
+	Packages    []string        `json:"packages,omitempty"`
+	DryRun      bool            `json:"dry_run"`
+	Success     bool            `json:"success"`
+	Problems    []SolverProblem `json:"problems"`
+	Chosen      []string        `json:"chosen_solutions"`
+	Prompts     []PromptAnswer  `json:"prompts"`
+	Plan        TransactionPlan `json:"plan"`
+	NewProblems []string        `json:"new_problems,omitempty"`
+	ExitCode    int             `json:"exit_code"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// problemResolver answers the prompts of an interactive zypper run with
+// the solutions chosen for the problems of the preceding dry-run.
+type problemResolver struct {
+	problems  []SolverProblem
+	solutions []int
+	result    *ResolutionResult
+	aborted   bool
+}
+
+// answer returns the reply to prompt, output is everything since the
+// previous prompt, with the problem and its solutions.
+func (resolver *problemResolver) answer(prompt zypperPrompt, output string) string {
+	text := strings.TrimSpace(prompt.Text)
+	reply := PromptAnswer{Prompt: text}
+	defer func() { resolver.result.Prompts = append(resolver.result.Prompts, reply) }()
+	context := html.UnescapeString(output)
+	switch {
+	case prompt.ID == zypperPromptDepResolve:
+		for i, problem := range resolver.problems {
+			if problem.Description == "" || !strings.Contains(context, problem.Description) {
+				continue
+			}
+			solution := problem.Solutions[resolver.solutions[i]-1]
+			if !strings.Contains(context, solution.Description) {
+				break
+			}
+			reply.Problem = problem.Number
+			reply.Answer = strconv.Itoa(solution.Number)
+			reply.Comment = solution.Description
+			return reply.Answer
+		}
+		resolver.aborted = true
+		if start := strings.LastIndex(output, "<stream>"); start >= 0 {
+			output = output[start+len("<stream>"):]
+		}
+		resolver.result.NewProblems = append(resolver.result.NewProblems, strings.TrimSpace(zypperMessagesText("<stream>"+output+"</stream>")))
+		reply.Answer = "c"
+		reply.Comment = "problem without a chosen solution, cancelled"
+		return reply.Answer
+	case prompt.ID == zypperPromptCommitContinue && !resolver.aborted:
+		reply.Answer = "y"
+		return reply.Answer
+	}
+	resolver.aborted = true
+	reply.Answer = cancelOption(prompt)
+	reply.Comment = "unexpected prompt, cancelled"
+	return reply.Answer
+}
+
+// cancelOption returns the option of prompt which stops zypper without
+// changing anything, keys are rejected and downloads aborted.
+func cancelOption(prompt zypperPrompt) string {
+	text := strings.ToLower(prompt.Text)
+	switch {
+	case strings.Contains(text, "trust"):
+		return "r"
+	case strings.Contains(text, "abort"):
+		return "a"
+	}
+	for _, option := range prompt.Options {
+		if option.Value == "n" {
+			return "n"
+		}
+	}
+	return "c"
+}
+
+// interactiveZypperCmd is zypperCmd without --non-interactive, for runs
+// whose prompts are answered by the server.
+func interactiveZypperCmd() utils.SystemCmd {
+	interactiveCmd := zypperCmd
+	interactiveCmd.DefaultParameters = nil
+	for _, param := range zypperCmd.DefaultParameters {
+		if param != "--non-interactive" {
+			interactiveCmd.DefaultParameters = append(interactiveCmd.DefaultParameters, param)
+		}
+	}
+	return interactiveCmd
+}
+
+// runInteractive runs cmd and answers every <prompt> of its --xmlout
+// output with answer, until the process exits. answer gets the output
+// since the previous prompt as well.
+func runInteractive(cmd *exec.Cmd, answer func(prompt zypperPrompt, output string) string) (utils.CmdResult, error) {
+	var result utils.CmdResult
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return result, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	var output bytes.Buffer
+	promptEnd := 0
+	chunk := make([]byte, 4096)
+	for {
+		n, readErr := stdout.Read(chunk)
+		output.Write(chunk[:n])
+		for {
+			pending := output.String()[promptEnd:]
+			end := strings.Index(pending, "</prompt>")
+			if end < 0 {
+				break
+			}
+			end += len("</prompt>")
+			start := strings.LastIndex(pending[:end], "<prompt")
+			var prompt zypperPrompt
+			reply := "c"
+			if start >= 0 && xml.Unmarshal([]byte(pending[start:end]), &prompt) == nil {
+				reply = answer(prompt, pending[:start])
+			}
+			io.WriteString(stdin, reply+"\n")
+			promptEnd += end
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	stdin.Close()
+	err = cmd.Wait()
+	result.Stdout = output.String()
+	result.Stderr = stderr.String()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return result, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, nil
+}
+
+// ResolveProblems runs the dry-run of action again, and if the solver
+// reports the same problems, carries out the transaction with the chosen
+// solution for each problem, numbered like in the dry-run.
+func ResolveProblems(ctx context.Context, action string, packages []string, solutions []int, dryRun bool) (ResolutionResult, error) {
+	resolution := ResolutionResult{Action: action, Packages: packages, DryRun: dryRun, Chosen: []string{}, Prompts: []PromptAnswer{}}
+	plan, err := PlanTransaction(ctx, action, packages)
+	if err != nil {
+		return resolution, err
+	}
+	resolution.Problems = plan.Problems
+	if len(plan.Problems) == 0 {
+		return resolution, fmt.Errorf("the solver reports no problems for this transaction, use zypper_transaction_plan and the install tools")
+	}
+	if len(solutions) != len(plan.Problems) {
+		return resolution, fmt.Errorf("the solver reports %d problems, but %d solutions were chosen; check the problems with zypper_transaction_plan", len(plan.Problems), len(solutions))
+	}
+	for i, problem := range plan.Problems {
+		if solutions[i] < 1 || solutions[i] > len(problem.Solutions) {
+			return resolution, fmt.Errorf("problem %d has solutions 1 to %d, not %d", problem.Number, len(problem.Solutions), solutions[i])
+		}
+		resolution.Chosen = append(resolution.Chosen, fmt.Sprintf("%d: %s", problem.Number, problem.Solutions[solutions[i]-1].Description))
+	}
+	var extraParams []string
+	if dryRun {
+		extraParams = append(extraParams, "--dry-run")
+	}
+	subcmd, params, err := transactionParams(action, packages, extraParams...)
+	if err != nil {
+		return resolution, err
+	}
+	// the prompts are answered here, so zypper must not assume the defaults
+	cmd, err := utils.NewSystemCmdContext(ctx, interactiveZypperCmd(), true, subcmd, params...)
+	if err != nil {
+		return resolution, err
+	}
+	resolver := &problemResolver{problems: plan.Problems, solutions: solutions, result: &resolution}
+	result, err := runInteractive(cmd, resolver.answer)
+	if err != nil {
+		return resolution, err
+	}
+	resolution.ExitCode = result.ExitCode
+	resolution.Plan = TransactionPlan{Action: action, Packages: packages}
+	if err := parseTransactionPlan(&resolution.Plan, result); err != nil {
+		resolution.Error = err.Error()
+		return resolution, nil
+	}
+	switch result.ExitCode {
+	case zypperExitOK, zypperExitInfRebootNeed, zypperExitInfRestartNeed, zypperExitInfReposSkip:
+		resolution.Success = !resolver.aborted
+	}
+	// the problems in the output are the ones resolved above
+	resolution.Plan.Problems = nil
+	resolution.Plan.Feasible = resolution.Success
+	resolution.Plan.summarize()
+	if !resolution.Success {
+		resolution.Error = strings.TrimSpace(zypperMessagesText(result.Stdout, "error") + "\n" + result.Stderr)
+		if resolver.aborted {
+			utils.MarkCallUnchanged(ctx)
+		}
+		if resolution.Error == "" && resolver.aborted {
+			resolution.Error = "zypper asked something which was not covered by the chosen solutions, nothing was changed"
+		}
+	}
+	return resolution, nil
+}
+
+func addResolveToolToMCPServer() {
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("zypper_resolve_problems", zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		return
+	}
+	mcpToolResolve := mcp.NewTool("zypper_resolve_problems",
+		mcp.WithDescription("Carry out an install, remove, update, patch or dist-upgrade which has solver problems, with one chosen solution per problem. Call zypper_transaction_plan first, it lists the problems and their numbered solutions. The dry-run is repeated, and the transaction only runs if the solver reports the same problems; a problem which was not chosen for cancels it."),
+		mcp.WithString("action", mcp.Required(), mcp.Description("One of install, remove, update, patch, dist-upgrade, as in zypper_transaction_plan")),
+		mcp.WithArray("packages", mcp.Description("The same packages as in zypper_transaction_plan"), mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithArray("solutions", mcp.Required(), mcp.Description("Number of the chosen solution for each problem, in the order of the problems, e.g. [2, 1]"), mcp.Items(map[string]any{"type": "number"})),
+		mcp.WithBoolean("dry_run", mcp.Description("Only show the resulting plan, do not change the system")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolResolve, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		action, _ := req.GetArguments()["action"].(string)
+		var packages []string
+		items, _ := req.GetArguments()["packages"].([]any)
+		for _, item := range items {
+			if name, ok := item.(string); ok && name != "" {
+				packages = append(packages, name)
+			}
+		}
+		var solutions []int
+		items, _ = req.GetArguments()["solutions"].([]any)
+		for _, item := range items {
+			number, ok := item.(float64)
+			if !ok {
+				utils.MarkCallUnchanged(ctx)
+				return mcp.NewToolResultError("solutions must be numbers"), nil
+			}
+			solutions = append(solutions, int(number))
+		}
+		dryRun, _ := req.GetArguments()["dry_run"].(bool)
+		resolution, err := ResolveProblems(ctx, action, packages, solutions, dryRun)
+		if dryRun || err != nil {
+			// only the dry-run, or the checks before the transaction failed
+			utils.MarkCallUnchanged(ctx)
+		}
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(resolution)
+	})
+	utils.RecordRegisteredTool("zypper_resolve_problems")
+	utils.MarkToolMutating("zypper_resolve_problems", zypperCmd.Executable)
+}
@@ -0,0 +1,48 @@
+package zypper
+
+import "testing"
+
+func TestProblemResolverAnswer(t *testing.T) {
+	problems := []SolverProblem{{
+		Number:      1,
+		Description: "nothing provides 'libfoo.so.1' needed by the to be installed bar-1.0-1.x86_64",
+		Solutions: []SolverSolution{
+			{Number: 1, Description: "do not install bar-1.0-1.x86_64"},
+			{Number: 2, Description: "break bar-1.0-1.x86_64 by ignoring some of its dependencies"},
+		},
+	}}
+	problemOutput := "<stream><message type=\"info\">Problem: " + problems[0].Description + "\n Solution 1: " +
+		problems[0].Solutions[0].Description + "\n Solution 2: " + problems[0].Solutions[1].Description + "</message>"
+	for _, tc := range []struct {
+		name    string
+		prompt  zypperPrompt
+		output  string
+		want    string
+		aborted bool
+	}{
+		{"chosen solution", zypperPrompt{ID: zypperPromptDepResolve, Text: "Choose from above solutions by number or cancel"}, problemOutput, "2", false},
+		{"unknown problem", zypperPrompt{ID: zypperPromptDepResolve, Text: "Choose from above solutions by number or cancel"}, "<stream>Problem: something else", "c", true},
+		{"commit", zypperPrompt{ID: zypperPromptCommitContinue, Text: "Continue?", Options: []zypperPromptOption{{Value: "y"}, {Value: "n"}}}, "", "y", false},
+		{"unsigned file", zypperPrompt{ID: "9", Text: "File 'repomd.xml' is not signed. Continue?", Options: []zypperPromptOption{{Value: "y"}, {Value: "n"}}}, "", "n", true},
+		{"signature check", zypperPrompt{ID: "13", Text: "Signature verification failed for file 'repomd.xml'. Continue?", Options: []zypperPromptOption{{Value: "y"}, {Value: "n"}}}, "", "n", true},
+		{"key trust", zypperPrompt{ID: "11", Text: "Do you want to reject the key, trust temporarily, or trust always?"}, "", "r", true},
+		{"download problem", zypperPrompt{ID: "8", Text: "Abort, retry, ignore?"}, "", "a", true},
+		{"license", zypperPrompt{ID: "2", Text: "Do you agree with the terms of the license?", Options: []zypperPromptOption{{Value: "yes"}, {Value: "no"}}}, "", "c", true},
+	} {
+		result := &ResolutionResult{}
+		resolver := &problemResolver{problems: problems, solutions: []int{2}, result: result}
+		if got := resolver.answer(tc.prompt, tc.output); got != tc.want || resolver.aborted != tc.aborted {
+			t.Errorf("%s: answer %q aborted %v, want %q %v", tc.name, got, resolver.aborted, tc.want, tc.aborted)
+		}
+		if len(result.Prompts) != 1 || result.Prompts[0].Answer != tc.want {
+			t.Errorf("%s: prompts %+v", tc.name, result.Prompts)
+		}
+	}
+
+	// after a cancelled prompt the commit is not confirmed
+	resolver := &problemResolver{problems: problems, solutions: []int{1}, result: &ResolutionResult{}}
+	resolver.answer(zypperPrompt{ID: "9", Text: "File 'repomd.xml' is not signed. Continue?"}, "")
+	if got := resolver.answer(zypperPrompt{ID: zypperPromptCommitContinue, Text: "Continue?", Options: []zypperPromptOption{{Value: "y"}, {Value: "n"}}}, ""); got != "n" {
+		t.Errorf("commit after a cancelled prompt answered %q", got)
+	}
+}
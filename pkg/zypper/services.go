@@ -0,0 +1,358 @@
+package zypper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mcp-server-admintasks/pkg/systemctl"
+	"mcp-server-admintasks/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Directories with the modules of the installed kernels
+var kernelModuleDirs = []string{"/usr/lib/modules", "/lib/modules"}
+
+// DeletedFilesProcess is a process of zypper ps, which still uses files
+// deleted or replaced by an update.
+type DeletedFilesProcess struct {
+	PID     int      `json:"pid"`
+	PPID    int      `json:"ppid"`
+	UID     int      `json:"uid"`
+	User    string   `json:"user"`
+	Command string   `json:"command"`
+	Unit    string   `json:"unit,omitempty"`
+	Files   []string `json:"files"`
+}
+
+type UnitRestart struct {
+	Unit        string `json:"unit"`
+	Restarted   bool   `json:"restarted"`
+	ActiveState string `json:"active_state,omitempty"`
+	Skipped     string `json:"skipped,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RestartStatus lists what has to be restarted after updates, and with
+// Restarts the result of restarting the units.
+type RestartStatus struct {
+	Processes      []DeletedFilesProcess `json:"processes"`
+	Units          []string              `json:"units"`
+	NotRestartable []UnitRestart         `json:"not_restartable,omitempty"`
+	RebootRequired bool                  `json:"reboot_required"`
+	RebootReasons  []string              `json:"reboot_reasons,omitempty"`
+	RunningKernel  string                `json:"running_kernel,omitempty"`
+	NewestKernel   string                `json:"newest_kernel,omitempty"`
+	Restarts       []UnitRestart         `json:"restarts,omitempty"`
+	Failed         []string              `json:"failed,omitempty"`
+	Summary        string                `json:"summary"`
+}
+
+// plainZypperCmd is zypperCmd without --xmlout and --terse, for the
+// commands without XML output like ps.
+func plainZypperCmd() utils.SystemCmd {
+	plainCmd := zypperCmd
+	plainCmd.DefaultParameters = nil
+	for _, param := range zypperCmd.DefaultParameters {
+		if param != "--xmlout" && param != "--terse" {
+			plainCmd.DefaultParameters = append(plainCmd.DefaultParameters, param)
+		}
+	}
+	return plainCmd
+}
+
+// parseZypperPS parses the table of zypper ps. It has a row per deleted
+// file, the further files of a process follow in rows with an empty PID.
+func parseZypperPS(output string) []DeletedFilesProcess {
+	processes := []DeletedFilesProcess{}
+	columns := map[string]int{}
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "|") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(columns) == 0 {
+			for i, field := range fields {
+				columns[strings.ToLower(field)] = i
+			}
+			if _, ok := columns["pid"]; !ok {
+				columns = map[string]int{}
+			}
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		file := field("files")
+		if field("pid") == "" {
+			if len(processes) > 0 && file != "" {
+				processes[len(processes)-1].Files = append(processes[len(processes)-1].Files, file)
+			}
+			continue
+		}
+		pid, err := strconv.Atoi(field("pid"))
+		if err != nil {
+			continue
+		}
+		process := DeletedFilesProcess{PID: pid, User: field("user"), Command: field("command"), Files: []string{}}
+		process.PPID, _ = strconv.Atoi(field("ppid"))
+		process.UID, _ = strconv.Atoi(field("uid"))
+		if service := field("service"); service != "" {
+			process.Unit, _ = systemctl.UnitName(service)
+		}
+		if file != "" {
+			process.Files = append(process.Files, file)
+		}
+		processes = append(processes, process)
+	}
+	return processes
+}
+
+// kernelRelease strips the flavor, 6.4.0-150600.23.25-default is
+// 6.4.0-150600.23.25 of flavor default.
+func kernelRelease(release string) (string, string) {
+	dash := strings.LastIndex(release, "-")
+	if dash < 0 {
+		return release, ""
+	}
+	flavor := release[dash+1:]
+	if flavor == "" || strings.ContainsAny(flavor[:1], "0123456789") {
+		return release, ""
+	}
+	return release[:dash], flavor
+}
+
+// newestKernel returns the newest installed kernel of the flavor of the
+// running one, by the directories of the kernel modules.
+func newestKernel(running string) string {
+	_, runningFlavor := kernelRelease(running)
+	newest := ""
+	for _, dir := range kernelModuleDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			release, flavor := kernelRelease(entry.Name())
+			if flavor != runningFlavor {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, entry.Name(), "modules.dep")); err != nil {
+				continue
+			}
+			newestRelease, _ := kernelRelease(newest)
+			if newest == "" || utils.RpmVerCmp(release, newestRelease) > 0 {
+				newest = entry.Name()
+			}
+		}
+		if newest != "" {
+			break
+		}
+	}
+	return newest
+}
+
+// rebootReasons returns why only a reboot replaces what processes use:
+// the kernel, glibc and systemd.
+func rebootReasons(processes []DeletedFilesProcess) []string {
+	reasons := []string{}
+	glibc, systemd := false, false
+	for _, process := range processes {
+		if process.PID == 1 && !systemd {
+			systemd = true
+			reasons = append(reasons, "systemd (PID 1) uses deleted files")
+		}
+		for _, file := range process.Files {
+			base := filepath.Base(file)
+			switch {
+			case !glibc && (strings.HasPrefix(base, "libc.so") || strings.HasPrefix(base, "libc-") || strings.HasPrefix(base, "ld-linux")):
+				glibc = true
+				reasons = append(reasons, "processes use the deleted glibc "+file)
+			case !systemd && strings.HasPrefix(base, "libsystemd-shared"):
+				systemd = true
+				reasons = append(reasons, "processes use the deleted systemd library "+file)
+			}
+		}
+	}
+	return reasons
+}
+
+// restartExclusion returns why unit must not be restarted by the server.
+func restartExclusion(unit string, ownUnit string) string {
+	switch {
+	case unit == ownUnit:
+		return "the MCP server runs in this unit"
+	case strings.HasSuffix(unit, ".scope"):
+		return "scopes of sessions cannot be restarted, the users have to log in again"
+	case strings.HasPrefix(unit, "user@"):
+		return "user manager, the user has to log in again"
+	case strings.HasPrefix(unit, "dbus") || unit == "display-manager.service" || strings.HasPrefix(unit, "systemd-logind"):
+		return "restarting it ends the sessions, reboot instead"
+	}
+	return ""
+}
+
+// ServicesNeedingRestart runs zypper ps and needs-rebooting and checks
+// whether a newer kernel is installed than the one running.
+func ServicesNeedingRestart(ctx context.Context) (RestartStatus, error) {
+	status := RestartStatus{Units: []string{}}
+	result, err := utils.RunSystemCmd(ctx, plainZypperCmd(), true, "ps")
+	if err != nil {
+		return status, err
+	}
+	if result.ExitCode != zypperExitOK {
+		return status, fmt.Errorf("zypper ps failed: %s", strings.TrimSpace(result.Stdout+"\n"+result.Stderr))
+	}
+	status.Processes = parseZypperPS(result.Stdout)
+	ownUnit := systemctl.OwnUnit()
+	units := make(map[string]bool)
+	for _, process := range status.Processes {
+		if process.Unit == "" || units[process.Unit] {
+			continue
+		}
+		units[process.Unit] = true
+		if reason := restartExclusion(process.Unit, ownUnit); reason != "" {
+			status.NotRestartable = append(status.NotRestartable, UnitRestart{Unit: process.Unit, Skipped: reason})
+			continue
+		}
+		status.Units = append(status.Units, process.Unit)
+	}
+	sort.Strings(status.Units)
+
+	status.RebootReasons = rebootReasons(status.Processes)
+	result, err = zypperBackend{}.run(ctx, false, "needs-rebooting")
+	if err == nil && result.ExitCode == zypperExitInfRebootNeed {
+		status.RebootReasons = append(status.RebootReasons, "zypp set the reboot-needed flag after installing a package which requires a reboot")
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		status.RunningKernel = strings.TrimSpace(string(release))
+		status.NewestKernel = newestKernel(status.RunningKernel)
+		switch status.NewestKernel {
+		case "":
+			status.RebootReasons = append(status.RebootReasons, "the modules of the running kernel "+status.RunningKernel+" are not installed anymore")
+		case status.RunningKernel:
+		default:
+			status.RebootReasons = append(status.RebootReasons, "kernel "+status.NewestKernel+" is installed, "+status.RunningKernel+" is running")
+		}
+	}
+	status.RebootRequired = len(status.RebootReasons) > 0
+	status.summarize()
+	return status, nil
+}
+
+func (status *RestartStatus) summarize() {
+	var summary []string
+	summary = append(summary, fmt.Sprintf("%d processes use deleted files, %d units can be restarted", len(status.Processes), len(status.Units)))
+	if len(status.NotRestartable) > 0 {
+		summary = append(summary, fmt.Sprintf("%d units are not restarted by the server", len(status.NotRestartable)))
+	}
+	if len(status.Restarts) > 0 {
+		summary = append(summary, fmt.Sprintf("%d units restarted, %d not active afterwards", len(status.Restarts)-len(status.Failed), len(status.Failed)))
+	}
+	if status.RebootRequired {
+		summary = append(summary, "a reboot is required: "+strings.Join(status.RebootReasons, "; "))
+	} else {
+		summary = append(summary, "no reboot required")
+	}
+	status.Summary = strings.Join(summary, "; ")
+}
+
+// RestartServices restarts the units of ServicesNeedingRestart one by one,
+// only those in units if given, and checks that each is active again.
+func RestartServices(ctx context.Context, units []string, timeout time.Duration) (RestartStatus, error) {
+	status, err := ServicesNeedingRestart(ctx)
+	if err != nil {
+		return status, err
+	}
+	selected := status.Units
+	if len(units) > 0 {
+		selected = nil
+		for _, name := range units {
+			unit, err := systemctl.UnitName(name)
+			if err != nil {
+				return status, err
+			}
+			found := false
+			for _, candidate := range status.Units {
+				found = found || candidate == unit
+			}
+			if !found {
+				return status, fmt.Errorf("%s does not use deleted files or is not restarted by the server", unit)
+			}
+			selected = append(selected, unit)
+		}
+	}
+	status.Restarts = []UnitRestart{}
+	for _, unit := range selected {
+		restart := UnitRestart{Unit: unit}
+		state, err := systemctl.RestartUnit(ctx, unit, timeout)
+		restart.ActiveState = state
+		restart.Restarted = err == nil
+		if err != nil {
+			restart.Error = err.Error()
+		} else if state != "active" {
+			restart.Error = "not active after the restart"
+		}
+		if restart.Error != "" {
+			status.Failed = append(status.Failed, unit)
+		}
+		status.Restarts = append(status.Restarts, restart)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	status.summarize()
+	return status, nil
+}
+
+func addServicesToolsToMCPServer() {
+	mcpToolList := mcp.NewTool("zypper_services_needing_restart",
+		mcp.WithDescription("After updates, list the processes still using deleted files and libraries (zypper ps) with their systemd units, and whether a reboot is required because of the kernel, glibc or systemd."),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolList, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		status, err := ServicesNeedingRestart(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(status)
+	})
+	utils.RecordRegisteredTool("zypper_services_needing_restart")
+
+	mcpToolRestart := mcp.NewTool("zypper_restart_services",
+		mcp.WithDescription("Restart the systemd units using deleted files one by one and check each is active again. Units of sessions, dbus and the MCP server itself are not restarted; a required reboot is reported, never done."),
+		mcp.WithArray("units", mcp.Description("Only these units of zypper_services_needing_restart, default all"), mcp.Items(map[string]any{"type": "string"})),
+		mcp.WithNumber("timeout", mcp.Description("Seconds to wait for each unit to become active, default 30")),
+	)
+	utils.AdminTasksMCPServer.AddTool(mcpToolRestart, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var units []string
+		items, _ := req.GetArguments()["units"].([]any)
+		for _, item := range items {
+			if unit, ok := item.(string); ok && unit != "" {
+				units = append(units, unit)
+			}
+		}
+		timeout := 30 * time.Second
+		if seconds, ok := req.GetArguments()["timeout"].(float64); ok && seconds > 0 {
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		status, err := RestartServices(ctx, units, timeout)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return utils.JSONToolResult(status)
+	})
+	utils.RecordRegisteredTool("zypper_restart_services")
+	utils.MarkToolMutating("zypper_restart_services", "systemctl")
+}
@@ -0,0 +1,50 @@
+package zypper
+
+import (
+	"reflect"
+	"testing"
+)
+
+const zypperPSOutput = `The following running processes use deleted files:
+
+PID  | PPID | UID | User  | Command        | Service       | Files
+-----+------+-----+-------+----------------+---------------+-------------------------------------------
+1    | 0    | 0   | root  | systemd        |               | /usr/lib/systemd/libsystemd-shared-254.so
+     |      |     |       |                |               | /usr/lib64/libc.so.6
+812  | 1    | 0   | root  | sshd           | sshd          | /usr/lib64/libcrypto.so.3
+     |      |     |       |                |               | /usr/lib64/libssl.so.3
+1022 | 1    | 484 | nginx | nginx          | nginx         | /usr/lib64/libc.so.6
+
+You may wish to restart these processes.
+See 'man zypper' for information about the meaning of values in the above table.
+`
+
+func TestParseZypperPS(t *testing.T) {
+	processes := parseZypperPS(zypperPSOutput)
+	want := []DeletedFilesProcess{
+		{PID: 1, PPID: 0, UID: 0, User: "root", Command: "systemd", Files: []string{"/usr/lib/systemd/libsystemd-shared-254.so", "/usr/lib64/libc.so.6"}},
+		{PID: 812, PPID: 1, UID: 0, User: "root", Command: "sshd", Unit: "sshd.service", Files: []string{"/usr/lib64/libcrypto.so.3", "/usr/lib64/libssl.so.3"}},
+		{PID: 1022, PPID: 1, UID: 484, User: "nginx", Command: "nginx", Unit: "nginx.service", Files: []string{"/usr/lib64/libc.so.6"}},
+	}
+	if !reflect.DeepEqual(processes, want) {
+		t.Errorf("processes\n%+v\nwant\n%+v", processes, want)
+	}
+	if processes := parseZypperPS("No processes using deleted files found.\n"); len(processes) != 0 {
+		t.Errorf("processes without table: %+v", processes)
+	}
+}
+
+func TestRebootReasons(t *testing.T) {
+	reasons := rebootReasons(parseZypperPS(zypperPSOutput))
+	want := []string{
+		"systemd (PID 1) uses deleted files",
+		"processes use the deleted glibc /usr/lib64/libc.so.6",
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("reasons %v, want %v", reasons, want)
+	}
+	reasons = rebootReasons([]DeletedFilesProcess{{PID: 700, Files: []string{"/usr/lib/systemd/libsystemd-shared-254.so"}}})
+	if !reflect.DeepEqual(reasons, []string{"processes use the deleted systemd library /usr/lib/systemd/libsystemd-shared-254.so"}) {
+		t.Errorf("systemd library: %v", reasons)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 
+	"mcp-server-admintasks/pkg/pkgmgr"
 	"mcp-server-admintasks/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -24,6 +25,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 	Description:       "Command-line interface to ZYpp system management library (libzypp)",
 	NeedsRootHandling: true,
 	DefaultParameters: []string{"--xmlout", "--terse", "--non-interactive"},
+	Distributions:     []string{"suse"},
 	SubCommands: map[string]utils.SingleSubCmd{
 		"search": {
 			CmdGroup:       "Querying Commands",
@@ -55,6 +57,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"removerepo": {
@@ -63,6 +66,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"renamerepo": {
@@ -71,6 +75,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"modifyrepo": {
@@ -79,6 +84,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"refresh": {
@@ -111,6 +117,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"modifyservice": {
@@ -119,6 +126,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"removeservice": {
@@ -127,6 +135,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"refresh-services": {
@@ -143,6 +152,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "If installation fails adding the INSTALLOPTION '--no-confirm' might help",
 			IsEnabled:      true,
 			IsRootRequired: true,
+			IsMutating:     true,
 			Parameters:     []string{"PATTERN or PACKAGE name", "INSTALLOPTION"},
 		},
 		"remove": {
@@ -151,6 +161,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "If installation fails adding the INSTALLOPTION '--no-confirm' might help",
 			IsEnabled:      true,
 			IsRootRequired: true,
+			IsMutating:     true,
 			Parameters:     []string{"PATTERN or PACKAGE name", "REMOVEOPTION"},
 		},
 		"removeptf": {
@@ -159,6 +170,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"verify": {
@@ -175,6 +187,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"install-new-recommends": {
@@ -183,6 +196,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"update": {
@@ -191,6 +205,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      true,
 			IsRootRequired: true,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"list-updates": {
@@ -206,14 +221,15 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Summary:        "Install needed patches.",
 			Description:    "",
 			IsEnabled:      false,
-			IsRootRequired: false,
+			IsRootRequired: true,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"list-patches": {
 			CmdGroup:       "UpdateManagement Commands",
 			Summary:        "List available patches.",
 			Description:    "",
-			IsEnabled:      false,
+			IsEnabled:      true,
 			IsRootRequired: false,
 			Parameters:     []string{},
 		},
@@ -223,13 +239,14 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"patch-check": {
 			CmdGroup:       "UpdateManagement Commands",
 			Summary:        "Check for patches.",
 			Description:    "",
-			IsEnabled:      false,
+			IsEnabled:      true,
 			IsRootRequired: false,
 			Parameters:     []string{},
 		},
@@ -245,9 +262,9 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			CmdGroup:       "Querying Commands",
 			Summary:        "Show full information for specified patches.",
 			Description:    "",
-			IsEnabled:      false,
+			IsEnabled:      true,
 			IsRootRequired: false,
-			Parameters:     []string{},
+			Parameters:     []string{"PATCH name"},
 		},
 		"pattern-info": {
 			CmdGroup:       "Querying Commands",
@@ -311,6 +328,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"removelock": {
@@ -319,6 +337,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"locks": {
@@ -335,6 +354,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"locales": {
@@ -351,6 +371,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"removelocale": {
@@ -359,6 +380,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"versioncmp": {
@@ -423,6 +445,7 @@ var zypperCmd utils.SystemCmd = utils.SystemCmd{
 			Description:    "",
 			IsEnabled:      false,
 			IsRootRequired: false,
+			IsMutating:     true,
 			Parameters:     []string{},
 		},
 		"system-architecture": {
@@ -449,6 +472,13 @@ func addSingleToolToMCPServer(cmdName string, newCmd utils.SingleSubCmd) {
 	if newCmd.IsEnabled {
 
 		newCmdName := "zypper_" + cmdName
+		if !utils.IsSubCmdAvailable(zypperCmd, newCmdName, newCmd) {
+			return
+		}
+		utils.RecordRegisteredTool(newCmdName)
+		if newCmd.IsMutating {
+			utils.MarkToolMutating(newCmdName, zypperCmd.Executable)
+		}
 
 		var numOfParameters = 0
 		if newCmd.Parameters != nil {
@@ -513,8 +543,29 @@ func addSingleToolToMCPServer(cmdName string, newCmd utils.SingleSubCmd) {
 	}
 }
 
+// addTypedToolToMCPServer leaves out the tools changing the system, if
+// the root file system is read-only.
+func addTypedToolToMCPServer(cmdName string, newCmd utils.SingleSubCmd) {
+	if newCmd.IsEnabled && newCmd.IsMutating && utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("zypper_"+cmdName, zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		return
+	}
+	utils.AddToolToMCPServer(zypperCmd, jsonZypperSubCmds, cmdName, newCmd)
+}
+
 func addToolsToMCPServer() {
 
+	if !utils.IsSubCmdAvailable(zypperCmd, "tool_zypper", utils.SingleSubCmd{}) {
+		return
+	}
+	if utils.DetectedOSRelease.ReadOnlyRoot {
+		utils.RecordSkippedTool("tool_zypper", zypperCmd.Executable, "read-only root file system, use the transactional_update tools")
+		return
+	}
+	utils.RecordRegisteredTool("tool_zypper")
+	// any zypper command can be sent here
+	utils.MarkToolMutating("tool_zypper", zypperCmd.Executable)
+
 	mcpToolZypper := mcp.NewTool("tool_zypper",
 		mcp.WithDescription("Send a single cmd to zypper and get output back in XML (or JSON)"),
 		mcp.WithString("zyppercmd",
@@ -560,7 +611,33 @@ func runTests() {
 	}
 }
 
+// addExtraToolsToMCPServer adds the tools beyond the generic subcommand
+// tools, if the zypper subcommand they are built on is available.
+func addExtraToolsToMCPServer() {
+	for _, extra := range []struct {
+		toolName string
+		subcmd   string
+		add      func()
+	}{
+		{"zypper_history", "search", addHistoryToolsToMCPServer},
+		{"zypper_list_needed_patches", "patch", addPatchToolsToMCPServer},
+		{"zypper_transaction_plan", "install", addPlanToolToMCPServer},
+		{"zypper_resolve_problems", "install", addResolveToolToMCPServer},
+		{"zypper_add_repo", "addrepo", addRepoToolsToMCPServer},
+		{"zypper_add_lock", "addlock", addLockToolsToMCPServer},
+		{"zypper_explain_package", "search", addExplainToolsToMCPServer},
+		{"zypper_services_needing_restart", "ps", addServicesToolsToMCPServer},
+		{"zypper_list_kernels", "purge-kernels", addKernelToolsToMCPServer},
+		{"zypper_dist_upgrade_preflight", "dist-upgrade", addDistUpgradeToolsToMCPServer},
+	} {
+		if utils.IsSubCmdAvailable(zypperCmd, extra.toolName, zypperCmd.SubCommands[extra.subcmd]) {
+			extra.add()
+		}
+	}
+}
+
 func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
+	pkgmgr.RegisterBackend(zypperBackend{})
 	tmpZypperSubCmds, err := json.MarshalIndent(zypperCmd.SubCommands, "", "  ")
 	if err != nil {
 		fmt.Println("Error converting to JSON:", err)
@@ -568,24 +645,17 @@ func INIT(debugMode utils.RunningMode, initMode utils.ToolsInitMode) {
 	}
 	jsonZypperSubCmds = string(tmpZypperSubCmds)
 	switch debugMode {
-	case utils.Production:
-		zypperDebug = false
-		if initMode == utils.Single {
-			addToolsToMCPServer()
-		} else if initMode == utils.Typed {
-			for key := range zypperCmd.SubCommands {
-				utils.AddToolToMCPServer(zypperCmd, jsonZypperSubCmds, key, zypperCmd.SubCommands[key])
-			}
-		}
-	case utils.Debug:
-		zypperDebug = true
+	case utils.Production, utils.Debug:
+		zypperDebug = debugMode == utils.Debug
+		utils.ResolveSystemCmd(zypperCmd)
 		if initMode == utils.Single {
 			addToolsToMCPServer()
 		} else if initMode == utils.Typed {
 			for key := range zypperCmd.SubCommands {
-				utils.AddToolToMCPServer(zypperCmd, jsonZypperSubCmds, key, zypperCmd.SubCommands[key])
+				addTypedToolToMCPServer(key, zypperCmd.SubCommands[key])
 			}
 		}
+		addExtraToolsToMCPServer()
 	case utils.Test:
 		zypperDebug = true
 		runTests()